@@ -18,7 +18,19 @@ type BadgerStore struct {
 var badgerTmpFile = "/tmp/badger.db"
 
 // NewBadger instantiates a new BadgerStore.
+//
+// NewBadger exits the process if the database cannot be opened, use NewBadgerE to get an error instead.
 func NewBadger(opts ...badger.Options) *BadgerStore {
+	store, err := NewBadgerE(opts...)
+	if err != nil {
+		fmt.Printf("failed to open badgerdb: %v", err)
+		os.Exit(1)
+	}
+	return store
+}
+
+// NewBadgerE instantiates a new BadgerStore like NewBadger, but returns an error instead of exiting.
+func NewBadgerE(opts ...badger.Options) (*BadgerStore, error) {
 	store := &BadgerStore{
 		dblock: sync.Mutex{},
 	}
@@ -35,10 +47,9 @@ func NewBadger(opts ...badger.Options) *BadgerStore {
 
 	err := store.open()
 	if err != nil {
-		fmt.Printf("failed to open badgerdb: %v", err)
-		os.Exit(1)
+		return nil, err
 	}
-	return store
+	return store, nil
 }
 
 func (store *BadgerStore) open() error {
@@ -63,10 +74,8 @@ func (store *BadgerStore) Get(key []byte) ([]byte, error) {
 		} else if err != nil {
 			return err
 		}
-		err = item.Value(func(val []byte) error {
-			value = val
-			return nil
-		})
+		// the value is only valid during the transaction, so it is copied
+		value, err = item.ValueCopy(nil)
 		return err
 	})
 
@@ -87,13 +96,84 @@ func (store *BadgerStore) Put(key, value []byte) error {
 	return err
 }
 
-// isReady returns true if the store is ready to use.
-func (store *BadgerStore) isReady() bool {
+// PutBatch sets the value of each key in a single write batch, vals[i] being the value of keys[i]
+func (store *BadgerStore) PutBatch(keys, vals [][]byte) error {
+	if len(keys) != len(vals) {
+		return fmt.Errorf("got %d keys and %d values", len(keys), len(vals))
+	}
+	wb := store.db.NewWriteBatch()
+	defer wb.Cancel()
+	for i := range keys {
+		if err := wb.Set(keys[i], vals[i]); err != nil {
+			return err
+		}
+	}
+	return wb.Flush()
+}
+
+// Delete removes the key from the store
+func (store *BadgerStore) Delete(key []byte) error {
+	err := store.db.Update(func(tx *badger.Txn) error {
+		return tx.Delete(key)
+	})
+	return err
+}
+
+// Has returns true if the key is in the store
+func (store *BadgerStore) Has(key []byte) (bool, error) {
+	var found bool
+	err := store.db.View(func(tx *badger.Txn) error {
+		_, err := tx.Get(key)
+		if err == badger.ErrKeyNotFound {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		found = true
+		return nil
+	})
+	return found, err
+}
+
+// ForEach calls fn for every key in the store, in key order.
+// The key and value are only valid until fn returns.
+func (store *BadgerStore) ForEach(fn func(key, val []byte) error) error {
+	return store.Scan(nil, fn)
+}
+
+// Scan calls fn for every key in the store starting with prefix, in key order.
+// The key and value are only valid until fn returns.
+func (store *BadgerStore) Scan(prefix []byte, fn func(key, val []byte) error) error {
+	return store.db.View(func(tx *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = prefix
+		it := tx.NewIterator(opts)
+		defer it.Close()
+
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			item := it.Item()
+			err := item.Value(func(val []byte) error {
+				return fn(item.Key(), val)
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// IsReady returns true if the store is ready to use.
+func (store *BadgerStore) IsReady() bool {
 	return store.db != nil
 }
 
+// DB returns the underlying database
 func (store *BadgerStore) DB() interface{} {
 	return store.db
 }
 
-var _ Store = (*BadgerStore)(nil)
+var _ ReadyStore = (*BadgerStore)(nil)
+var _ IterableStore = (*BadgerStore)(nil)
+var _ BatchStore = (*BadgerStore)(nil)
+var _ DBStore = (*BadgerStore)(nil)
@@ -57,3 +57,10 @@ func TestBadgerDB_WithOptions(t *testing.T) {
 		}
 	})
 }
+
+func TestNewBadgerE(t *testing.T) {
+	_, err := NewBadgerE(badger.DefaultOptions("/dev/null/badger.db"))
+	if err == nil {
+		t.Errorf("Expected an error when the database cannot be opened, got nil")
+	}
+}
@@ -0,0 +1,66 @@
+package sprout
+
+import (
+	"math/bits"
+	"sync/atomic"
+	"unsafe"
+)
+
+// The bit arrays of the filters are read and written one 64 bit word at a time
+// with atomic operations, so that keys can be added and looked up concurrently
+// without a lock. The filter regions start on a word boundary of the mmaped
+// memory, which is page aligned.
+
+// nativeLittleEndian is true if the host stores the bytes of a word in little endian order
+var nativeLittleEndian = func() bool {
+	x := uint16(1)
+	return *(*byte)(unsafe.Pointer(&x)) == 1
+}()
+
+// bitWord returns the word of mem holding the byte at pos, and the shift of that byte in the word
+func bitWord(mem []byte, pos int) (*uint64, uint) {
+	start := pos &^ 7
+	_ = mem[start+7] // the whole word must be in mem
+
+	shift := uint(pos & 7)
+	if !nativeLittleEndian {
+		shift = 7 - shift
+	}
+	return (*uint64)(unsafe.Pointer(&mem[start])), 8 * shift
+}
+
+// setBit atomically sets the bits of mask in the byte at pos of mem, and reports whether they were already set
+func setBit(mem []byte, pos int, mask byte) (wasSet bool) {
+	word, shift := bitWord(mem, pos)
+	return orWord(word, uint64(mask)<<shift)
+}
+
+// testBit atomically checks if the bits of mask are set in the byte at pos of mem
+func testBit(mem []byte, pos int, mask byte) bool {
+	word, shift := bitWord(mem, pos)
+	want := uint64(mask) << shift
+	return atomic.LoadUint64(word)&want == want
+}
+
+// littleEndianWord returns the word that holds v in little endian byte order in memory
+func littleEndianWord(v uint64) uint64 {
+	if nativeLittleEndian {
+		return v
+	}
+	return bits.ReverseBytes64(v)
+}
+
+// orWord atomically sets the given bits in the word, and reports whether they were already set
+func orWord(word *uint64, set uint64) (wasSet bool) {
+	for {
+		old := atomic.LoadUint64(word)
+
+		// the bits are already set, no need to dirty the page
+		if old&set == set {
+			return true
+		}
+		if atomic.CompareAndSwapUint64(word, old, old|set) {
+			return false
+		}
+	}
+}
@@ -0,0 +1,326 @@
+package sprout
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"math/bits"
+	"os"
+	"sync/atomic"
+)
+
+const (
+	// blockBits is the number of bits per block of a blocked bloom filter, one 64 byte cache line
+	blockBits  = 512
+	blockBytes = blockBits / 8
+	blockWords = blockBytes / 8
+	blockShift = 64 - 9 // the high 9 bits of a hash are a bit in the block
+
+	// maxBlockedHashFns bounds the number of bits set per key in a block
+	maxBlockedHashFns = 32
+)
+
+// BlockedBloomFilter is a bloom filter where all the bits of a key are set in a single
+// 64 byte block, as described by F. Putze, P. Sanders and J. Singler [5].
+//
+// A lookup touches a single cache line of the mmaped file instead of k random pages,
+// at the cost of a few more bits per key for the same false positive rate, since
+// the keys are not spread evenly across the blocks.
+type BlockedBloomFilter struct {
+	// the underlying filter holds the blocks in its mmaped region.
+	// m is the number of blocks, and k the number of bits set per key.
+	bf *BloomFilter
+
+	// offset of the first block in the mmaped region, aligned on a cache line
+	blockOffset int
+}
+
+// NewBlockedBloom creates a new blocked bloom filter.
+// err_rate is the desired false error rate. e.g. 0.001 implies 1 false positive in 1000 lookups
+//
+// capacity is the number of entries intended to be added to the filter
+//
+// database is the persistent store to attach to the filter. can be nil.
+//
+// NewBlockedBloom panics if the filter cannot be created, use NewBlockedBloomE to get an error instead.
+func NewBlockedBloom(opts *BloomOptions) *BlockedBloomFilter {
+	bbf, err := NewBlockedBloomE(opts)
+	if err != nil {
+		log.Panicf("%v", err)
+	}
+	return bbf
+}
+
+// NewBlockedBloomE creates a new blocked bloom filter like NewBlockedBloom, but returns an error instead of panicking.
+func NewBlockedBloomE(opts *BloomOptions) (*BlockedBloomFilter, error) {
+	if opts == nil {
+		opts = &DefaultBloomOptions
+	}
+	if err := validateOptions(opts); err != nil {
+		return nil, err
+	}
+
+	// the indices of a key are always computed from a single hash
+	if opts.FormatVersion != 0 && opts.FormatVersion != formatVersion2 {
+		return nil, fmt.Errorf("%w: blocked bloom filters require format version %d", ErrInvalidOptions, formatVersion2)
+	}
+
+	numBlocks, k := blockedParams(opts.Capacity, opts.Err_rate)
+
+	bf := newBloomFilter(opts)
+	bf.kind = blockedHeaderMagic
+	bf.version = formatVersion2
+	bf.m = numBlocks
+	bf.k = k
+	bf.seeds = bf.seeds[:0]
+	for i := 0; i < k; i++ {
+		bf.seeds = append(bf.seeds, 64<<int64(i+1))
+	}
+
+	// one extra block leaves room to align the blocks on a cache line after the header
+	bf.bit_width = (numBlocks + 1) * blockBytes
+
+	if err := bf.open(opts); err != nil {
+		return nil, err
+	}
+	return newBlockedBloomFilter(bf), nil
+}
+
+// OpenBlockedBloom restores the blocked bloom filter stored in the file at path.
+//
+// database is the persistent store to attach to the filter. can be omitted.
+func OpenBlockedBloom(path string, database ...Store) (*BlockedBloomFilter, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open bloom filter file: %w", err)
+	}
+	h, err := readHeader(file, 0)
+	file.Close()
+	if err != nil {
+		return nil, err
+	}
+	if h.magic != blockedHeaderMagic {
+		return nil, fmt.Errorf("%w: not a blocked bloom filter", ErrInvalidHeader)
+	}
+
+	opts := &BloomOptions{
+		Path:     path,
+		Err_rate: h.errRate,
+		Capacity: int(h.capacity),
+	}
+	if len(database) > 0 {
+		opts.Database = database[0]
+	}
+
+	bf := bloomFromHeader(h, opts.Database)
+	if err := bf.open(opts); err != nil {
+		return nil, err
+	}
+	return newBlockedBloomFilter(bf), nil
+}
+
+func newBlockedBloomFilter(bf *BloomFilter) *BlockedBloomFilter {
+	return &BlockedBloomFilter{
+		bf:          bf,
+		blockOffset: (bf.bitOffset + blockBytes - 1) &^ (blockBytes - 1),
+	}
+}
+
+// blockedParams returns the number of blocks and the number of bits set per key of a
+// blocked bloom filter holding capacity keys with a false positive rate of at most errRate.
+func blockedParams(capacity int, errRate float64) (numBlocks, k int) {
+	// a standard bloom filter of the same error rate is the lower bound of the size
+	bitWidth := float64(capacity) * -math.Log(errRate) / (math.Ln2 * math.Ln2)
+	for {
+		numBlocks = int(math.Ceil(bitWidth / blockBits))
+
+		best := math.Inf(1)
+		for i := 1; i <= maxBlockedHashFns; i++ {
+			if rate := blockedErrorRate(capacity, numBlocks, i); rate < best {
+				best, k = rate, i
+			}
+		}
+		if best <= errRate {
+			return numBlocks, k
+		}
+		bitWidth *= 1.01
+	}
+}
+
+// blockedErrorRate returns the false positive rate of a blocked bloom filter holding n keys.
+//
+// The number of keys in a block follows a Poisson distribution of mean n/numBlocks,
+// and a block holding i keys has the false positive rate of a standard bloom filter of blockBits bits holding i keys.
+func blockedErrorRate(n, numBlocks, k int) float64 {
+	if n == 0 {
+		return 0
+	}
+	lambda := float64(n) / float64(numBlocks)
+
+	rate := 0.0
+	for i := 0; ; i++ {
+		lgamma, _ := math.Lgamma(float64(i + 1))
+		p := math.Exp(float64(i)*math.Log(lambda) - lambda - lgamma)
+		rate += p * math.Pow(1-math.Pow(1-1.0/blockBits, float64(i*k)), float64(k))
+
+		// the remaining blocks are too unlikely to matter
+		if float64(i) > lambda && p < 1e-12 {
+			return rate
+		}
+	}
+}
+
+// blockMasks returns the offset of the block of the key in mem, and the bits of the key in each word of the block
+func (bbf *BlockedBloomFilter) blockMasks(key []byte, masks *[blockWords]uint64) int {
+	bf := bbf.bf
+	h1, h2 := bf.hasher.Sum128(key, uint64(bf.seeds[0]))
+
+	// h1 picks the block, multiplying instead of dividing to map it to [0, m)
+	block, _ := bits.Mul64(h1, uint64(bf.m))
+
+	// h2 seeds a linear congruential generator, whose high bits pick the bits in the block.
+	// Double hashing within a block would only yield a few distinct sets of bits,
+	// which raises the false positive rate of dense blocks.
+	x := h2
+	for i := 0; i < bf.k; i++ {
+		x = x*6364136223846793005 + 1442695040888963407
+		bit := x >> blockShift
+		masks[bit/64] |= 1 << (bit % 64)
+	}
+	return bbf.blockOffset + int(block)*blockBytes
+}
+
+// Add adds the key to the filter.
+// It is safe to call Add and Contains concurrently, the bits are set without taking a lock.
+func (bbf *BlockedBloomFilter) Add(key []byte) error {
+	bf := bbf.bf
+	var masks [blockWords]uint64
+	offset := bbf.blockMasks(key, &masks)
+
+	if err := bf.reserve(1); err != nil {
+		return err
+	}
+
+	// the words of a block are stored in little endian order, like the header
+	for i, mask := range masks {
+		if mask != 0 {
+			word, _ := bitWord(bf.mem, offset+8*i)
+			orWord(word, littleEndianWord(mask))
+		}
+	}
+	bf.storeCount()
+	return nil
+}
+
+// Contains checks if the key exists in the filter
+func (bbf *BlockedBloomFilter) Contains(key []byte) bool {
+	bf := bbf.bf
+	var masks [blockWords]uint64
+	offset := bbf.blockMasks(key, &masks)
+
+	for i, mask := range masks {
+		if mask == 0 {
+			continue
+		}
+		word, _ := bitWord(bf.mem, offset+8*i)
+		want := littleEndianWord(mask)
+		if atomic.LoadUint64(word)&want != want {
+			return false
+		}
+	}
+	return true
+}
+
+// Put adds the key to the filter, and also stores it in the persistent store
+func (bbf *BlockedBloomFilter) Put(key, val []byte) error {
+	if !bbf.bf.hasStore() {
+		return fmt.Errorf("%w, use Add() to add keys", ErrNoStore)
+	}
+
+	if err := bbf.Add(key); err != nil {
+		return err
+	}
+	return bbf.bf.db.Put(key, val)
+}
+
+// Get gets the key from the underlying persistent store
+func (bbf *BlockedBloomFilter) Get(key []byte) []byte {
+	val, err := bbf.GetE(key)
+	if errors.Is(err, ErrNoStore) {
+		log.Panicf("BlockedBloomFilter has no persistent store. Use Contains() instead")
+	}
+	if err != nil {
+		fmt.Printf("Error getting key %s from db: %s\n", key, err)
+		return nil
+	}
+	return val
+}
+
+// GetE gets the key from the underlying persistent store like Get, but returns an error instead of panicking.
+// A nil value is returned if the key is not found.
+func (bbf *BlockedBloomFilter) GetE(key []byte) ([]byte, error) {
+	if !bbf.bf.hasStore() {
+		return nil, fmt.Errorf("%w, use Contains() instead", ErrNoStore)
+	}
+
+	if !bbf.Contains(key) {
+		return nil, nil
+	}
+
+	return bbf.bf.db.Get(key)
+}
+
+// Delete removes the key from the persistent store.
+// Bits cannot be unset in a bloom filter, so Contains may still report the key.
+func (bbf *BlockedBloomFilter) Delete(key []byte) error {
+	return bbf.bf.Delete(key)
+}
+
+// Capacity returns the capacity of the filter
+func (bbf *BlockedBloomFilter) Capacity() int {
+	return bbf.bf.capacity
+}
+
+// Count returns the number of items in the filter
+func (bbf *BlockedBloomFilter) Count() int {
+	return bbf.bf.Count()
+}
+
+// FilterSize returns the size of the blocks in bytes
+func (bbf *BlockedBloomFilter) FilterSize() int {
+	return bbf.bf.bit_width
+}
+
+// EstimatedErrorRate returns the false positive rate of the filter for the number of keys it holds
+func (bbf *BlockedBloomFilter) EstimatedErrorRate() float64 {
+	return blockedErrorRate(bbf.bf.Count(), bbf.bf.m, bbf.bf.k)
+}
+
+// DB returns the underlying persistent store
+func (bbf *BlockedBloomFilter) DB() interface{} {
+	return bbf.bf.DB()
+}
+
+// Clear resets all bits in the filter
+func (bbf *BlockedBloomFilter) Clear() {
+	bbf.bf.Clear()
+}
+
+// ClearE resets all bits in the filter like Clear, but returns an error instead of exiting.
+func (bbf *BlockedBloomFilter) ClearE() error {
+	return bbf.bf.ClearE()
+}
+
+// Stats returns the stats of the filter.
+// M is the number of blocks and K the number of bits set per key.
+func (bbf *BlockedBloomFilter) Stats() BloomFilterStats {
+	return bbf.bf.Stats()
+}
+
+// Close flushes the filter to disk and closes the file handle to the filter
+func (bbf *BlockedBloomFilter) Close() error {
+	return bbf.bf.Close()
+}
+
+var _ KeyValueFilter = (*BlockedBloomFilter)(nil)
@@ -0,0 +1,155 @@
+package sprout
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestBlockedBloomFilter(t *testing.T) {
+	opts := &BloomOptions{
+		Err_rate: 0.01,
+		Capacity: 10000,
+		Path:     "./test.db",
+	}
+	bbf := NewBlockedBloom(opts)
+	defer func() {
+		bbf.Close()
+		os.Remove(opts.Path)
+	}()
+
+	for i := 0; i < opts.Capacity; i++ {
+		if err := bbf.Add([]byte(fmt.Sprintf("foo%d", i))); err != nil {
+			t.Fatalf("Expected no error adding key foo%d, got %v", i, err)
+		}
+	}
+
+	t.Run("should find all added keys", func(t *testing.T) {
+		for i := 0; i < opts.Capacity; i++ {
+			if !bbf.Contains([]byte(fmt.Sprintf("foo%d", i))) {
+				t.Fatalf("Expected key foo%d to be found", i)
+			}
+		}
+	})
+
+	t.Run("false positive rate should be close to the error rate", func(t *testing.T) {
+		found := 0
+		for i := 0; i < 100000; i++ {
+			if bbf.Contains([]byte(fmt.Sprintf("bar%d", i))) {
+				found++
+			}
+		}
+		if rate := float64(found) / 100000; rate > opts.Err_rate*1.5 {
+			t.Errorf("Expected false positive rate to be at most %v, got %v", opts.Err_rate*1.5, rate)
+		}
+		if rate := bbf.EstimatedErrorRate(); rate > opts.Err_rate {
+			t.Errorf("Expected estimated error rate to be at most %v, got %v", opts.Err_rate, rate)
+		}
+	})
+
+	t.Run("should not exceed capacity", func(t *testing.T) {
+		if err := bbf.Add([]byte("baz")); !errors.Is(err, ErrCapacityReached) {
+			t.Errorf("Expected ErrCapacityReached, got %v", err)
+		}
+	})
+
+	t.Run("blocks should be aligned on a cache line", func(t *testing.T) {
+		if bbf.blockOffset%blockBytes != 0 || bbf.blockOffset < bbf.bf.bitOffset {
+			t.Errorf("Expected an aligned block offset after %d, got %d", bbf.bf.bitOffset, bbf.blockOffset)
+		}
+		end := bbf.blockOffset + bbf.bf.m*blockBytes
+		if end > bbf.bf.bitOffset+bbf.bf.bit_width {
+			t.Errorf("Expected the blocks to end before %d, got %d", bbf.bf.bitOffset+bbf.bf.bit_width, end)
+		}
+	})
+
+	t.Run("should be restored from the file", func(t *testing.T) {
+		bbf.Close()
+		var err error
+		bbf, err = OpenBlockedBloom(opts.Path)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if bbf.Count() != opts.Capacity {
+			t.Errorf("Expected count to be %d, got %d", opts.Capacity, bbf.Count())
+		}
+		for i := 0; i < opts.Capacity; i++ {
+			if !bbf.Contains([]byte(fmt.Sprintf("foo%d", i))) {
+				t.Fatalf("Expected key foo%d to be found", i)
+			}
+		}
+		bbf.Close()
+
+		bbf, err = NewBlockedBloomE(opts)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if !bbf.Contains([]byte("foo0")) {
+			t.Errorf("Expected key foo0 to be found")
+		}
+
+		if _, err := OpenBloom(opts.Path); !errors.Is(err, ErrInvalidHeader) {
+			t.Errorf("Expected ErrInvalidHeader opening a blocked filter as a bloom filter, got %v", err)
+		}
+	})
+}
+
+func TestNewBlockedBloomE(t *testing.T) {
+	defer os.Remove("./test.db")
+
+	_, err := NewBlockedBloomE(&BloomOptions{Err_rate: 0.01, Capacity: 1000, Path: "./test.db", FormatVersion: formatVersion1})
+	if !errors.Is(err, ErrInvalidOptions) {
+		t.Errorf("Expected ErrInvalidOptions with format version 1, got %v", err)
+	}
+
+	bbf, err := NewBlockedBloomE(&BloomOptions{Err_rate: 0.01, Capacity: 1000, Path: "./test.db"})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	bbf.Close()
+
+	_, err = NewBlockedBloomE(&BloomOptions{Err_rate: 0.001, Capacity: 1000, Path: "./test.db"})
+	if !errors.Is(err, ErrHeaderMismatch) {
+		t.Errorf("Expected ErrHeaderMismatch, got %v", err)
+	}
+}
+
+func TestBlockedParams(t *testing.T) {
+	for _, errRate := range []float64{0.1, 0.01, 0.001, 0.0001, 0.000001} {
+		for _, capacity := range []int{100, 10000, 1000000} {
+			numBlocks, k := blockedParams(capacity, errRate)
+			if rate := blockedErrorRate(capacity, numBlocks, k); rate > errRate {
+				t.Errorf("Expected error rate %v with capacity %d, got %v", errRate, capacity, rate)
+			}
+
+			// blocking costs space over a standard bloom filter, but not too much
+			bf := newBloomFilter(&BloomOptions{Err_rate: errRate, Capacity: capacity})
+			ratio := float64(numBlocks*blockBits) / float64(bf.k*bf.m)
+			if ratio < 1 || ratio > 1.6 {
+				t.Errorf("Expected a blocked filter slightly larger than a standard filter for error rate %v, got ratio %v", errRate, ratio)
+			}
+		}
+	}
+}
+
+func TestBlockedBloomFilter_Allocs(t *testing.T) {
+	opts := &BloomOptions{
+		Err_rate: 0.001,
+		Capacity: 1000,
+		Path:     "./test.db",
+	}
+	bbf := NewBlockedBloom(opts)
+	defer func() {
+		bbf.Close()
+		os.Remove(opts.Path)
+	}()
+
+	key := []byte("https://example.com/some/path?query=1")
+	if allocs := testing.AllocsPerRun(100, func() { bbf.Add(key) }); allocs != 0 {
+		t.Errorf("Expected Add to not allocate, got %v allocations", allocs)
+	}
+	if allocs := testing.AllocsPerRun(100, func() { bbf.Contains(key) }); allocs != 0 {
+		t.Errorf("Expected Contains to not allocate, got %v allocations", allocs)
+	}
+}
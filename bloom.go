@@ -1,11 +1,14 @@
 package sprout
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"math"
 	"os"
 	"sync"
+	"sync/atomic"
+	"time"
 	"unsafe"
 
 	"github.com/dsa0x/sprout/pkg/murmur"
@@ -27,13 +30,14 @@ type BloomFilter struct {
 	// persistent storage
 	db Store
 
-	// the number of items added to the bloom filter
-	count int
+	// the number of items added to the bloom filter, accessed atomically
+	count int64
 
 	memFile    *os.File
 	mem        mmap.MMap
 	pageOffset int
-	lock       sync.Mutex
+	bitOffset  int // offset of the bit array in mem, after the header
+	lock       sync.RWMutex
 	flock      *fslock.Lock
 	byteSize   int
 
@@ -46,6 +50,19 @@ type BloomFilter struct {
 	// one seed per hash function
 	seeds []int64
 
+	// kind is the type of filter held in the region, recorded as the header magic
+	kind string
+
+	// hasher computes the indices of the keys
+	hasher Hasher
+
+	// version is the format version of the filter, which selects how the indices of a key are computed
+	version int
+
+	// cellBits is the number of bits per cell of the filter array,
+	// 1 for a bloom filter and the counter size for a counting bloom filter
+	cellBits int
+
 	path string
 	opts *BloomOptions
 }
@@ -68,6 +85,25 @@ type BloomOptions struct {
 	// growth rate of the bloom filter (valid values are 2 and 4)
 	GrowthRate GrowthRate
 
+	// the format version of a new filter, defaults to version 2.
+	// Version 1 hashes the key once per hash function, version 2 computes all the indices from a single hash.
+	// Version 3 sizes the filter and computes the indices like RedisBloom, see DumpRedis.
+	FormatVersion int
+
+	// the hash function of a new filter, defaults to HasherMurmur3, or HasherMurmur2 for format version 3
+	Hasher Hasher
+
+	// the number of bits per counter of a counting bloom filter (valid values are 4, 8 and 16),
+	// or per cell of a stable bloom filter (valid values are 1, 2 and 4)
+	CounterBits int
+
+	// the number of generations of a sliding bloom filter, defaults to 4
+	Generations int
+
+	// the age of the newest generation of a sliding bloom filter after which a new generation is started.
+	// 0 only starts a new generation when the newest one is full.
+	RotateEvery time.Duration
+
 	dataSize int
 }
 
@@ -85,17 +121,61 @@ var DefaultBloomOptions = BloomOptions{
 // capacity is the number of entries intended to be added to the filter
 //
 // database is the persistent store to attach to the filter. can be nil.
+//
+// If the file at opts.Path already holds a filter created with the same options, it is restored.
+//
+// NewBloom panics if the filter cannot be created, use NewBloomE to get an error instead.
 func NewBloom(opts *BloomOptions) *BloomFilter {
+	bf, err := NewBloomE(opts)
+	if err != nil {
+		log.Panicf("%v", err)
+	}
+	return bf
+}
+
+// NewBloomE creates a new bloom filter like NewBloom, but returns an error instead of panicking.
+// The error wraps ErrInvalidOptions if the options are not valid, and ErrFileLocked if
+// the filter file is in use by another filter.
+func NewBloomE(opts *BloomOptions) (*BloomFilter, error) {
 	if opts == nil {
 		opts = &DefaultBloomOptions
 	}
+	if err := validateOptions(opts); err != nil {
+		return nil, err
+	}
+
+	bf := newBloomFilter(opts)
+	if err := bf.open(opts); err != nil {
+		return nil, err
+	}
+
+	return bf, nil
+}
+
+// validateOptions checks the error rate and capacity of the options
+func validateOptions(opts *BloomOptions) error {
 	if opts.Err_rate <= 0 || opts.Err_rate >= 1 {
-		panic("Error rate must be between 0 and 1")
+		return fmt.Errorf("%w: error rate must be between 0 and 1", ErrInvalidOptions)
 	}
 	if opts.Capacity <= 10 {
-		panic("Capacity must be greater than 10")
+		return fmt.Errorf("%w: capacity must be greater than 10", ErrInvalidOptions)
 	}
+	return validateFormat(opts)
+}
+
+// validateFormat checks the format version and the hasher of the options
+func validateFormat(opts *BloomOptions) error {
+	if opts.FormatVersion < 0 || opts.FormatVersion > maxFormatVersion {
+		return fmt.Errorf("%w: unknown format version %d", ErrInvalidOptions, opts.FormatVersion)
+	}
+	if opts.FormatVersion == formatVersion3 && opts.Hasher != nil && opts.Hasher.ID() != hashMurmur2 {
+		return fmt.Errorf("%w: format version %d requires HasherMurmur2", ErrInvalidOptions, formatVersion3)
+	}
+	return nil
+}
 
+// newBloomFilter derives the parameters of the filter from the options, without opening the filter file
+func newBloomFilter(opts *BloomOptions) *BloomFilter {
 	// number of hash functions (k)
 	numHashFn := int(math.Ceil(math.Log2(1.0 / opts.Err_rate)))
 
@@ -113,10 +193,6 @@ func NewBloom(opts *BloomOptions) *BloomFilter {
 		seeds[i] = 64 << int64((i + 1))
 	}
 
-	if opts.Path == "" {
-		opts.Path = "/tmp/bloom.db"
-	}
-
 	var b byte
 	byteSize := int(unsafe.Sizeof(&b))
 
@@ -124,23 +200,124 @@ func NewBloom(opts *BloomOptions) *BloomFilter {
 	bit_width /= byteSize
 	bit_width += byteSize // add extra 1 byte to ensure we have a full byte at the end
 
-	pageOffset := opts.dataSize
-	opts.dataSize += bit_width // will be the offset of the next filter
+	version := newFormatVersion(opts)
+	if version == formatVersion3 {
+		// a single slice of the size RedisBloom would allocate
+		numHashFn, bits_per_slice, bit_width = redisParams(opts.Capacity, opts.Err_rate)
+		seeds = redisSeeds(numHashFn)
+	}
+
+	return &BloomFilter{
+		err_rate:  opts.Err_rate,
+		capacity:  opts.Capacity,
+		bit_width: bit_width,
+		m:         bits_per_slice,
+		seeds:     seeds,
+		db:        opts.Database,
+		lock:      sync.RWMutex{},
+		byteSize:  byteSize,
+		k:         numHashFn,
+		kind:      headerMagic,
+		cellBits:  1,
+		version:   version,
+		hasher:    newHasher(opts),
+	}
+}
+
+// newHasher returns the hasher of a new filter
+func newHasher(opts *BloomOptions) Hasher {
+	if opts.Hasher == nil && opts.FormatVersion == formatVersion3 {
+		return HasherMurmur2
+	}
+	if opts.Hasher == nil {
+		return HasherMurmur3
+	}
+	return opts.Hasher
+}
 
-	bf := &BloomFilter{
-		err_rate:   opts.Err_rate,
-		capacity:   opts.Capacity,
-		bit_width:  bit_width,
-		m:          bits_per_slice,
-		seeds:      seeds,
-		db:         opts.Database,
-		lock:       sync.Mutex{},
-		byteSize:   byteSize,
-		path:       opts.Path,
-		k:          numHashFn,
-		pageOffset: pageOffset,
-		opts:       opts,
+// newFormatVersion returns the format version of a new filter
+func newFormatVersion(opts *BloomOptions) int {
+	if opts.FormatVersion == 0 {
+		return formatVersion
 	}
+	return opts.FormatVersion
+}
+
+// bloomFromHeader creates the filter described by the header, without opening the filter file
+func bloomFromHeader(h *header, database Store) *BloomFilter {
+	var b byte
+	return &BloomFilter{
+		err_rate:  h.errRate,
+		capacity:  int(h.capacity),
+		bit_width: int(h.bitWidth),
+		m:         int(h.m),
+		seeds:     h.seeds,
+		db:        database,
+		lock:      sync.RWMutex{},
+		byteSize:  int(unsafe.Sizeof(&b)),
+		k:         int(h.k),
+		count:     int64(h.count),
+		kind:      h.magic,
+		cellBits:  int(h.cellBits),
+		version:   int(h.version),
+		hasher:    hasherByID(h.hash),
+	}
+}
+
+// OpenBloom restores the bloom filter stored in the file at path.
+// The parameters of the filter are read from the file, so they do not need to be known.
+//
+// database is the persistent store to attach to the filter. can be omitted.
+func OpenBloom(path string, database ...Store) (*BloomFilter, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open bloom filter file: %w", err)
+	}
+	h, err := readHeader(file, 0)
+	file.Close()
+	if err != nil {
+		return nil, err
+	}
+	if h.magic != headerMagic {
+		return nil, fmt.Errorf("%w: not a bloom filter", ErrInvalidHeader)
+	}
+
+	opts := &BloomOptions{
+		Path:     path,
+		Err_rate: h.errRate,
+		Capacity: int(h.capacity),
+	}
+	if len(database) > 0 {
+		opts.Database = database[0]
+	}
+
+	bf := bloomFromHeader(h, opts.Database)
+	if err := bf.open(opts); err != nil {
+		return nil, err
+	}
+	return bf, nil
+}
+
+// setRegion places the filter in the file region starting at opts.dataSize
+func (bf *BloomFilter) setRegion(opts *BloomOptions) {
+	// copy the options, so that the caller's options can be reused
+	o := *opts
+	if o.Path == "" {
+		o.Path = "/tmp/bloom.db"
+	}
+
+	headerSize := headerSize(bf.k)
+	bf.path = o.Path
+	bf.pageOffset = o.dataSize
+	bf.bitOffset = bf.pageOffset + headerSize
+	o.dataSize += alignSize(headerSize + bf.bit_width) // will be the offset of the next filter
+	bf.opts = &o
+}
+
+// open opens, locks and maps the region of the filter file described by opts.
+// The filter is restored from the file header if the region already exists.
+func (bf *BloomFilter) open(opts *BloomOptions) error {
+	bf.setRegion(opts)
 
 	// initialize advisory lock
 	bf.flock = fslock.New(bf.path)
@@ -148,68 +325,193 @@ func NewBloom(opts *BloomOptions) *BloomFilter {
 	// open the file
 	err := bf.openFile()
 	if err != nil {
-		log.Panicf("Error opening file: %v", err)
+		return fmt.Errorf("Error opening file: %w", err)
 	}
 
+	if err := bf.mapRegion(); err != nil {
+		bf.release()
+		return err
+	}
+	return nil
+}
+
+// mapRegion maps the region of the opened filter file, and restores the filter from its header
+func (bf *BloomFilter) mapRegion() error {
+	fi, err := bf.memFile.Stat()
+	if err != nil {
+		return fmt.Errorf("Error opening file: %w", err)
+	}
+	existing := fi.Size() > int64(bf.pageOffset)
+
 	// open mmap the file
 	err = bf.mmap()
 	if err != nil {
-		log.Panicf("Mmap error: %v", err)
+		return fmt.Errorf("Mmap error: %w", err)
 	}
 
-	return bf
+	if err := bf.loadHeader(existing); err != nil {
+		_ = bf.unmap()
+		return err
+	}
+	return nil
 }
 
-// Add adds the key to the bloom filter
+// Add adds the key to the bloom filter.
+// It is safe to call Add and Contains concurrently, the bits are set without taking a lock.
+// Add and Contains do not allocate, unless the filter has more than 32 hash functions.
 func (bf *BloomFilter) Add(key []byte) error {
+	var buf [maxStackIndices]uint64
+	indices := bf.appendCandidates(buf[:0], key)
+
+	if err := bf.reserve(1); err != nil {
+		return err
+	}
+	if _, err := bf.setBits(indices); err != nil {
+		atomic.AddInt64(&bf.count, -1)
+		return err
+	}
+	bf.storeCount()
+	return nil
+}
+
+// reserve atomically counts n new items, unless the filter would exceed its capacity
+func (bf *BloomFilter) reserve(n int) error {
+	for {
+		count := atomic.LoadInt64(&bf.count)
+		if count+int64(n) > int64(bf.capacity) {
+			return fmt.Errorf("%w: %d", ErrCapacityReached, bf.capacity)
+		}
+		if atomic.CompareAndSwapInt64(&bf.count, count, count+int64(n)) {
+			return nil
+		}
+	}
+}
+
+// AddIfAbsent adds the key to the bloom filter if it is not already in the filter.
+// The check and the insertion are done atomically, so that concurrent callers
+// adding the same key do not both see it as new.
+//
+// added is false if the key was probably already in the filter.
+func (bf *BloomFilter) AddIfAbsent(key []byte) (added bool, err error) {
+	var buf [maxStackIndices]uint64
+	indices := bf.appendCandidates(buf[:0], key)
+
+	// the lock serialises AddIfAbsent, Add sets the bits without it.
+	// The key is new if at least one of its bits was not set yet when it was set here,
+	// so that a key a concurrent Add has just set is not reported as new.
 	bf.lock.Lock()
 	defer bf.lock.Unlock()
 
-	indices := bf.candidates(string(key))
+	if bf.contains(indices) {
+		return false, nil
+	}
+	if err := bf.reserve(1); err != nil {
+		return false, err
+	}
+	wasSet, err := bf.setBits(indices)
+	if err != nil || wasSet {
+		atomic.AddInt64(&bf.count, -1)
+		return false, err
+	}
+	bf.storeCount()
+	return true, nil
+}
 
-	if bf.count >= bf.capacity {
-		return fmt.Errorf("BloomFilter has reached full capacity %d", bf.capacity)
+// AddBatch adds the keys to the bloom filter.
+// All the keys are hashed first, and the count of the filter is only updated once.
+// If the keys do not fit in the filter, none of them is added.
+func (bf *BloomFilter) AddBatch(keys [][]byte) error {
+	indices := make([]uint64, 0, len(keys)*bf.k)
+	for _, key := range keys {
+		indices = bf.appendCandidates(indices, key)
 	}
 
+	if err := bf.reserve(len(keys)); err != nil {
+		return err
+	}
+	if _, err := bf.setBits(indices); err != nil {
+		atomic.AddInt64(&bf.count, -int64(len(keys)))
+		return err
+	}
+	bf.storeCount()
+	return nil
+}
+
+// setBits atomically sets the bits at the given indices, and reports whether they were all already set
+func (bf *BloomFilter) setBits(indices []uint64) (wasSet bool, err error) {
+	wasSet = true
 	for i := 0; i < len(indices); i++ {
 		idx, mask := bf.getBitIndexN(indices[i])
 
-		if int(idx) >= len(bf.mem) {
-			return fmt.Errorf("Error finding key: Index out of bounds")
+		if int(idx) >= bf.bit_width {
+			return false, fmt.Errorf("Error finding key: Index out of bounds")
 		}
 
 		// set the bit at mask position of the byte at idx
 		// e.g. if idx = 2 and mask = 01000000, set the bit at 2nd position of byte 2
-		bf.mem[idx] |= mask
+		if !setBit(bf.mem, bf.bitOffset+int(idx), mask) {
+			wasSet = false
+		}
 	}
-	bf.count++
-	return nil
+	return wasSet, nil
 }
 
 // Put adds the key to the bloom filter, and also stores it in the persistent store
 func (bf *BloomFilter) Put(key, val []byte) error {
 	if !bf.hasStore() {
-		return fmt.Errorf("BloomFilter does not have a store, use Add() to add keys")
+		return fmt.Errorf("%w, use Add() to add keys", ErrNoStore)
 	}
 
-	bf.Add(key)
+	if err := bf.Add(key); err != nil {
+		return err
+	}
 	return bf.db.Put([]byte(key), val)
 }
 
+// PutBatch adds the keys to the bloom filter, and stores their values in the persistent store in a single transaction.
+// vals[i] is the value of keys[i].
+func (bf *BloomFilter) PutBatch(keys, vals [][]byte) error {
+	if !bf.hasStore() {
+		return fmt.Errorf("%w, use AddBatch() to add keys", ErrNoStore)
+	}
+	if len(keys) != len(vals) {
+		return fmt.Errorf("got %d keys and %d values", len(keys), len(vals))
+	}
+
+	if err := bf.AddBatch(keys); err != nil {
+		return err
+	}
+	return putBatch(bf.db, keys, vals)
+}
+
 // Contains checks if the key exists in the bloom filter
 func (bf *BloomFilter) Contains(key []byte) bool {
-	indices := bf.candidates(string(key))
+	var buf [maxStackIndices]uint64
+	return bf.contains(bf.appendCandidates(buf[:0], key))
+}
+
+// ContainsBatch checks if each of the keys exists in the bloom filter.
+// The i-th result is the result of Contains(keys[i]).
+func (bf *BloomFilter) ContainsBatch(keys [][]byte) []bool {
+	res := make([]bool, len(keys))
+	var buf [maxStackIndices]uint64
+	for i, key := range keys {
+		indices := bf.appendCandidates(buf[:0], key)
+		res[i] = bf.contains(indices)
+	}
+	return res
+}
 
+// contains checks if the bits at the given indices are all set
+func (bf *BloomFilter) contains(indices []uint64) bool {
 	for i := 0; i < len(indices); i++ {
 		idx, mask := bf.getBitIndexN(indices[i])
 
-		if int(idx) >= len(bf.mem) {
+		if int(idx) >= bf.bit_width {
 			return false
 		}
-		bit := bf.mem[idx]
-
 		// check if the mask part of the bit is set
-		if bit&mask == 0 {
+		if !testBit(bf.mem, bf.bitOffset+int(idx), mask) {
 			return false
 		}
 	}
@@ -218,32 +520,46 @@ func (bf *BloomFilter) Contains(key []byte) bool {
 
 // Get gets the key from the underlying persistent store
 func (bf *BloomFilter) Get(key []byte) []byte {
-	if !bf.hasStore() {
+	val, err := bf.GetE(key)
+	if errors.Is(err, ErrNoStore) {
 		log.Panicf("BloomFilter has no persistent store. Use Contains() instead")
 	}
-
-	if !bf.Contains(key) {
-		return nil
-	}
-
-	val, err := bf.db.Get(key)
 	if err != nil {
 		fmt.Printf("Error getting key %s from db: %s\n", key, err)
 		return nil
 	}
 	return val
+}
+
+// GetE gets the key from the underlying persistent store like Get, but returns an error instead of panicking.
+// A nil value is returned if the key is not found.
+func (bf *BloomFilter) GetE(key []byte) ([]byte, error) {
+	if !bf.hasStore() {
+		return nil, fmt.Errorf("%w, use Contains() instead", ErrNoStore)
+	}
+
+	if !bf.Contains(key) {
+		return nil, nil
+	}
+
+	return bf.db.Get(key)
+}
 
+// Delete removes the key from the persistent store.
+// Bits cannot be unset in a bloom filter, so Contains may still report the key.
+func (bf *BloomFilter) Delete(key []byte) error {
+	if !bf.hasStore() {
+		return fmt.Errorf("%w, keys cannot be removed from a bloom filter", ErrNoStore)
+	}
+	return bf.db.Delete(key)
 }
 
 // Merge merges the filter with another bloom filter.
-// Both filters must have the same capacity and error rate.
+// Both filters must have the same capacity, error rate, format version and hasher, or ErrHeaderMismatch is returned.
 // merging increases the false positive rate of the resulting filter
 func (bf *BloomFilter) Merge(bf2 *BloomFilter) error {
-	if bf.k != bf2.k {
-		return fmt.Errorf("BloomFilter k values do not match")
-	}
-	if bf.bit_width != bf2.bit_width {
-		return fmt.Errorf("BloomFilter bit_width values do not match")
+	if err := bf.checkMergeable(bf2.header()); err != nil {
+		return err
 	}
 
 	bf.lock.Lock()
@@ -252,15 +568,27 @@ func (bf *BloomFilter) Merge(bf2 *BloomFilter) error {
 	bf2.lock.Lock()
 	defer bf2.lock.Unlock()
 
-	for i := 0; i < bf.bit_width; i++ {
-		bf.mem[i] |= bf2.mem[i]
+	// the bit arrays are padded with zeros to a whole number of words
+	for i := 0; i < bf.bit_width; i += 8 {
+		word, _ := bitWord(bf.mem, bf.bitOffset+i)
+		word2, _ := bitWord(bf2.mem, bf2.bitOffset+i)
+		orWord(word, atomic.LoadUint64(word2))
 	}
 
 	return nil
 }
 
+// checkMergeable checks that the filter described by h sets the same bits as the filter for the same keys
+func (bf *BloomFilter) checkMergeable(h *header) error {
+	if !bf.header().matches(h) || int(h.version) != bf.version || h.hash != bf.hasher.ID() {
+		return fmt.Errorf("%w: filter has capacity %d, error rate %v, format version %d and hasher %d",
+			ErrHeaderMismatch, h.capacity, h.errRate, h.version, h.hash)
+	}
+	return nil
+}
+
 func (bf *BloomFilter) hasStore() bool {
-	return bf.db != nil && bf.db.isReady()
+	return storeReady(bf.db)
 }
 
 // getBitIndex returns the index and mask for the bit. (unused)
@@ -281,34 +609,85 @@ func (bf *BloomFilter) getBitIndex(idx uint64) (uint64, byte) {
 
 // getBitIndexN returns the index and mask for the bit.
 func (bf *BloomFilter) getBitIndexN(idx uint64) (uint64, byte) {
-	quot, rem := divmod(int64(idx), int64(bf.byteSize))
+	byteSize := uint64(bf.byteSize)
+	quot, rem := idx/byteSize, idx%byteSize
 
 	// shift the mask to the right by the remainder to get the bit index in the byte
 	// if byteSize = 8,
 	// 128 = 0x80 = 1000 0000, 128 >> 2 = 64.....and so on
 	// 1000 0000 >> 2 = 0100 0000
-	byteSizeInDec := int64(math.Pow(2, float64(bf.byteSize)-1))
-	shift := byte((byteSizeInDec >> rem)) // 128 >> 1,2..
+	shift := byte((1 << (byteSize - 1)) >> rem) // 128 >> 1,2..
 
-	return uint64(quot), shift
+	return quot, shift
 }
 
+// maxStackIndices is the number of index candidates of a key that Add and Contains keep on the stack.
+// Filters with more hash functions than that allocate the candidates on the heap.
+const maxStackIndices = 32
+
 // candidates uses the hash function to get all index candidates of the given key
-func (bf *BloomFilter) candidates(key string) []uint64 {
-	res := make([]uint64, 0, len(bf.seeds))
+func (bf *BloomFilter) candidates(key []byte) []uint64 {
+	return bf.appendCandidates(make([]uint64, 0, len(bf.seeds)), key)
+}
+
+// appendCandidates appends the index candidates of the given key to dst
+func (bf *BloomFilter) appendCandidates(dst []uint64, key []byte) []uint64 {
+	switch bf.version {
+	case formatVersion2:
+		return bf.appendDoubleHashCandidates(dst, key)
+	case formatVersion3:
+		return bf.appendRedisCandidates(dst, key)
+	}
+
 	for i, seed := range bf.seeds {
-		hash := getHash(key, seed)
+		hash := bf.hasher.Sum64(key, uint64(seed))
 		// each hash produces an index over m for its respective slice.
 		// e.g. 0-140, 140-280, 280-420
 		idx := uint64(i*bf.m) + getBucketIndex(hash, uint64(bf.m))
-		res = append(res, idx)
+		dst = append(dst, idx)
 	}
-	return res
+	return dst
+}
+
+// appendDoubleHashCandidates appends the index candidates of the given key to dst,
+// computing all of them from the two halves of a single 128-bit hash with the enhanced double hashing
+// of Kirsch and Mitzenmacher [4]: g_i(x) = h1(x) + i*h2(x) + (i^3-i)/6 mod m
+func (bf *BloomFilter) appendDoubleHashCandidates(dst []uint64, key []byte) []uint64 {
+	m := uint64(bf.m)
+	h1, h2 := bf.hasher.Sum128(key, uint64(bf.seeds[0]))
+	x, y := h1%m, h2%m
+	for i := 0; i < bf.k; i++ {
+		// each index still falls in the slice of its hash function
+		dst = append(dst, uint64(i*bf.m)+x)
+
+		// x and y stay below m, and i below k <= m, so a subtraction replaces the modulo
+		x += y
+		if x >= m {
+			x -= m
+		}
+		y += uint64(i)
+		if y >= m {
+			y -= m
+		}
+	}
+	return dst
+}
+
+// appendRedisCandidates appends the index candidates of the given key to dst like RedisBloom does,
+// with the double hashing g_i(x) = h1(x) + i*h2(x) mod m over the whole bit array.
+// RedisBloom numbers the bits of a byte from the least significant one, so the index of the bit in its byte is reversed.
+func (bf *BloomFilter) appendRedisCandidates(dst []uint64, key []byte) []uint64 {
+	m := uint64(bf.m)
+	h1, h2 := bf.hasher.Sum128(key, uint64(bf.seeds[0]))
+	for i := uint64(0); i < uint64(bf.k); i++ {
+		dst = append(dst, ((h1+i*h2)%m)^7)
+	}
+	return dst
 }
 
 // getHash returns the non-cryptographic murmur hash of the key seeded with the given seed
-func getHash(key string, seed int64) uint64 {
-	hash := murmur.Murmur3_64([]byte(key), uint64(seed))
+func getHash(key []byte, seed int64) uint64 {
+	hash := murmur.Murmur3_64(key, uint64(seed))
 	return hash
 }
 
@@ -347,7 +726,7 @@ func (bf *BloomFilter) Close() error {
 
 // Count returns the number of items added to the bloom filter
 func (bf *BloomFilter) Count() int {
-	return bf.count
+	return int(atomic.LoadInt64(&bf.count))
 }
 
 // FilterSize returns the size of the bloom filter
@@ -357,19 +736,36 @@ func (bf *BloomFilter) FilterSize() int {
 
 // DB returns the underlying persistent store
 func (bf *BloomFilter) DB() interface{} {
-	return bf.db.DB()
+	return storeDB(bf.db)
 }
 
 // Clear resets all bits in the bloom filter
 func (bf *BloomFilter) Clear() {
-	mem := make([]byte, bf.bit_width)
-	copy(bf.mem, mem)
-	err := bf.mem.Flush()
+	err := bf.ClearE()
 	if err != nil {
 		fmt.Printf("Error flushing filter to disk: %s\n", err)
 		os.Exit(1)
 	}
-	bf.count = 0
+}
+
+// ClearE resets all bits in the bloom filter like Clear, but returns an error
+// if the filter cannot be flushed to disk instead of exiting.
+func (bf *BloomFilter) ClearE() error {
+	bf.lock.Lock()
+	defer bf.lock.Unlock()
+
+	bf.reset()
+	return bf.mem.Flush()
+}
+
+// reset resets all bits and the count of the filter, without flushing them to disk
+func (bf *BloomFilter) reset() {
+	for i := 0; i < bf.bit_width; i += 8 {
+		word, _ := bitWord(bf.mem, bf.bitOffset+i)
+		atomic.StoreUint64(word, 0)
+	}
+	atomic.StoreInt64(&bf.count, 0)
+	bf.storeCount()
 }
 
 type BloomFilterStats struct {
@@ -381,13 +777,17 @@ type BloomFilterStats struct {
 
 	// Prob is the error probability of the filter
 	Prob float64
+
+	// FalseNegativeProb is the probability that a key added Capacity keys ago is not found.
+	// Only stable bloom filters forget keys.
+	FalseNegativeProb float64
 }
 
 // Stats returns the stats of the bloom filter
 func (bf *BloomFilter) Stats() BloomFilterStats {
 	return BloomFilterStats{
 		Capacity: bf.capacity,
-		Count:    bf.count,
+		Count:    bf.Count(),
 		Size:     bf.bit_width,
 		M:        bf.m,
 		K:        bf.k,
@@ -406,16 +806,21 @@ func (bf *BloomFilter) unmap() error {
 	return nil
 }
 
-// mmap opens the filter file and maps it into memory
+// mmap maps the filter file into memory, growing the file if it is too small to hold the filter
 func (bf *BloomFilter) mmap() error {
 
-	var err error
-
-	if err := bf.memFile.Truncate(int64(bf.opts.dataSize)); err != nil {
-		log.Printf("Error truncating file: %s", err)
+	fi, err := bf.memFile.Stat()
+	if err != nil {
 		return err
 	}
 
+	if fi.Size() < int64(bf.opts.dataSize) {
+		if err := bf.memFile.Truncate(int64(bf.opts.dataSize)); err != nil {
+			log.Printf("Error truncating file: %s", err)
+			return err
+		}
+	}
+
 	bf.mem, err = mmap.MapRegion(bf.memFile, bf.opts.dataSize, mmap.RDWR, 0, 0)
 	if err != nil {
 		return fmt.Errorf("unable to mmap bloom filter file: %s", err)
@@ -424,6 +829,13 @@ func (bf *BloomFilter) mmap() error {
 	return nil
 }
 
+// release unmaps the filter and releases the file handle and lock, after a failed open
+func (bf *BloomFilter) release() {
+	_ = bf.unmap()
+	_ = bf.flock.Unlock()
+	_ = bf.memFile.Close()
+}
+
 // openFile opens the filter file and locks it
 func (bf *BloomFilter) openFile() error {
 	var err error
@@ -433,8 +845,9 @@ func (bf *BloomFilter) openFile() error {
 	}
 
 	if err := bf.flock.TryLock(); err != nil {
+		_ = bf.memFile.Close()
 		if err == fslock.ErrLocked {
-			return fmt.Errorf("file is locked by another process")
+			return ErrFileLocked
 		}
 		return fmt.Errorf("unable to lock bloom filter file: %s", err)
 	}
@@ -448,3 +861,5 @@ func divmod(num, denom int64) (quot, rem int64) {
 	rem = num % denom
 	return
 }
+
+var _ KeyValueFilter = (*BloomFilter)(nil)
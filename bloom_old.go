@@ -1,6 +1,7 @@
 package sprout
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"math"
@@ -51,17 +52,7 @@ func NewBloom2(opts *BloomOptions) *BloomFilter2 {
 		panic("Capacity must be greater than 0")
 	}
 
-	// number of hash functions (k)
-	numHashFn := int(math.Ceil(math.Log2(1.0 / opts.Err_rate)))
-
-	//ln22 = ln2^2
-	ln22 := math.Pow(math.Ln2, 2)
-
-	// M
-	bit_width := int((float64(opts.Capacity) * math.Abs(math.Log(opts.Err_rate)) / ln22))
-
-	//m
-	bits_per_slice := bit_width / numHashFn
+	numHashFn, bits_per_slice, bit_width := bloom2Params(opts.Err_rate, opts.Capacity)
 
 	seeds := make([]int64, numHashFn)
 	for i := 0; i < len(seeds); i++ {
@@ -71,10 +62,6 @@ func NewBloom2(opts *BloomOptions) *BloomFilter2 {
 	var b byte
 	byteSize := int(unsafe.Sizeof(&b))
 
-	// we only need bit_width/8 bits, but only after calculating m
-	bit_width /= byteSize
-	bit_width += byteSize // add extra 1 byte to ensure we have a full byte at the end
-
 	return &BloomFilter2{
 		err_rate:  opts.Err_rate,
 		capacity:  opts.Capacity,
@@ -87,13 +74,37 @@ func NewBloom2(opts *BloomOptions) *BloomFilter2 {
 	}
 }
 
+// bloom2Params returns the number of hash functions, the bits per slice and the width in bytes
+// of the bit array of a filter with the given error rate and capacity
+func bloom2Params(errRate float64, capacity int) (numHashFn, bitsPerSlice, bitWidth int) {
+	// number of hash functions (k)
+	numHashFn = int(math.Ceil(math.Log2(1.0 / errRate)))
+
+	//ln22 = ln2^2
+	ln22 := math.Pow(math.Ln2, 2)
+
+	// M
+	bitWidth = int((float64(capacity) * math.Abs(math.Log(errRate)) / ln22))
+
+	//m
+	bitsPerSlice = bitWidth / numHashFn
+
+	var b byte
+	byteSize := int(unsafe.Sizeof(&b))
+
+	// we only need bit_width/8 bits, but only after calculating m
+	bitWidth /= byteSize
+	bitWidth += byteSize // add extra 1 byte to ensure we have a full byte at the end
+	return numHashFn, bitsPerSlice, bitWidth
+}
+
 // Add adds the key to the bloom filter
-func (bf *BloomFilter2) Add(key, val []byte) {
+func (bf *BloomFilter2) Add(key []byte) error {
 
-	indices := bf.candidates(string(key))
+	indices := bf.candidates(key)
 
 	if bf.count >= bf.capacity {
-		log.Panicf("BloomFilter2 has reached full capacity %d, count: %d", bf.capacity, bf.count)
+		return fmt.Errorf("%w: %d", ErrCapacityReached, bf.capacity)
 	}
 
 	for i := 0; i < len(indices); i++ {
@@ -101,16 +112,24 @@ func (bf *BloomFilter2) Add(key, val []byte) {
 		bf.bit_array[idx] |= mask
 	}
 	bf.count++
+	return nil
+}
 
-	if bf.hasStore() {
-		bf.db.Put([]byte(key), val)
+// Put adds the key to the bloom filter, and also stores it in the persistent store
+func (bf *BloomFilter2) Put(key, val []byte) error {
+	if !bf.hasStore() {
+		return fmt.Errorf("%w, use Add() to add keys", ErrNoStore)
 	}
 
+	if err := bf.Add(key); err != nil {
+		return err
+	}
+	return bf.db.Put(key, val)
 }
 
 // Find checks if the key exists in the bloom filter
 func (bf *BloomFilter2) Contains(key []byte) bool {
-	indices := bf.candidates(string(key))
+	indices := bf.candidates(key)
 
 	for i := 0; i < len(indices); i++ {
 		idx, mask := bf.getBitIndexN(indices[i])
@@ -126,25 +145,42 @@ func (bf *BloomFilter2) Contains(key []byte) bool {
 
 // Get Gets the key from the underlying persistent store
 func (bf *BloomFilter2) Get(key []byte) []byte {
-	if !bf.hasStore() {
+	val, err := bf.GetE(key)
+	if errors.Is(err, ErrNoStore) {
 		log.Panicf("BloomFilter2 has no persistent store. Use Contains() instead")
 	}
-
-	if !bf.Contains(key) {
-		return nil
-	}
-
-	val, err := bf.db.Get(key)
 	if err != nil {
 		fmt.Printf("Error getting key %s from db: %s\n", key, err)
 		return nil
 	}
 	return val
+}
 
+// GetE gets the key from the underlying persistent store like Get, but returns an error instead of panicking.
+// A nil value is returned if the key is not found.
+func (bf *BloomFilter2) GetE(key []byte) ([]byte, error) {
+	if !bf.hasStore() {
+		return nil, fmt.Errorf("%w, use Contains() instead", ErrNoStore)
+	}
+
+	if !bf.Contains(key) {
+		return nil, nil
+	}
+
+	return bf.db.Get(key)
+}
+
+// Delete removes the key from the persistent store.
+// Bits cannot be unset in a bloom filter, so Contains may still report the key.
+func (bf *BloomFilter2) Delete(key []byte) error {
+	if !bf.hasStore() {
+		return fmt.Errorf("%w, keys cannot be removed from a bloom filter", ErrNoStore)
+	}
+	return bf.db.Delete(key)
 }
 
 func (bf *BloomFilter2) hasStore() bool {
-	return bf.db != nil && bf.db.isReady()
+	return storeReady(bf.db)
 }
 
 // getBitIndexN returns the index and mask for the bit.
@@ -157,7 +193,7 @@ func (bf *BloomFilter2) getBitIndexN(idx uint64) (uint64, byte) {
 }
 
 // candidates uses the hash function to return all index candidates of the given key
-func (bf *BloomFilter2) candidates(key string) []uint64 {
+func (bf *BloomFilter2) candidates(key []byte) []uint64 {
 	res := make([]uint64, 0, len(bf.seeds))
 	for i, seed := range bf.seeds {
 		hash := getHash(key, seed)
@@ -184,6 +220,18 @@ func (bf *BloomFilter2) FilterSize() int {
 	return bf.bit_width
 }
 
+// Stats returns the stats of the bloom filter
+func (bf *BloomFilter2) Stats() BloomFilterStats {
+	return BloomFilterStats{
+		Capacity: bf.capacity,
+		Count:    bf.count,
+		Size:     bf.bit_width,
+		M:        bf.m,
+		K:        len(bf.seeds),
+		Prob:     bf.err_rate,
+	}
+}
+
 // Close closes the file handle to the filter and the persistent store (if any)
 func (bf *BloomFilter2) Close() error {
 	if bf.hasStore() {
@@ -191,3 +239,5 @@ func (bf *BloomFilter2) Close() error {
 	}
 	return nil
 }
+
+var _ KeyValueFilter = (*BloomFilter2)(nil)
@@ -2,9 +2,12 @@ package sprout
 
 import (
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
+	"sync"
+	"sync/atomic"
 	"testing"
 
 	"github.com/dgraph-io/badger/v3"
@@ -168,6 +171,20 @@ func TestBloomFilter_Merge(t *testing.T) {
 		}
 	})
 
+	t.Run("merge should return ErrHeaderMismatch when the filters hash keys differently", func(t *testing.T) {
+		for name, opts := range map[string]*BloomOptions{
+			"format version": {Err_rate: 0.01, Capacity: 1000, Path: "./test3.db", FormatVersion: formatVersion1},
+			"hasher":         {Err_rate: 0.01, Capacity: 1000, Path: "./test3.db", Hasher: HasherXXHash},
+		} {
+			bf3 := NewBloom(opts)
+			if err := bf2.Merge(bf3); !errors.Is(err, ErrHeaderMismatch) {
+				t.Errorf("%s: expected ErrHeaderMismatch, got %v", name, err)
+			}
+			bf3.Close()
+			os.Remove(opts.Path)
+		}
+	})
+
 	t.Run("object added to the single filters should be found in the resulting merge", func(t *testing.T) {
 		key := []byte("foo")
 		opts := &BloomOptions{
@@ -229,6 +246,20 @@ func TestBloomFilter_AddToDB(t *testing.T) {
 			t.Errorf("expected value to be nil, got %s; error: %v", val, err)
 		}
 	})
+	t.Run("should delete the key from the store", func(t *testing.T) {
+		key, val := []byte("foo"), []byte("var")
+		bf.Put(key, val)
+
+		if err := bf.Delete(key); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if found, err := bf.db.Has(key); err != nil || found {
+			t.Errorf("expected key to be deleted, got %v; error: %v", found, err)
+		}
+		if val, err := bf.GetE(key); err != nil || val != nil {
+			t.Errorf("expected value to be nil, got %s; error: %v", val, err)
+		}
+	})
 }
 func TestBloomFilter_AddToBadgerDB(t *testing.T) {
 	store, cleanupFunc := BadgerDBSetupTest(t)
@@ -400,3 +431,488 @@ func TestBloomFilter_FileLock(t *testing.T) {
 	})
 
 }
+
+func TestOpenBloom(t *testing.T) {
+	opts := &BloomOptions{
+		Err_rate: 0.01,
+		Capacity: 1000,
+		Path:     "./test.db",
+	}
+	bf := NewBloom(opts)
+	defer os.Remove(opts.Path)
+
+	keys := []string{"foo", "bar", "baz"}
+	for _, key := range keys {
+		bf.Add([]byte(key))
+	}
+	if err := bf.Close(); err != nil {
+		t.Fatalf("Expected no error closing filter, got %v", err)
+	}
+
+	t.Run("should restore the filter from the file", func(t *testing.T) {
+		bf, err := OpenBloom(opts.Path)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		defer bf.Close()
+
+		if bf.Count() != len(keys) {
+			t.Errorf("Expected count to be %d, got %d", len(keys), bf.Count())
+		}
+		if bf.Capacity() != opts.Capacity {
+			t.Errorf("Expected capacity to be %d, got %d", opts.Capacity, bf.Capacity())
+		}
+		for _, key := range keys {
+			if !bf.Contains([]byte(key)) {
+				t.Errorf("Expected key %s to be found in the restored filter", key)
+			}
+		}
+	})
+
+	t.Run("NewBloom with the same options should restore the filter", func(t *testing.T) {
+		bf := NewBloom(opts)
+		defer bf.Close()
+
+		if bf.Count() != len(keys) {
+			t.Errorf("Expected count to be %d, got %d", len(keys), bf.Count())
+		}
+	})
+
+	t.Run("NewBloom with different options should panic", func(t *testing.T) {
+		opts := *opts
+		opts.Capacity = 2000
+		assertPanic(t, func() {
+			NewBloom(&opts)
+		})
+	})
+
+	t.Run("should return an error when the file is not a filter", func(t *testing.T) {
+		path := fmt.Sprintf("%s/invalid.db", t.TempDir())
+		os.WriteFile(path, make([]byte, 1024), 0600)
+
+		_, err := OpenBloom(path)
+		if !errors.Is(err, ErrInvalidHeader) {
+			t.Errorf("Expected ErrInvalidHeader, got %v", err)
+		}
+	})
+
+	t.Run("should return an error when the header describes a corrupt filter", func(t *testing.T) {
+		data, err := os.ReadFile(opts.Path)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		path := fmt.Sprintf("%s/corrupt.db", t.TempDir())
+
+		// m, then the bit width
+		for _, field := range []struct {
+			offset int
+			val    uint64
+		}{{16, 0}, {16, 1 << 20}, {24, 1 << 62}} {
+			buf := append([]byte(nil), data...)
+			binary.LittleEndian.PutUint64(buf[field.offset:], field.val)
+			os.WriteFile(path, buf, 0600)
+
+			_, err := OpenBloom(path)
+			if !errors.Is(err, ErrInvalidHeader) {
+				t.Errorf("Expected ErrInvalidHeader with %d at offset %d, got %v", field.val, field.offset, err)
+			}
+		}
+	})
+}
+
+func TestNewBloomE(t *testing.T) {
+	t.Run("should return ErrInvalidOptions for invalid options", func(t *testing.T) {
+		table := []BloomOptions{
+			{Err_rate: 0, Capacity: 1000, Path: "./test.db"},
+			{Err_rate: 1, Capacity: 1000, Path: "./test.db"},
+			{Err_rate: 0.01, Capacity: 10, Path: "./test.db"},
+		}
+		for _, opts := range table {
+			_, err := NewBloomE(&opts)
+			if !errors.Is(err, ErrInvalidOptions) {
+				t.Errorf("Expected ErrInvalidOptions for %+v, got %v", opts, err)
+			}
+		}
+	})
+
+	t.Run("should return ErrFileLocked when the file is in use", func(t *testing.T) {
+		opts := &BloomOptions{
+			Err_rate: 0.01,
+			Capacity: 1000,
+			Path:     "./test.db",
+		}
+		bf, err := NewBloomE(opts)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		defer func() {
+			bf.Close()
+			os.Remove(opts.Path)
+		}()
+
+		_, err = NewBloomE(opts)
+		if !errors.Is(err, ErrFileLocked) {
+			t.Errorf("Expected ErrFileLocked, got %v", err)
+		}
+	})
+
+	t.Run("should return typed errors from filter operations", func(t *testing.T) {
+		opts := &BloomOptions{
+			Err_rate: 0.01,
+			Capacity: 100,
+			Path:     "./test.db",
+		}
+		bf, err := NewBloomE(opts)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		defer func() {
+			bf.Close()
+			os.Remove(opts.Path)
+		}()
+
+		if _, err := bf.GetE([]byte("foo")); !errors.Is(err, ErrNoStore) {
+			t.Errorf("Expected ErrNoStore, got %v", err)
+		}
+		if err := bf.Put([]byte("foo"), []byte("bar")); !errors.Is(err, ErrNoStore) {
+			t.Errorf("Expected ErrNoStore, got %v", err)
+		}
+
+		for i := 0; i < opts.Capacity; i++ {
+			bf.Add([]byte(fmt.Sprintf("foo%d", i)))
+		}
+		if err := bf.Add([]byte("foo")); !errors.Is(err, ErrCapacityReached) {
+			t.Errorf("Expected ErrCapacityReached, got %v", err)
+		}
+
+		if err := bf.ClearE(); err != nil {
+			t.Errorf("Expected no error, got %v", err)
+		}
+		if err := bf.Add([]byte("foo")); err != nil {
+			t.Errorf("Expected no error after clear, got %v", err)
+		}
+	})
+}
+
+func TestBloomFilter_Batch(t *testing.T) {
+	store, cleanupFunc := DBSetupTest(t)
+	defer cleanupFunc()
+	opts := &BloomOptions{
+		Err_rate: 0.01,
+		Capacity: 100,
+		Database: store,
+		Path:     "./test.db",
+	}
+	bf := NewBloom(opts)
+	defer func() {
+		bf.Close()
+		os.Remove(opts.Path)
+	}()
+
+	keys, vals := make([][]byte, 50), make([][]byte, 50)
+	for i := range keys {
+		keys[i] = []byte(fmt.Sprintf("key%d", i))
+		vals[i] = []byte(fmt.Sprintf("val%d", i))
+	}
+
+	t.Run("it puts all the keys in the filter and the store", func(t *testing.T) {
+		if err := bf.PutBatch(keys, vals); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if bf.Count() != len(keys) {
+			t.Errorf("Expected count %d, got %d", len(keys), bf.Count())
+		}
+		for i, found := range bf.ContainsBatch(keys) {
+			if !found {
+				t.Errorf("Expected key %s to be found", keys[i])
+			}
+			if val := bf.Get(keys[i]); string(val) != string(vals[i]) {
+				t.Errorf("Expected value %s, got %s", vals[i], val)
+			}
+		}
+	})
+
+	t.Run("it returns the result of Contains for each key", func(t *testing.T) {
+		batch := [][]byte{[]byte("key1"), []byte("missing"), []byte("key2")}
+		res := bf.ContainsBatch(batch)
+		for i, key := range batch {
+			if res[i] != bf.Contains(key) {
+				t.Errorf("Expected ContainsBatch to match Contains for key %s", key)
+			}
+		}
+	})
+
+	t.Run("it does not add a batch that does not fit", func(t *testing.T) {
+		err := bf.AddBatch(make([][]byte, 51))
+		if !errors.Is(err, ErrCapacityReached) {
+			t.Errorf("Expected ErrCapacityReached, got %v", err)
+		}
+		if bf.Count() != len(keys) {
+			t.Errorf("Expected count to be unchanged, got %d", bf.Count())
+		}
+	})
+
+	t.Run("it rejects mismatched values", func(t *testing.T) {
+		if err := bf.PutBatch(keys[:2], vals[:1]); err == nil {
+			t.Errorf("Expected an error, got nil")
+		}
+	})
+}
+
+// assertAddIfAbsent adds the same keys from several goroutines, and checks that each key is only added once
+func assertAddIfAbsent(t *testing.T, filter interface {
+	Filter
+	AddIfAbsent(key []byte) (bool, error)
+}) {
+	n, workers := 200, 8
+	added := make([]int32, n)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < n; i++ {
+				ok, err := filter.AddIfAbsent([]byte(fmt.Sprintf("key%d", i)))
+				if err != nil {
+					t.Errorf("Expected no error, got %v", err)
+					return
+				}
+				if ok {
+					atomic.AddInt32(&added[i], 1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	total := 0
+	for i, c := range added {
+		if c > 1 {
+			t.Errorf("Expected key%d to be added at most once, got %d", i, c)
+		}
+		total += int(c)
+		if !filter.Contains([]byte(fmt.Sprintf("key%d", i))) {
+			t.Errorf("Expected key%d to be in the filter", i)
+		}
+	}
+	if total != filter.Count() {
+		t.Errorf("Expected count %d to match the number of added keys %d", filter.Count(), total)
+	}
+
+	if ok, err := filter.AddIfAbsent([]byte("key0")); ok || err != nil {
+		t.Errorf("Expected existing key to not be added, got %v; error: %v", ok, err)
+	}
+}
+
+func TestBloomFilter_AddIfAbsent(t *testing.T) {
+	opts := &BloomOptions{
+		Err_rate: 0.0001,
+		Capacity: 1000,
+		Path:     "./test.db",
+	}
+	bf := NewBloom(opts)
+	defer func() {
+		bf.Close()
+		os.Remove(opts.Path)
+	}()
+
+	assertAddIfAbsent(t, bf)
+
+	// AddIfAbsent only reports a key as new if it set one of its bits,
+	// not if a concurrent Add set them all after the key was looked up
+	indices := bf.candidates([]byte("foo"))
+	if wasSet, _ := bf.setBits(indices); wasSet {
+		t.Errorf("Expected the bits of a new key to not be set")
+	}
+	if wasSet, _ := bf.setBits(indices); !wasSet {
+		t.Errorf("Expected the bits of an added key to be set")
+	}
+}
+
+func TestBloomFilter_ConcurrentAdd(t *testing.T) {
+	opts := &BloomOptions{
+		Err_rate: 0.01,
+		Capacity: 4000,
+		Path:     "./test.db",
+	}
+	bf := NewBloom(opts)
+	defer os.Remove(opts.Path)
+
+	workers, n := 8, 500
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(2)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < n; i++ {
+				if err := bf.Add([]byte(fmt.Sprintf("key%d-%d", w, i))); err != nil {
+					t.Errorf("Expected no error, got %v", err)
+					return
+				}
+			}
+		}(w)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < n; i++ {
+				bf.Contains([]byte(fmt.Sprintf("key%d-%d", w, i)))
+				bf.Count()
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	if bf.Count() != workers*n {
+		t.Errorf("Expected count %d, got %d", workers*n, bf.Count())
+	}
+	for w := 0; w < workers; w++ {
+		for i := 0; i < n; i++ {
+			if key := []byte(fmt.Sprintf("key%d-%d", w, i)); !bf.Contains(key) {
+				t.Fatalf("Expected key %s to be in the filter", key)
+			}
+		}
+	}
+
+	t.Run("the count is stored in the file", func(t *testing.T) {
+		bf.Close()
+		bf, err := OpenBloom(opts.Path)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		defer bf.Close()
+		if bf.Count() != workers*n {
+			t.Errorf("Expected count %d, got %d", workers*n, bf.Count())
+		}
+	})
+}
+
+func TestBloomFilter_ConcurrentCapacity(t *testing.T) {
+	opts := &BloomOptions{
+		Err_rate: 0.01,
+		Capacity: 100,
+		Path:     "./test.db",
+	}
+	bf := NewBloom(opts)
+	defer func() {
+		bf.Close()
+		os.Remove(opts.Path)
+	}()
+
+	var added int32
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				err := bf.Add([]byte(fmt.Sprintf("key%d-%d", w, i)))
+				if err == nil {
+					atomic.AddInt32(&added, 1)
+				} else if !errors.Is(err, ErrCapacityReached) {
+					t.Errorf("Expected ErrCapacityReached, got %v", err)
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	if added != 100 || bf.Count() != 100 {
+		t.Errorf("Expected exactly 100 keys to be added, got %d and count %d", added, bf.Count())
+	}
+}
+
+func TestBloomFilter_FormatVersion(t *testing.T) {
+	for _, version := range []int{formatVersion1, formatVersion2} {
+		t.Run(fmt.Sprintf("version %d", version), func(t *testing.T) {
+			opts := &BloomOptions{
+				Err_rate:      0.01,
+				Capacity:      1000,
+				Path:          "./test.db",
+				FormatVersion: version,
+			}
+			bf := NewBloom(opts)
+			defer os.Remove(opts.Path)
+
+			for i := 0; i < 1000; i++ {
+				bf.Add([]byte(fmt.Sprintf("key%d", i)))
+			}
+
+			// the indices stay in the slice of their hash function
+			indices := bf.candidates([]byte("key0"))
+			for i, idx := range indices {
+				if idx < uint64(i*bf.m) || idx >= uint64((i+1)*bf.m) {
+					t.Errorf("Expected index %d to be in slice %d", idx, i)
+				}
+			}
+
+			falsePositives := 0
+			for i := 0; i < 10000; i++ {
+				if bf.Contains([]byte(fmt.Sprintf("other%d", i))) {
+					falsePositives++
+				}
+			}
+			if rate := float64(falsePositives) / 10000; rate > 2*opts.Err_rate {
+				t.Errorf("Expected false positive rate close to %v, got %v", opts.Err_rate, rate)
+			}
+			bf.Close()
+
+			// the filter is reopened with the version it was created with
+			bf = NewBloom(&BloomOptions{Err_rate: 0.01, Capacity: 1000, Path: opts.Path})
+			if bf.version != version {
+				t.Errorf("Expected version %d, got %d", version, bf.version)
+			}
+			for i := 0; i < 1000; i++ {
+				if !bf.Contains([]byte(fmt.Sprintf("key%d", i))) {
+					t.Fatalf("Expected key%d to be in the reopened filter", i)
+				}
+			}
+			bf.Close()
+
+			other := formatVersion1 + formatVersion2 - version
+			_, err := NewBloomE(&BloomOptions{Err_rate: 0.01, Capacity: 1000, Path: opts.Path, FormatVersion: other})
+			if !errors.Is(err, ErrHeaderMismatch) {
+				t.Errorf("Expected ErrHeaderMismatch when reopening with version %d, got %v", other, err)
+			}
+		})
+	}
+
+	t.Run("it rejects an unknown version", func(t *testing.T) {
+		_, err := NewBloomE(&BloomOptions{Err_rate: 0.01, Capacity: 1000, Path: "./test.db", FormatVersion: maxFormatVersion + 1})
+		if !errors.Is(err, ErrInvalidOptions) {
+			t.Errorf("Expected ErrInvalidOptions, got %v", err)
+		}
+	})
+}
+
+func TestBloomFilter_Allocs(t *testing.T) {
+	hashers := []Hasher{HasherMurmur3, HasherMurmur2, HasherXXHash, HasherFNV1a}
+	for _, version := range []int{formatVersion1, formatVersion2} {
+		for _, hasher := range hashers {
+			t.Run(fmt.Sprintf("version %d hasher %d", version, hasher.ID()), func(t *testing.T) {
+				opts := &BloomOptions{
+					Err_rate:      0.0001,
+					Capacity:      1000,
+					Path:          "./test.db",
+					FormatVersion: version,
+					Hasher:        hasher,
+				}
+				bf := NewBloom(opts)
+				defer func() {
+					bf.Close()
+					os.Remove(opts.Path)
+				}()
+
+				key := []byte("https://example.com/some/path?query=1")
+				if allocs := testing.AllocsPerRun(100, func() { bf.Add(key) }); allocs != 0 {
+					t.Errorf("Expected Add to not allocate, got %v allocations", allocs)
+				}
+				if allocs := testing.AllocsPerRun(100, func() { bf.Contains(key) }); allocs != 0 {
+					t.Errorf("Expected Contains to not allocate, got %v allocations", allocs)
+				}
+				if allocs := testing.AllocsPerRun(100, func() { bf.AddIfAbsent(key) }); allocs != 0 {
+					t.Errorf("Expected AddIfAbsent to not allocate, got %v allocations", allocs)
+				}
+			})
+		}
+	}
+}
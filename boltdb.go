@@ -1,6 +1,7 @@
 package sprout
 
 import (
+	"bytes"
 	"fmt"
 	"os"
 	"sync"
@@ -24,7 +25,19 @@ var (
 )
 
 // NewBolt instantiates a new BoltStore.
+//
+// NewBolt exits the process if the database cannot be opened, use NewBoltE to get an error instead.
 func NewBolt(filePath string, filemode os.FileMode, opts ...bolt.Options) *BoltStore {
+	store, err := NewBoltE(filePath, filemode, opts...)
+	if err != nil {
+		fmt.Printf("failed to open boltdb: %v", err)
+		os.Exit(1)
+	}
+	return store
+}
+
+// NewBoltE instantiates a new BoltStore like NewBolt, but returns an error instead of exiting.
+func NewBoltE(filePath string, filemode os.FileMode, opts ...bolt.Options) (*BoltStore, error) {
 	store := &BoltStore{
 		filePath: filePath,
 		fileMode: filemode,
@@ -44,11 +57,10 @@ func NewBolt(filePath string, filemode os.FileMode, opts ...bolt.Options) *BoltS
 
 	err := store.open()
 	if err != nil {
-		fmt.Printf("failed to open boltdb: %v", err)
-		os.Exit(1)
+		return nil, err
 	}
 
-	return store
+	return store, nil
 }
 
 func (store *BoltStore) open() error {
@@ -73,7 +85,11 @@ func (store *BoltStore) Get(key []byte) ([]byte, error) {
 	var value []byte
 	err := store.db.View(func(tx *bolt.Tx) error {
 		b := tx.Bucket([]byte(store.name))
-		value = b.Get(key)
+
+		// the value is only valid during the transaction, so it is copied
+		if v := b.Get(key); v != nil {
+			value = append([]byte{}, v...)
+		}
 		return nil
 	})
 	if err != nil {
@@ -94,13 +110,73 @@ func (store *BoltStore) Put(key []byte, value []byte) error {
 	return err
 }
 
-// isReady returns true if the store is ready to use.
-func (store *BoltStore) isReady() bool {
+// PutBatch sets the value of each key in a single transaction, vals[i] being the value of keys[i]
+func (store *BoltStore) PutBatch(keys, vals [][]byte) error {
+	if len(keys) != len(vals) {
+		return fmt.Errorf("got %d keys and %d values", len(keys), len(vals))
+	}
+	return store.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(store.name))
+		for i := range keys {
+			if err := b.Put(keys[i], vals[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Delete removes the key from the store
+func (store *BoltStore) Delete(key []byte) error {
+	err := store.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(store.name))
+		return b.Delete(key)
+	})
+	return err
+}
+
+// Has returns true if the key is in the store
+func (store *BoltStore) Has(key []byte) (bool, error) {
+	var found bool
+	err := store.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(store.name))
+		found = b.Get(key) != nil
+		return nil
+	})
+	return found, err
+}
+
+// ForEach calls fn for every key in the store, in key order.
+// The key and value are only valid until fn returns.
+func (store *BoltStore) ForEach(fn func(key, val []byte) error) error {
+	return store.Scan(nil, fn)
+}
+
+// Scan calls fn for every key in the store starting with prefix, in key order.
+// The key and value are only valid until fn returns.
+func (store *BoltStore) Scan(prefix []byte, fn func(key, val []byte) error) error {
+	return store.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket([]byte(store.name)).Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			if err := fn(k, v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// IsReady returns true if the store is ready to use.
+func (store *BoltStore) IsReady() bool {
 	return store.db != nil
 }
 
+// DB returns the underlying database
 func (store *BoltStore) DB() interface{} {
 	return store.db
 }
 
-var _ Store = (*BoltStore)(nil)
+var _ ReadyStore = (*BoltStore)(nil)
+var _ IterableStore = (*BoltStore)(nil)
+var _ BatchStore = (*BoltStore)(nil)
+var _ DBStore = (*BoltStore)(nil)
@@ -26,3 +26,10 @@ func TestBoltDB(t *testing.T) {
 		}
 	})
 }
+
+func TestNewBoltE(t *testing.T) {
+	_, err := NewBoltE("/nonexistent/dir/test.db", 0600)
+	if err == nil {
+		t.Errorf("Expected an error when the database cannot be opened, got nil")
+	}
+}
@@ -14,6 +14,7 @@ var (
 	pathFlag string
 	errFlag  string
 	capFlag  int
+	typeFlag string
 )
 
 var writer io.Writer = os.Stderr
@@ -23,6 +24,8 @@ var errHelp = `
 #   sprout new [flags]
 #	-path <path>
 #		Path to the filter
+#	-type <type>
+#		The type of filter: bloom, scalable, counting or cuckoo (default bloom)
 #	-err_rate <float>
 #		The desired false positive rate
 #	-capacity <int>
@@ -42,11 +45,24 @@ var errHelp = `
 #	-path <path>
 #		Path to the filter
 
+#  sprout serve [flags]
+#	-resp[=<addr>]
+#		Serve the filters to Redis clients with the BF commands of RedisBloom (default :6379)
+#	-http[=<addr>]
+#		Serve the filters over HTTP (default :8080)
+#	-grpc[=<addr>]
+#		Serve the filters over gRPC (default :9090)
+#	-store <store>
+#		Persistent store of the values of the filters: bolt, or none (default none)
+#	-dir <dir>
+#		Directory of the filters served (default filters)
+
 
 `
 
 func init() {
 	flag.StringVar(&pathFlag, "path", "", "Path to the filter")
+	flag.StringVar(&typeFlag, "type", "bloom", "The type of filter")
 
 	flag.ErrHelp = errors.New(errHelp)
 	flag.Usage = func() {
@@ -64,6 +80,14 @@ func Execute() {
 	var element string
 	flag.CommandLine.Parse(os.Args[2:])
 
+	if command == "serve" {
+		if err := serve(); err != nil {
+			fmt.Fprintln(writer, err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	if command != "new" {
 		if len(os.Args) <= 2 {
 			flag.Usage()
@@ -82,7 +106,13 @@ func Execute() {
 		pathFlag = "bloom.db"
 	}
 
-	bf := NewBloom()
+	bf, err := NewFilter()
+	if err != nil {
+		fmt.Fprintln(writer, err)
+		os.Exit(1)
+	}
+	defer bf.Close()
+
 	switch command {
 	case "new":
 		fmt.Fprintf(writer, "Filter %s created\n", pathFlag)
@@ -92,7 +122,16 @@ func Execute() {
 		resp := bf.Contains([]byte(element))
 		fmt.Println(resp)
 	case "reset":
-		bf.Clear()
+		f, ok := bf.(interface{ ClearE() error })
+		if !ok {
+			fmt.Fprintln(writer, "reset is not supported for this filter type")
+			bf.Close()
+			os.Exit(1)
+		}
+		if err := f.ClearE(); err != nil {
+			fmt.Fprintln(writer, err)
+			os.Exit(1)
+		}
 		fmt.Fprintf(writer, "Filter %s reset\n", pathFlag)
 	case "stats":
 		fmt.Printf("%+v\n", bf.Stats())
@@ -101,12 +140,23 @@ func Execute() {
 	}
 }
 
-func NewBloom() *sprout.BloomFilter {
+// NewFilter creates the filter of the type set by the -type flag
+func NewFilter() (sprout.Filter, error) {
 	opts := &sprout.BloomOptions{
 		Path:     pathFlag,
 		Capacity: 100,
 		Err_rate: 0.001,
 	}
-	bf := sprout.NewBloom(opts)
-	return bf
+	switch typeFlag {
+	case "", "bloom":
+		return sprout.NewBloomE(opts)
+	case "scalable":
+		return sprout.NewScalableBloomE(opts)
+	case "counting":
+		return sprout.NewCountingBloomE(opts)
+	case "cuckoo":
+		return sprout.NewCuckooE(opts)
+	default:
+		return nil, fmt.Errorf("unknown filter type %q", typeFlag)
+	}
 }
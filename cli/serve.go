@@ -0,0 +1,148 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/dsa0x/sprout"
+	"github.com/dsa0x/sprout/remote"
+	"github.com/dsa0x/sprout/server"
+	"google.golang.org/grpc"
+)
+
+// shutdownTimeout bounds the time given to the running requests to complete on shutdown
+const shutdownTimeout = 10 * time.Second
+
+var (
+	respFlag  = addrFlag{def: ":6379"}
+	httpFlag  = addrFlag{def: ":8080"}
+	grpcFlag  = addrFlag{def: ":9090"}
+	dirFlag   string
+	storeFlag string
+)
+
+func init() {
+	flag.Var(&respFlag, "resp", "Serve the filters to Redis clients, on the given address (default :6379)")
+	flag.Var(&httpFlag, "http", "Serve the filters over HTTP, on the given address (default :8080)")
+	flag.Var(&grpcFlag, "grpc", "Serve the filters over gRPC, on the given address (default :9090)")
+	flag.StringVar(&dirFlag, "dir", "filters", "Directory of the filters served")
+	flag.StringVar(&storeFlag, "store", "", "Persistent store of the values of the filters served: bolt, or none")
+}
+
+// addrFlag is the address of a server, which can be given as -name to use the default address or -name=addr
+type addrFlag struct {
+	def  string
+	addr string
+}
+
+func (f *addrFlag) String() string { return f.addr }
+
+func (f *addrFlag) IsBoolFlag() bool { return true }
+
+func (f *addrFlag) Set(s string) error {
+	switch s {
+	case "true":
+		f.addr = f.def
+	case "false":
+		f.addr = ""
+	default:
+		f.addr = s
+	}
+	return nil
+}
+
+// serve serves the filters of the -dir directory until the process is interrupted
+func serve() error {
+	if respFlag.addr == "" && httpFlag.addr == "" && grpcFlag.addr == "" {
+		return errors.New("serve requires -resp, -http or -grpc")
+	}
+
+	reg, err := server.NewRegistry(dirFlag, nil)
+	if err != nil {
+		return err
+	}
+	defer reg.Close()
+
+	switch storeFlag {
+	case "", "none":
+	case "bolt":
+		reg.NewStore = func(path string) (sprout.Store, error) {
+			return sprout.NewBoltE(strings.TrimSuffix(path, ".db")+".bolt", 0600)
+		}
+	default:
+		return fmt.Errorf("unknown store %q", storeFlag)
+	}
+
+	errc := make(chan error, 3)
+	var resp *server.RESPServer
+	if respFlag.addr != "" {
+		resp = server.NewRESPServer(reg)
+		go func() {
+			errc <- resp.ListenAndServe(respFlag.addr)
+		}()
+		fmt.Fprintf(writer, "Serving the filters of %s to Redis clients on %s\n", dirFlag, respFlag.addr)
+	}
+	var hs *http.Server
+	if httpFlag.addr != "" {
+		hs = &http.Server{Addr: httpFlag.addr, Handler: server.NewHandler(reg)}
+		go func() {
+			errc <- hs.ListenAndServe()
+		}()
+		fmt.Fprintf(writer, "Serving the filters of %s over HTTP on %s\n", dirFlag, httpFlag.addr)
+	}
+
+	var gs *grpc.Server
+	if grpcFlag.addr != "" {
+		l, err := net.Listen("tcp", grpcFlag.addr)
+		if err != nil {
+			return err
+		}
+		gs = grpc.NewServer()
+		remote.Register(gs, reg)
+		go func() {
+			errc <- gs.Serve(l)
+		}()
+		fmt.Fprintf(writer, "Serving the filters of %s over gRPC on %s\n", dirFlag, grpcFlag.addr)
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	select {
+	case err = <-errc:
+	case <-sig:
+	}
+	if resp != nil {
+		resp.Close()
+	}
+	if hs != nil {
+		// the handlers must return before the filters are closed with the registry
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		if hs.Shutdown(ctx) != nil {
+			hs.Close()
+		}
+		cancel()
+	}
+	if gs != nil {
+		// like the HTTP server, the running calls are given shutdownTimeout to complete
+		stopped := make(chan struct{})
+		go func() {
+			gs.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-time.After(shutdownTimeout):
+			gs.Stop()
+		}
+	}
+	return err
+}
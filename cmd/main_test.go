@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/binary"
 	"fmt"
 	"os"
 	"testing"
@@ -16,7 +17,7 @@ func Benchmark_InitializeBloom(b *testing.B) {
 	opts := &sprout.BloomOptions{
 		Err_rate: 0.001,
 		Path:     "/tmp/bloom.db",
-		Capacity: b.N,
+		Capacity: b.N + 10,
 	}
 	bf := sprout.NewBloom(opts)
 
@@ -32,7 +33,7 @@ func Benchmark_NewBloom(b *testing.B) {
 	opts := &sprout.BloomOptions{
 		Err_rate: 0.001,
 		Path:     "/tmp/bloom.db",
-		Capacity: b.N,
+		Capacity: b.N + 10,
 	}
 	bf := sprout.NewBloom(opts)
 	n := 0
@@ -53,7 +54,7 @@ func Benchmark_NewBloomFind(b *testing.B) {
 	opts := &sprout.BloomOptions{
 		Err_rate: 0.001,
 		Path:     "/tmp/bloom.db",
-		Capacity: b.N,
+		Capacity: b.N + 10,
 	}
 	bf := sprout.NewBloom(opts)
 
@@ -85,7 +86,7 @@ func Benchmark_NewScalableBloom(b *testing.B) {
 	opts := &sprout.BloomOptions{
 		Err_rate: 0.001,
 		Path:     "/tmp/bloom.db",
-		Capacity: b.N,
+		Capacity: b.N + 10,
 	}
 	bf := sprout.NewScalableBloom(opts)
 	n := 0
@@ -106,7 +107,7 @@ func Benchmark_NewScalableBloomFind(b *testing.B) {
 	opts := &sprout.BloomOptions{
 		Err_rate: 0.001,
 		Path:     "/tmp/bloom.db",
-		Capacity: b.N,
+		Capacity: b.N + 10,
 	}
 	bf := sprout.NewScalableBloom(opts)
 
@@ -134,14 +135,14 @@ func Benchmark_NewBloom2(b *testing.B) {
 	opts := &sprout.BloomOptions{
 		Err_rate: 0.001,
 		Path:     "/tmp/bloom.db",
-		Capacity: b.N,
+		Capacity: b.N + 10,
 	}
 	bf := sprout.NewBloom2(opts)
 	defer bf.Close()
 
 	n := 0
 	for i := 0; i < b.N; i++ {
-		bf.Add([]byte{byte(n)}, []byte("bar"))
+		bf.Add([]byte{byte(n)})
 		n++
 	}
 	n = 0
@@ -225,3 +226,262 @@ func Benchmark_Badgerdb(b *testing.B) {
 		os.Remove("/tmp/test.db")
 	}()
 }
+func Benchmark_NewBloomAddBatch(b *testing.B) {
+	b.ReportAllocs()
+	b.ResetTimer()
+	opts := &sprout.BloomOptions{
+		Err_rate: 0.001,
+		Path:     "/tmp/bloom.db",
+		Capacity: b.N + 10,
+	}
+	bf := sprout.NewBloom(opts)
+
+	keys := make([][]byte, 0, 1000)
+	for i := 0; i < b.N; i++ {
+		keys = append(keys, []byte{byte(i)})
+		if len(keys) == cap(keys) || i == b.N-1 {
+			bf.AddBatch(keys)
+			keys = keys[:0]
+		}
+	}
+
+	defer func() {
+		bf.Close()
+		os.Remove(opts.Path)
+	}()
+
+}
+func Benchmark_BloomPutBatch(b *testing.B) {
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	db := sprout.NewBolt("/tmp/bolt.db", 0600)
+	opts := &sprout.BloomOptions{
+		Err_rate: 0.001,
+		Path:     "/tmp/bloom.db",
+		Capacity: b.N + 10,
+		Database: db,
+	}
+	bf := sprout.NewBloom(opts)
+
+	keys, vals := make([][]byte, 0, 1000), make([][]byte, 0, 1000)
+	for i := 0; i < b.N; i++ {
+		keys = append(keys, []byte(fmt.Sprint(i)))
+		vals = append(vals, []byte("bar"))
+		if len(keys) == cap(keys) || i == b.N-1 {
+			bf.PutBatch(keys, vals)
+			keys, vals = keys[:0], vals[:0]
+		}
+	}
+
+	defer func() {
+		bf.Close()
+		db.Close()
+		os.Remove(opts.Path)
+		os.Remove("/tmp/bolt.db")
+	}()
+}
+
+// benchmarkFormatVersion adds and looks up b.N keys in a filter with many hash functions.
+// Version 1 hashes each key once per hash function, version 2 once per key.
+func benchmarkFormatVersion(b *testing.B, version int, lookup bool) {
+	opts := &sprout.BloomOptions{
+		Err_rate:      0.0001,
+		Path:          "/tmp/bloom.db",
+		Capacity:      b.N + 10,
+		FormatVersion: version,
+	}
+	bf := sprout.NewBloom(opts)
+	defer func() {
+		bf.Close()
+		os.Remove(opts.Path)
+	}()
+
+	keys := make([][]byte, b.N)
+	for i := range keys {
+		keys[i] = []byte(fmt.Sprintf("https://example.com/items/%d?ref=benchmark", i))
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	if lookup {
+		b.StopTimer()
+		for _, key := range keys {
+			bf.Add(key)
+		}
+		b.StartTimer()
+		for _, key := range keys {
+			bf.Contains(key)
+		}
+		return
+	}
+	for _, key := range keys {
+		bf.Add(key)
+	}
+}
+
+func Benchmark_AddFormatV1(b *testing.B)      { benchmarkFormatVersion(b, 1, false) }
+func Benchmark_AddFormatV2(b *testing.B)      { benchmarkFormatVersion(b, 2, false) }
+func Benchmark_ContainsFormatV1(b *testing.B) { benchmarkFormatVersion(b, 1, true) }
+func Benchmark_ContainsFormatV2(b *testing.B) { benchmarkFormatVersion(b, 2, true) }
+
+func Benchmark_BloomAdd(b *testing.B) {
+	opts := &sprout.BloomOptions{
+		Err_rate: 0.001,
+		Capacity: b.N + 10,
+		Path:     "/tmp/bloom.db",
+	}
+	bf := sprout.NewBloom(opts)
+	defer func() {
+		bf.Close()
+		os.Remove(opts.Path)
+	}()
+
+	key := make([]byte, 8)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		binary.LittleEndian.PutUint64(key, uint64(i))
+		bf.Add(key)
+	}
+}
+
+func Benchmark_BloomContains(b *testing.B) {
+	opts := &sprout.BloomOptions{
+		Err_rate: 0.001,
+		Capacity: 10000,
+		Path:     "/tmp/bloom.db",
+	}
+	bf := sprout.NewBloom(opts)
+	defer func() {
+		bf.Close()
+		os.Remove(opts.Path)
+	}()
+
+	key := make([]byte, 8)
+	for i := 0; i < opts.Capacity; i++ {
+		binary.LittleEndian.PutUint64(key, uint64(i))
+		bf.Add(key)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		binary.LittleEndian.PutUint64(key, uint64(i))
+		bf.Contains(key)
+	}
+}
+
+func Benchmark_ScalableBloomAdd(b *testing.B) {
+	opts := &sprout.BloomOptions{
+		Err_rate: 0.001,
+		Capacity: b.N + 10,
+		Path:     "/tmp/bloom.db",
+	}
+	sbf := sprout.NewScalableBloom(opts)
+	defer func() {
+		sbf.Close()
+		os.Remove(opts.Path)
+	}()
+
+	key := make([]byte, 8)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		binary.LittleEndian.PutUint64(key, uint64(i))
+		sbf.Add(key)
+	}
+}
+
+func Benchmark_ScalableBloomContains(b *testing.B) {
+	opts := &sprout.BloomOptions{
+		Err_rate: 0.001,
+		Capacity: 1000,
+		Path:     "/tmp/bloom.db",
+	}
+	sbf := sprout.NewScalableBloom(opts)
+	defer func() {
+		sbf.Close()
+		os.Remove(opts.Path)
+	}()
+
+	// the lookups go through several filters
+	key := make([]byte, 8)
+	for i := 0; i < 10*opts.Capacity; i++ {
+		binary.LittleEndian.PutUint64(key, uint64(i))
+		sbf.Add(key)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		binary.LittleEndian.PutUint64(key, uint64(i))
+		sbf.Contains(key)
+	}
+}
+
+// benchmarkLayout compares the partitioned layout of BloomFilter with the blocks of BlockedBloomFilter,
+// on a filter much larger than the CPU caches
+func benchmarkLayout(b *testing.B, blocked, lookup bool) {
+	opts := &sprout.BloomOptions{
+		Err_rate: 0.001,
+		Capacity: 1 << 22,
+		Path:     "/tmp/bloom.db",
+	}
+	var f sprout.Filter
+	if blocked {
+		f = sprout.NewBlockedBloom(opts)
+	} else {
+		f = sprout.NewBloom(opts)
+	}
+	defer func() {
+		// closing the filter flushes it to disk, which is not part of the measure
+		b.StopTimer()
+		f.Close()
+		os.Remove(opts.Path)
+	}()
+
+	// fill adds n keys that are not looked up. Writing to the pages of the new filter file faults them in,
+	// and a blocked filter, which writes one page per key instead of k, takes many more keys to fault in
+	// the whole file, so the first keys would measure the page faults rather than the cache misses.
+	key := make([]byte, 8)
+	fill := func(n int) {
+		for i := 0; i < n; i++ {
+			binary.LittleEndian.PutUint64(key, uint64(1<<40+i))
+			f.Add(key)
+		}
+	}
+	if lookup {
+		for i := 0; i < opts.Capacity; i++ {
+			binary.LittleEndian.PutUint64(key, uint64(i))
+			f.Add(key)
+		}
+	} else {
+		fill(opts.Capacity / 4)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		// half of the lookups are for keys in the filter
+		binary.LittleEndian.PutUint64(key, uint64(i%opts.Capacity*2))
+		if lookup {
+			f.Contains(key)
+			continue
+		}
+
+		// every iteration adds a key, the full filter is cleared outside of the measure
+		if f.Count() == opts.Capacity {
+			b.StopTimer()
+			if err := f.(interface{ ClearE() error }).ClearE(); err != nil {
+				b.Fatal(err)
+			}
+			fill(opts.Capacity / 4)
+			binary.LittleEndian.PutUint64(key, uint64(i%opts.Capacity*2))
+			b.StartTimer()
+		}
+		f.Add(key)
+	}
+}
+
+func Benchmark_AddPartitioned(b *testing.B)      { benchmarkLayout(b, false, false) }
+func Benchmark_AddBlocked(b *testing.B)          { benchmarkLayout(b, true, false) }
+func Benchmark_ContainsPartitioned(b *testing.B) { benchmarkLayout(b, false, true) }
+func Benchmark_ContainsBlocked(b *testing.B)     { benchmarkLayout(b, true, true) }
@@ -0,0 +1,8 @@
+// Command sprout creates and queries sprout filters, and serves them to other applications.
+package main
+
+import "github.com/dsa0x/sprout/cli"
+
+func main() {
+	cli.Execute()
+}
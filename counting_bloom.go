@@ -0,0 +1,310 @@
+package sprout
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync/atomic"
+)
+
+// CountingBloomFilter is a bloom filter that keeps a counter instead of a single bit
+// for each position of the filter, which allows keys to be removed from the filter.
+//
+// A counter that reaches its maximum value is never incremented or decremented again,
+// so that removing other keys cannot cause false negatives.
+type CountingBloomFilter struct {
+	// the underlying filter holds the counters in its mmaped region
+	bf *BloomFilter
+
+	// the number of bits per counter
+	counterBits int
+
+	// the largest value of a counter
+	maxCount uint64
+}
+
+// NewCountingBloom creates a new counting bloom filter.
+// err_rate is the desired false error rate. e.g. 0.001 implies 1 false positive in 1000 lookups
+//
+// capacity is the number of entries intended to be added to the filter
+//
+// counter_bits is the number of bits per counter, and defaults to 4.
+//
+// NewCountingBloom panics if the filter cannot be created, use NewCountingBloomE to get an error instead.
+func NewCountingBloom(opts *BloomOptions) *CountingBloomFilter {
+	cbf, err := NewCountingBloomE(opts)
+	if err != nil {
+		log.Panicf("%v", err)
+	}
+	return cbf
+}
+
+// NewCountingBloomE creates a new counting bloom filter like NewCountingBloom, but returns an error instead of panicking.
+func NewCountingBloomE(opts *BloomOptions) (*CountingBloomFilter, error) {
+	if opts == nil {
+		opts = &DefaultBloomOptions
+	}
+	if err := validateOptions(opts); err != nil {
+		return nil, err
+	}
+	// the RedisBloom layout only describes plain bloom filters
+	if opts.FormatVersion == formatVersion3 {
+		return nil, fmt.Errorf("%w: counting bloom filters do not support format version %d", ErrInvalidOptions, formatVersion3)
+	}
+
+	counterBits := opts.CounterBits
+	if counterBits == 0 {
+		counterBits = 4
+	}
+	if counterBits != 4 && counterBits != 8 && counterBits != 16 {
+		return nil, fmt.Errorf("%w: counter bits must be 4, 8 or 16", ErrInvalidOptions)
+	}
+
+	bf := newBloomFilter(opts)
+	bf.kind = countingHeaderMagic
+	bf.cellBits = counterBits
+
+	// one counter per bit of the bloom filter
+	bf.bit_width = (bf.k*bf.m*counterBits + 7) / 8
+
+	if err := bf.open(opts); err != nil {
+		return nil, err
+	}
+	return newCountingBloomFilter(bf), nil
+}
+
+// OpenCountingBloom restores the counting bloom filter stored in the file at path.
+//
+// database is the persistent store to attach to the filter. can be omitted.
+func OpenCountingBloom(path string, database ...Store) (*CountingBloomFilter, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open bloom filter file: %w", err)
+	}
+	h, err := readHeader(file, 0)
+	file.Close()
+	if err != nil {
+		return nil, err
+	}
+	if h.magic != countingHeaderMagic {
+		return nil, fmt.Errorf("%w: not a counting bloom filter", ErrInvalidHeader)
+	}
+
+	opts := &BloomOptions{
+		Path:        path,
+		Err_rate:    h.errRate,
+		Capacity:    int(h.capacity),
+		CounterBits: int(h.cellBits),
+	}
+	if len(database) > 0 {
+		opts.Database = database[0]
+	}
+
+	bf := bloomFromHeader(h, opts.Database)
+	if err := bf.open(opts); err != nil {
+		return nil, err
+	}
+	return newCountingBloomFilter(bf), nil
+}
+
+func newCountingBloomFilter(bf *BloomFilter) *CountingBloomFilter {
+	return &CountingBloomFilter{
+		bf:          bf,
+		counterBits: bf.cellBits,
+		maxCount:    1<<bf.cellBits - 1,
+	}
+}
+
+// Add adds the key to the filter, incrementing its counters
+func (cbf *CountingBloomFilter) Add(key []byte) error {
+	bf := cbf.bf
+	bf.lock.Lock()
+	defer bf.lock.Unlock()
+
+	if bf.Count() >= bf.capacity {
+		return fmt.Errorf("%w: %d", ErrCapacityReached, bf.capacity)
+	}
+
+	for _, idx := range bf.candidates(key) {
+		// saturated counters are left as is
+		if c := cbf.counter(idx); c < cbf.maxCount {
+			cbf.setCounter(idx, c+1)
+		}
+	}
+	atomic.AddInt64(&bf.count, 1)
+	bf.storeCount()
+	return nil
+}
+
+// Remove removes the key from the filter, decrementing its counters.
+// It returns ErrKeyNotFound if the key is not in the filter.
+func (cbf *CountingBloomFilter) Remove(key []byte) error {
+	bf := cbf.bf
+	bf.lock.Lock()
+	defer bf.lock.Unlock()
+
+	indices := bf.candidates(key)
+	for _, idx := range indices {
+		if cbf.counter(idx) == 0 {
+			return ErrKeyNotFound
+		}
+	}
+
+	for _, idx := range indices {
+		if c := cbf.counter(idx); c < cbf.maxCount {
+			cbf.setCounter(idx, c-1)
+		}
+	}
+	if bf.Count() > 0 {
+		atomic.AddInt64(&bf.count, -1)
+	}
+	bf.storeCount()
+	return nil
+}
+
+// Contains checks if the key exists in the filter
+func (cbf *CountingBloomFilter) Contains(key []byte) bool {
+	indices := cbf.bf.candidates(key)
+
+	// the counters are written a byte at a time by Add and Remove
+	cbf.bf.lock.RLock()
+	defer cbf.bf.lock.RUnlock()
+
+	for _, idx := range indices {
+		if cbf.counter(idx) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Put adds the key to the filter, and also stores it in the persistent store
+func (cbf *CountingBloomFilter) Put(key, val []byte) error {
+	if !cbf.bf.hasStore() {
+		return fmt.Errorf("%w, use Add() to add keys", ErrNoStore)
+	}
+
+	if err := cbf.Add(key); err != nil {
+		return err
+	}
+	return cbf.bf.db.Put(key, val)
+}
+
+// Get gets the key from the underlying persistent store
+func (cbf *CountingBloomFilter) Get(key []byte) []byte {
+	val, err := cbf.GetE(key)
+	if errors.Is(err, ErrNoStore) {
+		log.Panicf("CountingBloomFilter has no persistent store. Use Contains() instead")
+	}
+	if err != nil {
+		fmt.Printf("Error getting key %s from db: %s\n", key, err)
+		return nil
+	}
+	return val
+}
+
+// GetE gets the key from the underlying persistent store like Get, but returns an error instead of panicking.
+// A nil value is returned if the key is not found.
+func (cbf *CountingBloomFilter) GetE(key []byte) ([]byte, error) {
+	if !cbf.bf.hasStore() {
+		return nil, fmt.Errorf("%w, use Contains() instead", ErrNoStore)
+	}
+
+	if !cbf.Contains(key) {
+		return nil, nil
+	}
+
+	return cbf.bf.db.Get(key)
+}
+
+// Delete removes the key from the persistent store and from the filter
+func (cbf *CountingBloomFilter) Delete(key []byte) error {
+	if !cbf.bf.hasStore() {
+		return fmt.Errorf("%w, use Remove() to remove keys", ErrNoStore)
+	}
+
+	// the key is removed from the store first, so that the filter never misses a stored key
+	if err := cbf.bf.db.Delete(key); err != nil {
+		return err
+	}
+	return cbf.Remove(key)
+}
+
+// counter returns the value of the counter at idx
+func (cbf *CountingBloomFilter) counter(idx uint64) uint64 {
+	mem := cbf.bf.mem[cbf.bf.bitOffset:]
+	switch cbf.counterBits {
+	case 4:
+		// two counters per byte, the first one in the high nibble
+		b := mem[idx/2]
+		if idx%2 == 0 {
+			return uint64(b >> 4)
+		}
+		return uint64(b & 0x0F)
+	case 8:
+		return uint64(mem[idx])
+	default:
+		return uint64(binary.LittleEndian.Uint16(mem[2*idx:]))
+	}
+}
+
+// setCounter sets the value of the counter at idx
+func (cbf *CountingBloomFilter) setCounter(idx, val uint64) {
+	mem := cbf.bf.mem[cbf.bf.bitOffset:]
+	switch cbf.counterBits {
+	case 4:
+		if idx%2 == 0 {
+			mem[idx/2] = mem[idx/2]&0x0F | byte(val)<<4
+		} else {
+			mem[idx/2] = mem[idx/2]&0xF0 | byte(val)
+		}
+	case 8:
+		mem[idx] = byte(val)
+	default:
+		binary.LittleEndian.PutUint16(mem[2*idx:], uint16(val))
+	}
+}
+
+// Capacity returns the capacity of the filter
+func (cbf *CountingBloomFilter) Capacity() int {
+	return cbf.bf.capacity
+}
+
+// Count returns the number of items in the filter
+func (cbf *CountingBloomFilter) Count() int {
+	return cbf.bf.Count()
+}
+
+// FilterSize returns the size of the counter array in bytes
+func (cbf *CountingBloomFilter) FilterSize() int {
+	return cbf.bf.bit_width
+}
+
+// DB returns the underlying persistent store
+func (cbf *CountingBloomFilter) DB() interface{} {
+	return cbf.bf.DB()
+}
+
+// Clear resets all counters in the filter
+func (cbf *CountingBloomFilter) Clear() {
+	cbf.bf.Clear()
+}
+
+// ClearE resets all counters in the filter like Clear, but returns an error instead of exiting.
+func (cbf *CountingBloomFilter) ClearE() error {
+	return cbf.bf.ClearE()
+}
+
+// Stats returns the stats of the filter
+func (cbf *CountingBloomFilter) Stats() BloomFilterStats {
+	return cbf.bf.Stats()
+}
+
+// Close flushes the filter to disk and closes the file handle to the filter
+func (cbf *CountingBloomFilter) Close() error {
+	return cbf.bf.Close()
+}
+
+var _ KeyValueFilter = (*CountingBloomFilter)(nil)
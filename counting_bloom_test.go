@@ -0,0 +1,230 @@
+package sprout
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+)
+
+func TestCountingBloomFilter_Remove(t *testing.T) {
+	for _, bits := range []int{4, 8, 16} {
+		t.Run(fmt.Sprintf("%d bit counters", bits), func(t *testing.T) {
+			opts := &BloomOptions{
+				Err_rate:    0.01,
+				Capacity:    1000,
+				CounterBits: bits,
+				Path:        "./test.db",
+			}
+			cbf := NewCountingBloom(opts)
+			defer func() {
+				cbf.Close()
+				os.Remove(opts.Path)
+			}()
+
+			for i := 0; i < 100; i++ {
+				cbf.Add([]byte(fmt.Sprintf("foo%d", i)))
+			}
+			for i := 0; i < 50; i++ {
+				if err := cbf.Remove([]byte(fmt.Sprintf("foo%d", i))); err != nil {
+					t.Fatalf("Expected no error removing key foo%d, got %v", i, err)
+				}
+			}
+
+			if cbf.Count() != 50 {
+				t.Errorf("Expected count to be 50, got %d", cbf.Count())
+			}
+			for i := 50; i < 100; i++ {
+				if !cbf.Contains([]byte(fmt.Sprintf("foo%d", i))) {
+					t.Errorf("Expected key foo%d to be found after removing other keys", i)
+				}
+			}
+			found := 0
+			for i := 0; i < 50; i++ {
+				if cbf.Contains([]byte(fmt.Sprintf("foo%d", i))) {
+					found++
+				}
+			}
+			if found > 5 {
+				t.Errorf("Expected removed keys to not be found, found %d of 50", found)
+			}
+		})
+	}
+
+	t.Run("removing a key that was not added should fail", func(t *testing.T) {
+		opts := &BloomOptions{
+			Err_rate: 0.01,
+			Capacity: 1000,
+			Path:     "./test.db",
+		}
+		cbf := NewCountingBloom(opts)
+		defer func() {
+			cbf.Close()
+			os.Remove(opts.Path)
+		}()
+
+		if err := cbf.Remove([]byte("foo")); !errors.Is(err, ErrKeyNotFound) {
+			t.Errorf("Expected ErrKeyNotFound, got %v", err)
+		}
+	})
+
+	t.Run("saturated counters should not be decremented", func(t *testing.T) {
+		opts := &BloomOptions{
+			Err_rate: 0.01,
+			Capacity: 1000,
+			Path:     "./test.db",
+		}
+		cbf := NewCountingBloom(opts)
+		defer func() {
+			cbf.Close()
+			os.Remove(opts.Path)
+		}()
+
+		key := []byte("foo")
+		for i := 0; i < 20; i++ {
+			cbf.Add(key)
+		}
+		for i := 0; i < 20; i++ {
+			cbf.Remove(key)
+		}
+		if !cbf.Contains(key) {
+			t.Errorf("Expected key to still be found after its counters overflowed")
+		}
+	})
+
+	t.Run("invalid counter bits should return an error", func(t *testing.T) {
+		_, err := NewCountingBloomE(&BloomOptions{Err_rate: 0.01, Capacity: 1000, CounterBits: 3, Path: "./test.db"})
+		if !errors.Is(err, ErrInvalidOptions) {
+			t.Errorf("Expected ErrInvalidOptions, got %v", err)
+		}
+	})
+}
+
+func TestCountingBloomFilter_Delete(t *testing.T) {
+	store, cleanupFunc := DBSetupTest(t)
+	defer cleanupFunc()
+	opts := &BloomOptions{
+		Err_rate: 0.01,
+		Capacity: 1000,
+		Database: store,
+		Path:     "./test.db",
+	}
+	cbf := NewCountingBloom(opts)
+	defer func() {
+		cbf.Close()
+		os.Remove(opts.Path)
+	}()
+
+	key, val := []byte("foo"), []byte("bar")
+	cbf.Put(key, val)
+	if got := cbf.Get(key); string(got) != "bar" {
+		t.Fatalf("Expected value bar, got %s", got)
+	}
+
+	if err := cbf.Delete(key); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if cbf.Contains(key) {
+		t.Errorf("Expected key to not be found in the filter after delete")
+	}
+	if val, err := store.Get(key); err != nil || val != nil {
+		t.Errorf("Expected key to be deleted from the store, got %s; error: %v", val, err)
+	}
+}
+
+func TestNewCountingBloomE(t *testing.T) {
+	defer os.Remove("./test.db")
+
+	_, err := NewCountingBloomE(&BloomOptions{Err_rate: 0.01, Capacity: 1000, Path: "./test.db", FormatVersion: formatVersion3, Hasher: HasherMurmur2})
+	if !errors.Is(err, ErrInvalidOptions) {
+		t.Errorf("Expected ErrInvalidOptions with format version 3, got %v", err)
+	}
+}
+
+func TestOpenCountingBloom(t *testing.T) {
+	opts := &BloomOptions{
+		Err_rate:    0.01,
+		Capacity:    1000,
+		CounterBits: 8,
+		Path:        "./test.db",
+	}
+	cbf := NewCountingBloom(opts)
+	defer os.Remove(opts.Path)
+	cbf.Add([]byte("foo"))
+	cbf.Add([]byte("bar"))
+	cbf.Close()
+
+	t.Run("should restore the counters", func(t *testing.T) {
+		cbf, err := OpenCountingBloom(opts.Path)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		defer cbf.Close()
+
+		if cbf.counterBits != 8 || cbf.Count() != 2 {
+			t.Errorf("Expected 8 bit counters and count 2, got %d and %d", cbf.counterBits, cbf.Count())
+		}
+		if err := cbf.Remove([]byte("foo")); err != nil {
+			t.Errorf("Expected no error, got %v", err)
+		}
+		if !cbf.Contains([]byte("bar")) {
+			t.Errorf("Expected key bar to be found")
+		}
+	})
+
+	t.Run("should not be opened as a bloom filter", func(t *testing.T) {
+		if _, err := OpenBloom(opts.Path); !errors.Is(err, ErrInvalidHeader) {
+			t.Errorf("Expected ErrInvalidHeader, got %v", err)
+		}
+	})
+}
+
+func TestCountingBloomFilter_Concurrent(t *testing.T) {
+	opts := &BloomOptions{
+		Err_rate:    0.01,
+		Capacity:    1000,
+		Path:        "./test.db",
+		CounterBits: 16,
+	}
+	cbf := NewCountingBloom(opts)
+	defer func() {
+		cbf.Close()
+		os.Remove(opts.Path)
+	}()
+	cbf.Add([]byte("foo"))
+
+	workers, n := 8, 300
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(2)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < n; i++ {
+				key := []byte(fmt.Sprintf("key%d-%d", w, i%50))
+				if err := cbf.Add(key); err != nil {
+					t.Errorf("Expected no error, got %v", err)
+					return
+				}
+				if err := cbf.Remove(key); err != nil {
+					t.Errorf("Expected no error, got %v", err)
+					return
+				}
+			}
+		}(w)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < n; i++ {
+				if !cbf.Contains([]byte("foo")) {
+					t.Errorf("Expected key foo to be found while other keys are added and removed")
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if cbf.Count() != 1 {
+		t.Errorf("Expected count to be 1, got %d", cbf.Count())
+	}
+}
@@ -0,0 +1,403 @@
+package sprout
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+)
+
+const (
+	// bucketSize is the number of fingerprints per bucket
+	bucketSize = 4
+
+	// maxKicks is the number of relocations tried before an insertion fails
+	maxKicks = 500
+
+	// the load factor the buckets are sized for
+	cuckooLoadFactor = 0.95
+
+	cuckooSeed = 64
+)
+
+// CuckooFilter is a cuckoo filter, as described in the paper by
+// B. Fan, D. G. Andersen, M. Kaminsky and M. D. Mitzenmacher [3].
+//
+// A key is stored as a small fingerprint in one of two candidate buckets.
+// Unlike bloom filters, keys can be removed, and the filter reports a failed
+// insertion when the table is full.
+type CuckooFilter struct {
+	// the underlying filter holds the buckets in its mmaped region
+	bf *BloomFilter
+
+	// the number of bits per fingerprint
+	fpBits int
+
+	// the number of buckets, a power of two
+	numBuckets uint64
+}
+
+// NewCuckoo creates a new cuckoo filter.
+// err_rate is the desired false error rate. e.g. 0.001 implies 1 false positive in 1000 lookups
+//
+// capacity is the number of entries intended to be added to the filter
+//
+// database is the persistent store to attach to the filter. can be nil.
+//
+// NewCuckoo panics if the filter cannot be created, use NewCuckooE to get an error instead.
+func NewCuckoo(opts *BloomOptions) *CuckooFilter {
+	cf, err := NewCuckooE(opts)
+	if err != nil {
+		log.Panicf("%v", err)
+	}
+	return cf
+}
+
+// NewCuckooE creates a new cuckoo filter like NewCuckoo, but returns an error instead of panicking.
+func NewCuckooE(opts *BloomOptions) (*CuckooFilter, error) {
+	if opts == nil {
+		opts = &DefaultBloomOptions
+	}
+	if err := validateOptions(opts); err != nil {
+		return nil, err
+	}
+
+	// the buckets of a key are always computed from a single hash
+	if opts.FormatVersion != 0 && opts.FormatVersion != formatVersion2 {
+		return nil, fmt.Errorf("%w: cuckoo filters require format version %d", ErrInvalidOptions, formatVersion2)
+	}
+
+	// the false positive rate is at most 2*bucketSize/2^f for f bit fingerprints
+	fpBits := 8
+	for fpBits < 32 && 2*bucketSize/math.Pow(2, float64(fpBits)) > opts.Err_rate {
+		fpBits *= 2
+	}
+
+	numBuckets := uint64(1)
+	for float64(numBuckets*bucketSize)*cuckooLoadFactor < float64(opts.Capacity) {
+		numBuckets <<= 1
+	}
+
+	var b byte
+	bf := &BloomFilter{
+		err_rate:  opts.Err_rate,
+		capacity:  opts.Capacity,
+		bit_width: int(numBuckets) * bucketSize * fpBits / 8,
+		m:         int(numBuckets),
+		k:         1,
+		seeds:     []int64{cuckooSeed},
+		db:        opts.Database,
+		lock:      sync.RWMutex{},
+		byteSize:  int(unsafe.Sizeof(&b)),
+		kind:      cuckooHeaderMagic,
+		cellBits:  fpBits,
+		version:   formatVersion2,
+		hasher:    newHasher(opts),
+	}
+	if err := bf.open(opts); err != nil {
+		return nil, err
+	}
+	return newCuckooFilter(bf), nil
+}
+
+// OpenCuckoo restores the cuckoo filter stored in the file at path.
+//
+// database is the persistent store to attach to the filter. can be omitted.
+func OpenCuckoo(path string, database ...Store) (*CuckooFilter, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open bloom filter file: %w", err)
+	}
+	h, err := readHeader(file, 0)
+	file.Close()
+	if err != nil {
+		return nil, err
+	}
+	if h.magic != cuckooHeaderMagic {
+		return nil, fmt.Errorf("%w: not a cuckoo filter", ErrInvalidHeader)
+	}
+
+	opts := &BloomOptions{
+		Path:     path,
+		Err_rate: h.errRate,
+		Capacity: int(h.capacity),
+	}
+	if len(database) > 0 {
+		opts.Database = database[0]
+	}
+
+	bf := bloomFromHeader(h, opts.Database)
+	if err := bf.open(opts); err != nil {
+		return nil, err
+	}
+	return newCuckooFilter(bf), nil
+}
+
+func newCuckooFilter(bf *BloomFilter) *CuckooFilter {
+	return &CuckooFilter{
+		bf:         bf,
+		fpBits:     bf.cellBits,
+		numBuckets: uint64(bf.m),
+	}
+}
+
+// Add adds the key to the filter.
+// It returns an error wrapping ErrCapacityReached if the key cannot be placed
+// in the table, in which case the filter is left unchanged.
+func (cf *CuckooFilter) Add(key []byte) error {
+	bf := cf.bf
+	bf.lock.Lock()
+	defer bf.lock.Unlock()
+
+	fp, i1 := cf.fingerprint(key)
+	i2 := cf.altIndex(i1, fp)
+	if cf.insert(i1, fp) || cf.insert(i2, fp) {
+		atomic.AddInt64(&bf.count, 1)
+		bf.storeCount()
+		return nil
+	}
+
+	// relocate existing fingerprints to their alternate bucket to make room,
+	// keeping track of the swaps so that they can be undone if no room is found
+	type swap struct {
+		bucket uint64
+		slot   int
+		fp     uint32
+	}
+	swaps := make([]swap, 0, maxKicks)
+
+	i := i1
+	if rand.Intn(2) == 1 {
+		i = i2
+	}
+	for n := 0; n < maxKicks; n++ {
+		slot := rand.Intn(bucketSize)
+		evicted := cf.entry(i, slot)
+		cf.setEntry(i, slot, fp)
+		swaps = append(swaps, swap{i, slot, evicted})
+
+		fp = evicted
+		i = cf.altIndex(i, fp)
+		if cf.insert(i, fp) {
+			atomic.AddInt64(&bf.count, 1)
+			bf.storeCount()
+			return nil
+		}
+	}
+
+	for n := len(swaps) - 1; n >= 0; n-- {
+		cf.setEntry(swaps[n].bucket, swaps[n].slot, swaps[n].fp)
+	}
+	return fmt.Errorf("%w: no room for key after %d relocations", ErrCapacityReached, maxKicks)
+}
+
+// Contains checks if the key exists in the filter
+func (cf *CuckooFilter) Contains(key []byte) bool {
+	fp, i1 := cf.fingerprint(key)
+	i2 := cf.altIndex(i1, fp)
+
+	// Add moves the fingerprints of other keys between their buckets while relocating them
+	cf.bf.lock.RLock()
+	defer cf.bf.lock.RUnlock()
+
+	return cf.find(i1, fp) >= 0 || cf.find(i2, fp) >= 0
+}
+
+// Remove removes one occurrence of the key from the filter.
+// It returns ErrKeyNotFound if the key is not in the filter.
+func (cf *CuckooFilter) Remove(key []byte) error {
+	bf := cf.bf
+	bf.lock.Lock()
+	defer bf.lock.Unlock()
+
+	fp, i1 := cf.fingerprint(key)
+	for _, i := range []uint64{i1, cf.altIndex(i1, fp)} {
+		if slot := cf.find(i, fp); slot >= 0 {
+			cf.setEntry(i, slot, 0)
+			atomic.AddInt64(&bf.count, -1)
+			bf.storeCount()
+			return nil
+		}
+	}
+	return ErrKeyNotFound
+}
+
+// Delete removes the key from the persistent store and from the filter
+func (cf *CuckooFilter) Delete(key []byte) error {
+	if !cf.bf.hasStore() {
+		return fmt.Errorf("%w, use Remove() to remove keys", ErrNoStore)
+	}
+
+	// the key is removed from the store first, so that the filter never misses a stored key
+	if err := cf.bf.db.Delete(key); err != nil {
+		return err
+	}
+	return cf.Remove(key)
+}
+
+// Put adds the key to the filter, and also stores it in the persistent store
+func (cf *CuckooFilter) Put(key, val []byte) error {
+	if !cf.bf.hasStore() {
+		return fmt.Errorf("%w, use Add() to add keys", ErrNoStore)
+	}
+
+	if err := cf.Add(key); err != nil {
+		return err
+	}
+	return cf.bf.db.Put(key, val)
+}
+
+// Get gets the key from the underlying persistent store
+func (cf *CuckooFilter) Get(key []byte) []byte {
+	val, err := cf.GetE(key)
+	if errors.Is(err, ErrNoStore) {
+		log.Panicf("CuckooFilter has no persistent store. Use Contains() instead")
+	}
+	if err != nil {
+		fmt.Printf("Error getting key %s from db: %s\n", key, err)
+		return nil
+	}
+	return val
+}
+
+// GetE gets the key from the underlying persistent store like Get, but returns an error instead of panicking.
+// A nil value is returned if the key is not found.
+func (cf *CuckooFilter) GetE(key []byte) ([]byte, error) {
+	if !cf.bf.hasStore() {
+		return nil, fmt.Errorf("%w, use Contains() instead", ErrNoStore)
+	}
+
+	if !cf.Contains(key) {
+		return nil, nil
+	}
+
+	return cf.bf.db.Get(key)
+}
+
+// fingerprint returns the non-zero fingerprint of the key and its first bucket
+func (cf *CuckooFilter) fingerprint(key []byte) (uint32, uint64) {
+	hash := cf.bf.hasher.Sum64(key, uint64(cf.bf.seeds[0]))
+
+	// the low bits pick the bucket, the high bits the fingerprint
+	fp := uint32(hash>>32) & cf.fpMask()
+	if fp == 0 {
+		fp = 1
+	}
+	return fp, hash & (cf.numBuckets - 1)
+}
+
+// altIndex returns the other candidate bucket of a fingerprint stored in bucket i
+func (cf *CuckooFilter) altIndex(i uint64, fp uint32) uint64 {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], fp)
+	return (i ^ cf.bf.hasher.Sum64(b[:], cuckooSeed)) & (cf.numBuckets - 1)
+}
+
+func (cf *CuckooFilter) fpMask() uint32 {
+	return uint32(uint64(1)<<cf.fpBits - 1)
+}
+
+// insert stores the fingerprint in a free slot of bucket i, if any
+func (cf *CuckooFilter) insert(i uint64, fp uint32) bool {
+	for slot := 0; slot < bucketSize; slot++ {
+		if cf.entry(i, slot) == 0 {
+			cf.setEntry(i, slot, fp)
+			return true
+		}
+	}
+	return false
+}
+
+// find returns the slot holding the fingerprint in bucket i, or -1
+func (cf *CuckooFilter) find(i uint64, fp uint32) int {
+	for slot := 0; slot < bucketSize; slot++ {
+		if cf.entry(i, slot) == fp {
+			return slot
+		}
+	}
+	return -1
+}
+
+// entry returns the fingerprint in the slot of bucket i, 0 if the slot is empty
+func (cf *CuckooFilter) entry(i uint64, slot int) uint32 {
+	pos := (int(i)*bucketSize + slot) * cf.fpBits / 8
+	mem := cf.bf.mem[cf.bf.bitOffset+pos:]
+	switch cf.fpBits {
+	case 8:
+		return uint32(mem[0])
+	case 16:
+		return uint32(binary.LittleEndian.Uint16(mem))
+	default:
+		return binary.LittleEndian.Uint32(mem)
+	}
+}
+
+// setEntry stores the fingerprint in the slot of bucket i
+func (cf *CuckooFilter) setEntry(i uint64, slot int, fp uint32) {
+	pos := (int(i)*bucketSize + slot) * cf.fpBits / 8
+	mem := cf.bf.mem[cf.bf.bitOffset+pos:]
+	switch cf.fpBits {
+	case 8:
+		mem[0] = byte(fp)
+	case 16:
+		binary.LittleEndian.PutUint16(mem, uint16(fp))
+	default:
+		binary.LittleEndian.PutUint32(mem, fp)
+	}
+}
+
+// Capacity returns the capacity of the filter
+func (cf *CuckooFilter) Capacity() int {
+	return cf.bf.capacity
+}
+
+// Count returns the number of items in the filter
+func (cf *CuckooFilter) Count() int {
+	return cf.bf.Count()
+}
+
+// FilterSize returns the size of the buckets in bytes
+func (cf *CuckooFilter) FilterSize() int {
+	return cf.bf.bit_width
+}
+
+// DB returns the underlying persistent store
+func (cf *CuckooFilter) DB() interface{} {
+	return cf.bf.DB()
+}
+
+// Clear removes all keys from the filter
+func (cf *CuckooFilter) Clear() {
+	cf.bf.Clear()
+}
+
+// ClearE removes all keys from the filter like Clear, but returns an error instead of exiting.
+func (cf *CuckooFilter) ClearE() error {
+	return cf.bf.ClearE()
+}
+
+// Stats returns the stats of the filter.
+// M is the number of buckets and K the number of fingerprints per bucket.
+func (cf *CuckooFilter) Stats() BloomFilterStats {
+	return BloomFilterStats{
+		Capacity: cf.bf.capacity,
+		Count:    cf.bf.Count(),
+		Size:     cf.bf.bit_width,
+		M:        int(cf.numBuckets),
+		K:        bucketSize,
+		Prob:     cf.bf.err_rate,
+	}
+}
+
+// Close flushes the filter to disk and closes the file handle to the filter
+func (cf *CuckooFilter) Close() error {
+	return cf.bf.Close()
+}
+
+var _ KeyValueFilter = (*CuckooFilter)(nil)
@@ -0,0 +1,211 @@
+package sprout
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+)
+
+func TestCuckooFilter(t *testing.T) {
+	opts := &BloomOptions{
+		Err_rate: 0.01,
+		Capacity: 1000,
+		Path:     "./test.db",
+	}
+	cf := NewCuckoo(opts)
+	defer func() {
+		cf.Close()
+		os.Remove(opts.Path)
+	}()
+
+	for i := 0; i < opts.Capacity; i++ {
+		if err := cf.Add([]byte(fmt.Sprintf("foo%d", i))); err != nil {
+			t.Fatalf("Expected no error adding key foo%d, got %v", i, err)
+		}
+	}
+
+	t.Run("should find all added keys", func(t *testing.T) {
+		for i := 0; i < opts.Capacity; i++ {
+			if !cf.Contains([]byte(fmt.Sprintf("foo%d", i))) {
+				t.Errorf("Expected key foo%d to be found", i)
+			}
+		}
+	})
+
+	t.Run("false positive rate should be close to the error rate", func(t *testing.T) {
+		found := 0
+		for i := 0; i < 10000; i++ {
+			if cf.Contains([]byte(fmt.Sprintf("bar%d", i))) {
+				found++
+			}
+		}
+		if rate := float64(found) / 10000; rate > opts.Err_rate*2 {
+			t.Errorf("Expected false positive rate to be at most %v, got %v", opts.Err_rate*2, rate)
+		}
+	})
+
+	t.Run("removed keys should not be found", func(t *testing.T) {
+		for i := 0; i < opts.Capacity/2; i++ {
+			if err := cf.Remove([]byte(fmt.Sprintf("foo%d", i))); err != nil {
+				t.Fatalf("Expected no error removing key foo%d, got %v", i, err)
+			}
+		}
+		if cf.Count() != opts.Capacity/2 {
+			t.Errorf("Expected count to be %d, got %d", opts.Capacity/2, cf.Count())
+		}
+		for i := opts.Capacity / 2; i < opts.Capacity; i++ {
+			if !cf.Contains([]byte(fmt.Sprintf("foo%d", i))) {
+				t.Errorf("Expected key foo%d to be found", i)
+			}
+		}
+		if err := cf.Remove([]byte("qux")); !errors.Is(err, ErrKeyNotFound) {
+			t.Errorf("Expected ErrKeyNotFound, got %v", err)
+		}
+	})
+}
+
+func TestCuckooFilter_Full(t *testing.T) {
+	opts := &BloomOptions{
+		Err_rate: 0.01,
+		Capacity: 100,
+		Path:     "./test.db",
+	}
+	cf := NewCuckoo(opts)
+	defer func() {
+		cf.Close()
+		os.Remove(opts.Path)
+	}()
+
+	var err error
+	added := 0
+	for ; added < cf.FilterSize(); added++ {
+		if err = cf.Add([]byte(fmt.Sprintf("foo%d", added))); err != nil {
+			break
+		}
+	}
+	if !errors.Is(err, ErrCapacityReached) {
+		t.Fatalf("Expected ErrCapacityReached once the table is full, got %v", err)
+	}
+	if cf.Count() != added {
+		t.Errorf("Expected count to be %d, got %d", added, cf.Count())
+	}
+	for i := 0; i < added; i++ {
+		if !cf.Contains([]byte(fmt.Sprintf("foo%d", i))) {
+			t.Fatalf("Expected key foo%d to be found after a failed insertion", i)
+		}
+	}
+}
+
+func TestNewCuckooE(t *testing.T) {
+	defer os.Remove("./test.db")
+
+	_, err := NewCuckooE(&BloomOptions{Err_rate: 0.01, Capacity: 1000, Path: "./test.db", FormatVersion: formatVersion1})
+	if !errors.Is(err, ErrInvalidOptions) {
+		t.Errorf("Expected ErrInvalidOptions with format version 1, got %v", err)
+	}
+
+	opts := &BloomOptions{Err_rate: 0.01, Capacity: 1000, Path: "./test.db", FormatVersion: formatVersion2}
+	cf, err := NewCuckooE(opts)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	cf.Close()
+
+	cf, err = NewCuckooE(opts)
+	if err != nil {
+		t.Fatalf("Expected the filter to be reopened with the same options, got %v", err)
+	}
+	cf.Close()
+}
+
+func TestCuckooFilter_Concurrent(t *testing.T) {
+	opts := &BloomOptions{
+		Err_rate: 0.01,
+		Capacity: 1000,
+		Path:     "./test.db",
+	}
+	cf := NewCuckoo(opts)
+	defer func() {
+		cf.Close()
+		os.Remove(opts.Path)
+	}()
+
+	// a loaded table, so that the keys added next relocate the fingerprints of these ones
+	n := cf.Capacity() * 8 / 10
+	for i := 0; i < n; i++ {
+		if err := cf.Add([]byte(fmt.Sprintf("foo%d", i))); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			if err := cf.Add([]byte(fmt.Sprintf("bar%d", i))); err != nil {
+				return
+			}
+		}
+	}()
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < n; i++ {
+				if !cf.Contains([]byte(fmt.Sprintf("foo%d", i))) {
+					t.Errorf("Expected key foo%d to be found while keys are relocated", i)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestCuckooFilter_Store(t *testing.T) {
+	store, cleanupFunc := DBSetupTest(t)
+	defer cleanupFunc()
+	opts := &BloomOptions{
+		Err_rate: 0.01,
+		Capacity: 1000,
+		Database: store,
+		Path:     "./test.db",
+	}
+	cf := NewCuckoo(opts)
+	defer os.Remove(opts.Path)
+
+	key, val := []byte("foo"), []byte("bar")
+	cf.Put(key, val)
+	cf.Put([]byte("baz"), val)
+	if got := cf.Get(key); string(got) != "bar" {
+		t.Errorf("Expected value bar, got %s", got)
+	}
+	if err := cf.Delete(key); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if cf.Contains(key) {
+		t.Errorf("Expected key to not be found after delete")
+	}
+	if val, err := store.Get(key); err != nil || val != nil {
+		t.Errorf("Expected key to be deleted from the store, got %s; error: %v", val, err)
+	}
+	cf.Close()
+
+	t.Run("should restore the filter from the file", func(t *testing.T) {
+		cf, err := OpenCuckoo(opts.Path, store)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		defer cf.Close()
+
+		if cf.Count() != 1 || !cf.Contains([]byte("baz")) {
+			t.Errorf("Expected the restored filter to hold key baz")
+		}
+		if got := cf.Get([]byte("baz")); string(got) != "bar" {
+			t.Errorf("Expected value bar, got %s", got)
+		}
+	})
+}
@@ -0,0 +1,17 @@
+package sprout
+
+import "errors"
+
+var (
+	// ErrFileLocked is returned when the filter file is locked by another filter or process
+	ErrFileLocked = errors.New("file is locked by another process")
+
+	// ErrInvalidOptions is returned when the options of a filter are not valid
+	ErrInvalidOptions = errors.New("invalid filter options")
+
+	// ErrCapacityReached is returned when a key is added to a filter that is full
+	ErrCapacityReached = errors.New("filter has reached full capacity")
+
+	// ErrNoStore is returned when a store operation is used on a filter without a persistent store
+	ErrNoStore = errors.New("filter does not have a persistent store")
+)
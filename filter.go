@@ -0,0 +1,39 @@
+package sprout
+
+// Filter is the interface implemented by all the filters in sprout,
+// so that implementations can be swapped by configuration.
+type Filter interface {
+	// Add adds the key to the filter
+	Add(key []byte) error
+
+	// Contains checks if the key may be in the filter
+	Contains(key []byte) bool
+
+	// Count returns the number of items added to the filter
+	Count() int
+
+	// Capacity returns the number of items the filter is intended to hold
+	Capacity() int
+
+	Stats() BloomFilterStats
+	Close() error
+}
+
+// KeyValueFilter is a Filter with a persistent store attached,
+// which keeps the values of the keys added to the filter.
+type KeyValueFilter interface {
+	Filter
+
+	// Put adds the key to the filter and stores its value in the persistent store
+	Put(key, val []byte) error
+
+	// Get returns the value of the key from the persistent store, or nil if it is not found
+	Get(key []byte) []byte
+
+	// GetE is like Get, but returns an error instead of panicking
+	GetE(key []byte) ([]byte, error)
+
+	// Delete removes the key from the persistent store,
+	// and from the filter if the filter supports removing keys
+	Delete(key []byte) error
+}
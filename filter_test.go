@@ -0,0 +1,94 @@
+package sprout
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestFilter(t *testing.T) {
+	opts := &BloomOptions{
+		Err_rate: 0.01,
+		Capacity: 1000,
+		Path:     "./test.db",
+	}
+	filters := map[string]func() Filter{
+		"bloom":    func() Filter { return NewBloom(opts) },
+		"scalable": func() Filter { return NewScalableBloom(opts) },
+		"bloom2":   func() Filter { return NewBloom2(opts) },
+		"counting": func() Filter { return NewCountingBloom(opts) },
+		"cuckoo":   func() Filter { return NewCuckoo(opts) },
+		"blocked":  func() Filter { return NewBlockedBloom(opts) },
+		"sliding":  func() Filter { return NewSlidingBloom(opts) },
+	}
+
+	for name, newFilter := range filters {
+		t.Run(name, func(t *testing.T) {
+			f := newFilter()
+			defer func() {
+				f.Close()
+				os.Remove(opts.Path)
+			}()
+
+			for i := 0; i < opts.Capacity; i++ {
+				if err := f.Add([]byte(fmt.Sprintf("foo%d", i))); err != nil {
+					t.Fatalf("Expected no error adding key foo%d, got %v", i, err)
+				}
+			}
+			for i := 0; i < opts.Capacity; i++ {
+				if !f.Contains([]byte(fmt.Sprintf("foo%d", i))) {
+					t.Fatalf("Expected key foo%d to be found", i)
+				}
+			}
+			if f.Count() != opts.Capacity || f.Stats().Count != opts.Capacity {
+				t.Errorf("Expected count to be %d, got %d", opts.Capacity, f.Count())
+			}
+		})
+	}
+}
+
+func TestKeyValueFilter(t *testing.T) {
+	store, cleanupFunc := DBSetupTest(t)
+	defer cleanupFunc()
+	opts := &BloomOptions{
+		Err_rate: 0.01,
+		Capacity: 1000,
+		Database: store,
+		Path:     "./test.db",
+	}
+	filters := map[string]func() KeyValueFilter{
+		"bloom":    func() KeyValueFilter { return NewBloom(opts) },
+		"scalable": func() KeyValueFilter { return NewScalableBloom(opts) },
+		"bloom2":   func() KeyValueFilter { return NewBloom2(opts) },
+		"counting": func() KeyValueFilter { return NewCountingBloom(opts) },
+		"cuckoo":   func() KeyValueFilter { return NewCuckoo(opts) },
+		"blocked":  func() KeyValueFilter { return NewBlockedBloom(opts) },
+		"sliding":  func() KeyValueFilter { return NewSlidingBloom(opts) },
+		"stable":   func() KeyValueFilter { return NewStableBloom(opts) },
+	}
+
+	for name, newFilter := range filters {
+		t.Run(name, func(t *testing.T) {
+			f := newFilter()
+			defer func() {
+				os.Remove(opts.Path)
+			}()
+			key := []byte(fmt.Sprintf("foo-%s", name))
+
+			if err := f.Put(key, []byte("bar")); err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			val, err := f.GetE(key)
+			if err != nil || string(val) != "bar" {
+				t.Errorf("Expected value bar, got %s; error: %v", val, err)
+			}
+			if val := f.Get([]byte("baz")); val != nil {
+				t.Errorf("Expected value to be nil, got %s", val)
+			}
+			// BloomFilter2 closes its store on Close
+			if name != "bloom2" {
+				f.Close()
+			}
+		})
+	}
+}
@@ -3,14 +3,17 @@ module github.com/dsa0x/sprout
 go 1.17
 
 require (
+	github.com/cespare/xxhash/v2 v2.1.1
 	github.com/dgraph-io/badger/v3 v3.2103.2
 	github.com/edsrzf/mmap-go v1.1.0
+	github.com/juju/fslock v0.0.0-20160525022230-4d5c94c67b4b
 	go.etcd.io/bbolt v1.3.6
+	google.golang.org/grpc v1.43.0
+	google.golang.org/protobuf v1.26.0
 )
 
 require (
 	github.com/cespare/xxhash v1.1.0 // indirect
-	github.com/cespare/xxhash/v2 v2.1.1 // indirect
 	github.com/dgraph-io/ristretto v0.1.0 // indirect
 	github.com/dustin/go-humanize v1.0.0 // indirect
 	github.com/gogo/protobuf v1.3.2 // indirect
@@ -19,11 +22,11 @@ require (
 	github.com/golang/protobuf v1.5.2 // indirect
 	github.com/golang/snappy v0.0.3 // indirect
 	github.com/google/flatbuffers v2.0.0+incompatible // indirect
-	github.com/juju/fslock v0.0.0-20160525022230-4d5c94c67b4b // indirect
 	github.com/klauspost/compress v1.13.1 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
 	go.opencensus.io v0.23.0 // indirect
 	golang.org/x/net v0.0.0-20210614182718-04defd469f4e // indirect
 	golang.org/x/sys v0.0.0-20220204135822-1c1b9b1eba6a // indirect
-	google.golang.org/protobuf v1.26.0 // indirect
+	golang.org/x/text v0.3.6 // indirect
+	google.golang.org/genproto v0.0.0-20200526211855-cb27e3aa2013 // indirect
 )
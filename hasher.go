@@ -0,0 +1,144 @@
+package sprout
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/dsa0x/sprout/pkg/murmur"
+)
+
+// Hasher is the hash function used to compute the indices of the keys in a filter.
+//
+// The ID of the hasher is recorded in the filter file, and a filter can only be
+// reopened with the hasher it was created with. Hashers other than the built-in ones
+// must be registered with RegisterHasher before a filter using them is reopened.
+type Hasher interface {
+	// ID identifies the hash function in the filter file
+	ID() uint32
+
+	// Sum64 returns the hash of the key with the given seed
+	Sum64(key []byte, seed uint64) uint64
+
+	// Sum128 returns two independent hashes of the key with the given seed
+	Sum128(key []byte, seed uint64) (uint64, uint64)
+}
+
+// hash algorithms recorded in the header
+const (
+	hashMurmur3 uint32 = 1
+	hashMurmur2 uint32 = 2
+	hashXXHash  uint32 = 3
+	hashFNV1a   uint32 = 4
+)
+
+var (
+	// HasherMurmur3 is the 128-bit MurmurHash3, the default hasher
+	HasherMurmur3 Hasher = murmur3Hasher{}
+
+	// HasherMurmur2 is the 64-bit MurmurHash2 (MurmurHash64A), the hash used by RedisBloom
+	HasherMurmur2 Hasher = murmur2Hasher{}
+
+	// HasherXXHash is the 64-bit xxHash
+	HasherXXHash Hasher = &mixHasher{hashXXHash, xxhash.Sum64}
+
+	// HasherFNV1a is the 64-bit FNV-1a hash
+	HasherFNV1a Hasher = &mixHasher{hashFNV1a, fnv1a}
+)
+
+var (
+	hashersLock sync.RWMutex
+	hashers     = map[uint32]Hasher{
+		hashMurmur3: HasherMurmur3,
+		hashMurmur2: HasherMurmur2,
+		hashXXHash:  HasherXXHash,
+		hashFNV1a:   HasherFNV1a,
+	}
+)
+
+// RegisterHasher registers a hasher, so that the filters created with it can be reopened.
+// It returns an error if another hasher is registered with the same ID.
+func RegisterHasher(h Hasher) error {
+	hashersLock.Lock()
+	defer hashersLock.Unlock()
+
+	if existing, ok := hashers[h.ID()]; ok && existing != h {
+		return fmt.Errorf("%w: hasher %d is already registered", ErrInvalidOptions, h.ID())
+	}
+	hashers[h.ID()] = h
+	return nil
+}
+
+// hasherByID returns the registered hasher with the given ID, or nil
+func hasherByID(id uint32) Hasher {
+	hashersLock.RLock()
+	defer hashersLock.RUnlock()
+	return hashers[id]
+}
+
+type murmur3Hasher struct{}
+
+func (murmur3Hasher) ID() uint32 { return hashMurmur3 }
+
+func (murmur3Hasher) Sum64(key []byte, seed uint64) uint64 {
+	return murmur.Murmur3_64(key, seed)
+}
+
+func (murmur3Hasher) Sum128(key []byte, seed uint64) (uint64, uint64) {
+	return murmur.Murmur3_128(key, seed)
+}
+
+type murmur2Hasher struct{}
+
+func (murmur2Hasher) ID() uint32 { return hashMurmur2 }
+
+func (murmur2Hasher) Sum64(key []byte, seed uint64) uint64 {
+	return murmur.MurmurHash64A(key, seed)
+}
+
+// Sum128 seeds the second hash with the first one, like RedisBloom does
+func (murmur2Hasher) Sum128(key []byte, seed uint64) (uint64, uint64) {
+	h1 := murmur.MurmurHash64A(key, seed)
+	return h1, murmur.MurmurHash64A(key, h1)
+}
+
+// mixHasher seeds an unseeded 64-bit hash function by mixing the seed into its output
+type mixHasher struct {
+	id  uint32
+	sum func(key []byte) uint64
+}
+
+func (h *mixHasher) ID() uint32 { return h.id }
+
+func (h *mixHasher) Sum64(key []byte, seed uint64) uint64 {
+	return mix64(h.sum(key) ^ seed)
+}
+
+func (h *mixHasher) Sum128(key []byte, seed uint64) (uint64, uint64) {
+	sum := h.sum(key)
+	return mix64(sum ^ seed), mix64(sum ^ seed ^ 0x9e3779b97f4a7c15)
+}
+
+// mix64 is the finalizer of splitmix64, every bit of the input affects every bit of the output
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// fnv1a returns the 64-bit FNV-1a hash of the key, like hash/fnv without allocating
+func fnv1a(key []byte) uint64 {
+	const (
+		offset64 = 14695981039346656037
+		prime64  = 1099511628211
+	)
+	h := uint64(offset64)
+	for _, c := range key {
+		h ^= uint64(c)
+		h *= prime64
+	}
+	return h
+}
@@ -0,0 +1,159 @@
+package sprout
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestHasher(t *testing.T) {
+	builtins := map[string]Hasher{
+		"murmur3": HasherMurmur3,
+		"murmur2": HasherMurmur2,
+		"xxhash":  HasherXXHash,
+		"fnv1a":   HasherFNV1a,
+	}
+
+	for name, hasher := range builtins {
+		for _, version := range []int{formatVersion1, formatVersion2} {
+			t.Run(fmt.Sprintf("%s version %d", name, version), func(t *testing.T) {
+				opts := &BloomOptions{
+					Err_rate:      0.01,
+					Capacity:      1000,
+					Path:          "./test.db",
+					Hasher:        hasher,
+					FormatVersion: version,
+				}
+				bf := NewBloom(opts)
+				defer os.Remove(opts.Path)
+
+				for i := 0; i < 1000; i++ {
+					bf.Add([]byte(fmt.Sprintf("key%d", i)))
+				}
+				falsePositives := 0
+				for i := 0; i < 10000; i++ {
+					if bf.Contains([]byte(fmt.Sprintf("other%d", i))) {
+						falsePositives++
+					}
+				}
+				if rate := float64(falsePositives) / 10000; rate > 2*opts.Err_rate {
+					t.Errorf("Expected false positive rate close to %v, got %v", opts.Err_rate, rate)
+				}
+				bf.Close()
+
+				// the hasher is restored from the file
+				bf, err := OpenBloom(opts.Path)
+				if err != nil {
+					t.Fatalf("Expected no error, got %v", err)
+				}
+				if bf.hasher != hasher {
+					t.Errorf("Expected hasher %d, got %d", hasher.ID(), bf.hasher.ID())
+				}
+				for i := 0; i < 1000; i++ {
+					if !bf.Contains([]byte(fmt.Sprintf("key%d", i))) {
+						t.Fatalf("Expected key%d to be in the reopened filter", i)
+					}
+				}
+				bf.Close()
+			})
+		}
+	}
+
+	t.Run("it rejects reopening a filter with a different hasher", func(t *testing.T) {
+		opts := &BloomOptions{Err_rate: 0.01, Capacity: 1000, Path: "./test.db", Hasher: HasherXXHash}
+		bf := NewBloom(opts)
+		bf.Close()
+		defer os.Remove(opts.Path)
+
+		opts.Hasher = HasherFNV1a
+		if _, err := NewBloomE(opts); !errors.Is(err, ErrHeaderMismatch) {
+			t.Errorf("Expected ErrHeaderMismatch, got %v", err)
+		}
+
+		// the hasher of the file is used if none is set
+		opts.Hasher = nil
+		bf, err := NewBloomE(opts)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		defer bf.Close()
+		if bf.hasher != HasherXXHash {
+			t.Errorf("Expected the hasher of the file, got %d", bf.hasher.ID())
+		}
+	})
+
+	t.Run("it rejects reopening a scalable filter with a different hasher", func(t *testing.T) {
+		opts := &BloomOptions{Err_rate: 0.01, Capacity: 100, Path: "./test.db", Hasher: HasherMurmur2}
+		sbf := NewScalableBloom(opts)
+		for i := 0; i < 500; i++ {
+			sbf.Add([]byte(fmt.Sprintf("key%d", i)))
+		}
+		sbf.Close()
+		defer os.Remove(opts.Path)
+
+		if _, err := NewScalableBloomE(&BloomOptions{Err_rate: 0.01, Capacity: 100, Path: "./test.db", Hasher: HasherMurmur3}); !errors.Is(err, ErrHeaderMismatch) {
+			t.Errorf("Expected ErrHeaderMismatch, got %v", err)
+		}
+
+		sbf, err := OpenScalableBloom(opts.Path)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		defer sbf.Close()
+		for i := 0; i < 500; i++ {
+			if !sbf.Contains([]byte(fmt.Sprintf("key%d", i))) {
+				t.Fatalf("Expected key%d to be in the reopened filter", i)
+			}
+		}
+	})
+
+	t.Run("it reopens a filter with a registered hasher", func(t *testing.T) {
+		hasher := &testHasher{id: 1000}
+		opts := &BloomOptions{Err_rate: 0.01, Capacity: 1000, Path: "./test.db", Hasher: hasher}
+		bf := NewBloom(opts)
+		bf.Add([]byte("foo"))
+		bf.Close()
+		defer os.Remove(opts.Path)
+
+		if _, err := OpenBloom(opts.Path); !errors.Is(err, ErrInvalidHeader) {
+			t.Errorf("Expected ErrInvalidHeader for an unregistered hasher, got %v", err)
+		}
+
+		if err := RegisterHasher(hasher); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		defer func() {
+			hashersLock.Lock()
+			delete(hashers, hasher.ID())
+			hashersLock.Unlock()
+		}()
+		if err := RegisterHasher(&testHasher{id: 1000}); !errors.Is(err, ErrInvalidOptions) {
+			t.Errorf("Expected an error registering a hasher with the same ID, got %v", err)
+		}
+
+		bf, err := OpenBloom(opts.Path)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		defer bf.Close()
+		if !bf.Contains([]byte("foo")) {
+			t.Errorf("Expected key to be in the reopened filter")
+		}
+	})
+}
+
+// testHasher is a hasher implemented outside of the built-in ones
+type testHasher struct {
+	id uint32
+}
+
+func (h *testHasher) ID() uint32 { return h.id }
+
+func (h *testHasher) Sum64(key []byte, seed uint64) uint64 {
+	return HasherFNV1a.Sum64(key, seed+1)
+}
+
+func (h *testHasher) Sum128(key []byte, seed uint64) (uint64, uint64) {
+	return HasherFNV1a.Sum128(key, seed+1)
+}
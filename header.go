@@ -0,0 +1,486 @@
+package sprout
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sync/atomic"
+)
+
+// The filter header is written at the start of every filter region in the
+// mmaped file, so that a filter can be restored without knowing the options
+// it was created with.
+//
+// The magic identifies the type of the filter, and cell_bits the number of bits
+// per cell of the filter array (1 for bloom filters, the counter size for
+// counting bloom filters, the fingerprint size for cuckoo filters and the cell
+// size for stable bloom filters).
+// Blocked bloom filters record their number of blocks in m.
+//
+// Layout (little endian):
+//
+//	0   magic     [4]byte
+//	4   version   uint16
+//	6   cell_bits uint16
+//	8   hash      uint32
+//	12  k         uint32
+//	16  m         uint64
+//	24  bit_width uint64
+//	32  capacity  uint64
+//	40  err_rate  float64
+//	48  count     uint64
+//	56  seeds     [k]uint64
+const (
+	headerMagic         = "SPBF"
+	countingHeaderMagic = "SPCB"
+	cuckooHeaderMagic   = "SPCF"
+	blockedHeaderMagic  = "SPBB"
+	stableHeaderMagic   = "SPST"
+
+	// formatVersion1 computes the index of each hash function with its own murmur3 hash
+	formatVersion1 = 1
+
+	// formatVersion2 computes the indices of all the hash functions from a single
+	// 128-bit murmur3 hash, with enhanced double hashing
+	formatVersion2 = 2
+
+	// formatVersion3 computes the indices like RedisBloom, so that filters can be exchanged with it:
+	// double hashing with two MurmurHash64A hashes over the whole bit array,
+	// with the bits of a byte numbered from the least significant one
+	formatVersion3 = 3
+
+	// formatVersion is the version of the on-disk format of new filters
+	formatVersion = formatVersion2
+
+	// maxFormatVersion is the latest format version that can be requested
+	maxFormatVersion = formatVersion3
+
+	// scalableFormatVersion is the version of the scalable filter header
+	scalableFormatVersion = 1
+
+	countOffset     = 48
+	headerFixedSize = 56
+
+	// maxHashFns bounds k when reading a header from an untrusted file
+	maxHashFns = 64
+
+	// maxBitWidth bounds the size in bytes of the filter array when reading a header from an untrusted file
+	maxBitWidth = 1 << 40
+)
+
+var (
+	// ErrInvalidHeader is returned when a file does not hold a valid sprout filter header
+	ErrInvalidHeader = errors.New("invalid filter header")
+
+	// ErrHeaderMismatch is returned when the options do not match the filter stored in the file
+	ErrHeaderMismatch = errors.New("filter options do not match the existing filter")
+)
+
+type header struct {
+	magic    string
+	version  uint16
+	cellBits uint16
+	hash     uint32
+	k        uint32
+	m        uint64
+	bitWidth uint64
+	capacity uint64
+	errRate  float64
+	count    uint64
+	seeds    []int64
+}
+
+// headerSize returns the size of the header of a filter with k hash functions
+func headerSize(k int) int {
+	return headerFixedSize + 8*k
+}
+
+// alignSize rounds n up to a multiple of 8, so that each filter region starts on a word boundary
+func alignSize(n int) int {
+	return (n + 7) &^ 7
+}
+
+func (h *header) size() int {
+	return headerSize(int(h.k))
+}
+
+func (h *header) marshal(buf []byte) {
+	copy(buf[0:4], h.magic)
+	binary.LittleEndian.PutUint16(buf[4:], h.version)
+	binary.LittleEndian.PutUint16(buf[6:], h.cellBits)
+	binary.LittleEndian.PutUint32(buf[8:], h.hash)
+	binary.LittleEndian.PutUint32(buf[12:], h.k)
+	binary.LittleEndian.PutUint64(buf[16:], h.m)
+	binary.LittleEndian.PutUint64(buf[24:], h.bitWidth)
+	binary.LittleEndian.PutUint64(buf[32:], h.capacity)
+	binary.LittleEndian.PutUint64(buf[40:], math.Float64bits(h.errRate))
+	binary.LittleEndian.PutUint64(buf[countOffset:], h.count)
+	for i, seed := range h.seeds {
+		binary.LittleEndian.PutUint64(buf[headerFixedSize+8*i:], uint64(seed))
+	}
+}
+
+// unmarshalHeader decodes the header at the start of buf
+func unmarshalHeader(buf []byte) (*header, error) {
+	if len(buf) < headerFixedSize {
+		return nil, ErrInvalidHeader
+	}
+	magic := string(buf[0:4])
+	if magic != headerMagic && magic != countingHeaderMagic && magic != cuckooHeaderMagic &&
+		magic != blockedHeaderMagic && magic != stableHeaderMagic {
+		return nil, ErrInvalidHeader
+	}
+	h := &header{
+		magic:    magic,
+		version:  binary.LittleEndian.Uint16(buf[4:]),
+		cellBits: binary.LittleEndian.Uint16(buf[6:]),
+		hash:     binary.LittleEndian.Uint32(buf[8:]),
+		k:        binary.LittleEndian.Uint32(buf[12:]),
+		m:        binary.LittleEndian.Uint64(buf[16:]),
+		bitWidth: binary.LittleEndian.Uint64(buf[24:]),
+		capacity: binary.LittleEndian.Uint64(buf[32:]),
+		errRate:  math.Float64frombits(binary.LittleEndian.Uint64(buf[40:])),
+		count:    binary.LittleEndian.Uint64(buf[countOffset:]),
+	}
+	if h.version < formatVersion1 || h.version > maxFormatVersion {
+		return nil, fmt.Errorf("%w: unsupported format version %d", ErrInvalidHeader, h.version)
+	}
+	if hasherByID(h.hash) == nil {
+		return nil, fmt.Errorf("%w: unknown hash algorithm %d", ErrInvalidHeader, h.hash)
+	}
+	if h.k == 0 || h.k > maxHashFns || len(buf) < h.size() {
+		return nil, fmt.Errorf("%w: truncated header", ErrInvalidHeader)
+	}
+	if h.cellBits == 0 {
+		h.cellBits = 1
+	}
+	if err := h.checkSize(); err != nil {
+		return nil, err
+	}
+	h.seeds = make([]int64, h.k)
+	for i := range h.seeds {
+		h.seeds[i] = int64(binary.LittleEndian.Uint64(buf[headerFixedSize+8*i:]))
+	}
+	return h, nil
+}
+
+// checkSize checks that the filter array is at most maxBitWidth bytes,
+// and holds every cell the indices of the filter can reach
+func (h *header) checkSize() error {
+	if h.m == 0 || h.bitWidth > maxBitWidth || h.m > 8*h.bitWidth ||
+		h.cellBits > 32 || h.cellBits&(h.cellBits-1) != 0 {
+		return fmt.Errorf("%w: invalid filter size", ErrInvalidHeader)
+	}
+	if h.arrayBits() > 8*h.bitWidth {
+		return fmt.Errorf("%w: filter array of %d bytes is too small", ErrInvalidHeader, h.bitWidth)
+	}
+	return nil
+}
+
+// arrayBits returns the number of bits of the filter array the indices of the filter can reach.
+// m is at most 8*maxBitWidth, so that it does not overflow.
+func (h *header) arrayBits() uint64 {
+	switch {
+	case h.magic == cuckooHeaderMagic:
+		return h.m * bucketSize * uint64(h.cellBits)
+	case h.magic == blockedHeaderMagic:
+		// one extra block leaves room to align the blocks on a cache line
+		return (h.m + 1) * blockBits
+	case h.version == formatVersion3:
+		// a single slice of m cells
+		return h.m * uint64(h.cellBits)
+	}
+	return uint64(h.k) * h.m * uint64(h.cellBits)
+}
+
+// readHeader reads the filter header at the given offset of the file
+func readHeader(file *os.File, offset int64) (*header, error) {
+	h, _, err := decodeHeader(io.NewSectionReader(file, offset, math.MaxInt64-offset))
+	return h, err
+}
+
+// decodeHeader reads a filter header from r, and returns the number of bytes read
+func decodeHeader(r io.Reader) (*header, int64, error) {
+	buf := make([]byte, headerFixedSize)
+	n, err := io.ReadFull(r, buf)
+	if err != nil {
+		return nil, int64(n), fmt.Errorf("%w: %v", ErrInvalidHeader, err)
+	}
+
+	// read the seeds as well, now that we know k
+	k := binary.LittleEndian.Uint32(buf[12:])
+	if k > maxHashFns {
+		return nil, int64(n), fmt.Errorf("%w: too many hash functions", ErrInvalidHeader)
+	}
+	buf = append(buf, make([]byte, headerSize(int(k))-headerFixedSize)...)
+	read, err := io.ReadFull(r, buf[headerFixedSize:])
+	n += read
+	if err != nil {
+		return nil, int64(n), fmt.Errorf("%w: %v", ErrInvalidHeader, err)
+	}
+	h, err := unmarshalHeader(buf)
+	return h, int64(n), err
+}
+
+// header returns the header describing the filter
+func (bf *BloomFilter) header() *header {
+	return &header{
+		magic:    bf.kind,
+		version:  uint16(bf.version),
+		cellBits: uint16(bf.cellBits),
+		hash:     bf.hasher.ID(),
+		k:        uint32(bf.k),
+		m:        uint64(bf.m),
+		bitWidth: uint64(bf.bit_width),
+		capacity: uint64(bf.capacity),
+		errRate:  bf.err_rate,
+		count:    uint64(bf.Count()),
+		seeds:    bf.seeds,
+	}
+}
+
+// matches reports whether the stored header describes the same filter as h
+func (h *header) matches(stored *header) bool {
+	if h.magic != stored.magic || h.cellBits != stored.cellBits ||
+		h.k != stored.k || h.m != stored.m ||
+		h.bitWidth != stored.bitWidth || h.capacity != stored.capacity ||
+		h.errRate != stored.errRate {
+		return false
+	}
+	for i := range h.seeds {
+		if h.seeds[i] != stored.seeds[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// loadHeader restores the filter from the header in the mmaped file.
+// If the region is new or was never written to, a new header is written.
+func (bf *BloomFilter) loadHeader(existing bool) error {
+	h := bf.header()
+	buf := bf.mem[bf.pageOffset : bf.pageOffset+h.size()]
+	if !existing || bytes.Equal(buf[0:4], make([]byte, 4)) {
+		h.marshal(buf)
+		return nil
+	}
+
+	stored, err := unmarshalHeader(buf)
+	if err != nil {
+		return err
+	}
+	if err := bf.checkHeader(stored); err != nil {
+		return err
+	}
+	bf.version = int(stored.version)
+	bf.hasher = hasherByID(stored.hash)
+	bf.count = int64(stored.count)
+	return nil
+}
+
+// checkHeader checks that the stored header describes the filter, and uses the format version and hasher
+// the filter was opened with, if any. An existing filter otherwise keeps its format version and hasher.
+func (bf *BloomFilter) checkHeader(stored *header) error {
+	if !bf.header().matches(stored) {
+		return fmt.Errorf("%w: file has capacity %d and error rate %v", ErrHeaderMismatch, stored.capacity, stored.errRate)
+	}
+	if bf.opts.FormatVersion != 0 && bf.opts.FormatVersion != int(stored.version) {
+		return fmt.Errorf("%w: file has format version %d", ErrHeaderMismatch, stored.version)
+	}
+	if bf.opts.Hasher != nil && bf.opts.Hasher.ID() != stored.hash {
+		return fmt.Errorf("%w: file uses hasher %d", ErrHeaderMismatch, stored.hash)
+	}
+	return nil
+}
+
+// storeCount writes the number of items in the filter to the header.
+// Concurrent callers may store a stale count, so the count is stored again until it is up to date.
+func (bf *BloomFilter) storeCount() {
+	word, _ := bitWord(bf.mem, bf.pageOffset+countOffset)
+	for {
+		count := atomic.LoadInt64(&bf.count)
+		atomic.StoreUint64(word, littleEndianWord(uint64(count)))
+		if atomic.LoadInt64(&bf.count) == count {
+			return
+		}
+	}
+}
+
+// The scalable filter header is written at the start of the file, and is
+// followed by the regions of the sub-filters, each with its own filter header.
+//
+// Layout (little endian):
+//
+//	0   magic       [4]byte
+//	4   version     uint32
+//	8   growth_rate uint32
+//	12  filters     uint32
+//	16  err_rate    float64
+//	24  capacity    uint64
+//	32  ratio       float64
+const (
+	scalableHeaderMagic = "SPSB"
+
+	filtersOffset      = 12
+	scalableHeaderSize = 40
+)
+
+type scalableHeader struct {
+	version    uint32
+	growthRate uint32
+	filters    uint32
+	errRate    float64
+	capacity   uint64
+	ratio      float64
+}
+
+func (h *scalableHeader) marshal(buf []byte) {
+	copy(buf[0:4], scalableHeaderMagic)
+	binary.LittleEndian.PutUint32(buf[4:], h.version)
+	binary.LittleEndian.PutUint32(buf[8:], h.growthRate)
+	binary.LittleEndian.PutUint32(buf[filtersOffset:], h.filters)
+	binary.LittleEndian.PutUint64(buf[16:], math.Float64bits(h.errRate))
+	binary.LittleEndian.PutUint64(buf[24:], h.capacity)
+	binary.LittleEndian.PutUint64(buf[32:], math.Float64bits(h.ratio))
+}
+
+// readScalableHeader reads the scalable filter header at the start of the file
+func readScalableHeader(file *os.File) (*scalableHeader, error) {
+	buf := make([]byte, scalableHeaderSize)
+	if _, err := file.ReadAt(buf, 0); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidHeader, err)
+	}
+	return unmarshalScalableHeader(buf)
+}
+
+// unmarshalScalableHeader decodes the scalable filter header at the start of buf
+func unmarshalScalableHeader(buf []byte) (*scalableHeader, error) {
+	if !bytes.Equal(buf[0:4], []byte(scalableHeaderMagic)) {
+		return nil, ErrInvalidHeader
+	}
+	h := &scalableHeader{
+		version:    binary.LittleEndian.Uint32(buf[4:]),
+		growthRate: binary.LittleEndian.Uint32(buf[8:]),
+		filters:    binary.LittleEndian.Uint32(buf[filtersOffset:]),
+		errRate:    math.Float64frombits(binary.LittleEndian.Uint64(buf[16:])),
+		capacity:   binary.LittleEndian.Uint64(buf[24:]),
+		ratio:      math.Float64frombits(binary.LittleEndian.Uint64(buf[32:])),
+	}
+	if h.version != scalableFormatVersion {
+		return nil, fmt.Errorf("%w: unsupported format version %d", ErrInvalidHeader, h.version)
+	}
+	if h.filters == 0 {
+		return nil, fmt.Errorf("%w: scalable filter has no filters", ErrInvalidHeader)
+	}
+	return h, nil
+}
+
+// header returns the header describing the scalable filter
+func (sbf *ScalableBloomFilter) header() *scalableHeader {
+	return &scalableHeader{
+		version:    scalableFormatVersion,
+		growthRate: uint32(sbf.growth_rate),
+		filters:    uint32(len(sbf.filters)),
+		errRate:    sbf.err_rate,
+		capacity:   uint64(sbf.capacity),
+		ratio:      sbf.ratio,
+	}
+}
+
+// storeHeader writes the scalable filter header through the mmaped memory of the top filter
+func (sbf *ScalableBloomFilter) storeHeader() {
+	sbf.header().marshal(sbf.Top().mem[0:scalableHeaderSize])
+}
+
+// The sliding filter header is written at the start of the file, and is followed
+// by the regions of the generations, each with its own filter header.
+// The generations form a ring, current is the index of the newest one.
+//
+// Layout (little endian):
+//
+//	0   magic        [4]byte
+//	4   version      uint32
+//	8   generations  uint32
+//	12  current      uint32
+//	16  err_rate     float64
+//	24  capacity     uint64
+//	32  rotate_every int64 (nanoseconds)
+//	40  rotated_at   int64 (unix nanoseconds)
+const (
+	slidingHeaderMagic = "SPSW"
+
+	// slidingFormatVersion is the version of the sliding filter header
+	slidingFormatVersion = 1
+
+	slidingHeaderSize = 48
+)
+
+type slidingHeader struct {
+	version     uint32
+	generations uint32
+	current     uint32
+	errRate     float64
+	capacity    uint64
+	rotateEvery int64
+	rotatedAt   int64
+}
+
+func (h *slidingHeader) marshal(buf []byte) {
+	copy(buf[0:4], slidingHeaderMagic)
+	binary.LittleEndian.PutUint32(buf[4:], h.version)
+	binary.LittleEndian.PutUint32(buf[8:], h.generations)
+	binary.LittleEndian.PutUint32(buf[12:], h.current)
+	binary.LittleEndian.PutUint64(buf[16:], math.Float64bits(h.errRate))
+	binary.LittleEndian.PutUint64(buf[24:], h.capacity)
+	binary.LittleEndian.PutUint64(buf[32:], uint64(h.rotateEvery))
+	binary.LittleEndian.PutUint64(buf[40:], uint64(h.rotatedAt))
+}
+
+// readSlidingHeader reads the sliding filter header at the start of the file
+func readSlidingHeader(file *os.File) (*slidingHeader, error) {
+	buf := make([]byte, slidingHeaderSize)
+	if _, err := file.ReadAt(buf, 0); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidHeader, err)
+	}
+	if !bytes.Equal(buf[0:4], []byte(slidingHeaderMagic)) {
+		return nil, ErrInvalidHeader
+	}
+	h := &slidingHeader{
+		version:     binary.LittleEndian.Uint32(buf[4:]),
+		generations: binary.LittleEndian.Uint32(buf[8:]),
+		current:     binary.LittleEndian.Uint32(buf[12:]),
+		errRate:     math.Float64frombits(binary.LittleEndian.Uint64(buf[16:])),
+		capacity:    binary.LittleEndian.Uint64(buf[24:]),
+		rotateEvery: int64(binary.LittleEndian.Uint64(buf[32:])),
+		rotatedAt:   int64(binary.LittleEndian.Uint64(buf[40:])),
+	}
+	if h.version != slidingFormatVersion {
+		return nil, fmt.Errorf("%w: unsupported format version %d", ErrInvalidHeader, h.version)
+	}
+	if h.generations < 2 || h.current >= h.generations {
+		return nil, fmt.Errorf("%w: invalid generations", ErrInvalidHeader)
+	}
+	return h, nil
+}
+
+// header returns the header describing the sliding filter
+func (swf *SlidingBloomFilter) header() *slidingHeader {
+	return &slidingHeader{
+		version:     slidingFormatVersion,
+		generations: uint32(len(swf.generations)),
+		current:     uint32(swf.current),
+		errRate:     swf.err_rate,
+		capacity:    uint64(swf.capacity),
+		rotateEvery: int64(swf.rotateEvery),
+		rotatedAt:   swf.rotatedAt.UnixNano(),
+	}
+}
+
+// storeHeader writes the sliding filter header through the mmaped memory of the generations
+func (swf *SlidingBloomFilter) storeHeader() {
+	swf.header().marshal(swf.generations[0].mem[0:slidingHeaderSize])
+}
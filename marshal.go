@@ -0,0 +1,501 @@
+package sprout
+
+import (
+	"bytes"
+	"encoding"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sync/atomic"
+	"unsafe"
+
+	"github.com/juju/fslock"
+)
+
+// The serialized form of a filter is independent of the filter file and of the byte order
+// of the host, so that filters can be sent over the network or embedded in other files.
+//
+// A BloomFilter or a BloomFilter2 is serialized as its filter header, followed by its bit array
+// (bit_width bytes). A ScalableBloomFilter is serialized as its scalable filter header, followed by
+// each of its filters, oldest first. The headers are the ones written in the filter files,
+// but the regions of the filters are not padded.
+
+// MarshalBinary encodes the filter, see WriteTo
+func (bf *BloomFilter) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Grow(headerSize(bf.k) + bf.bit_width)
+	if _, err := bf.WriteTo(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary replaces the content of the filter with the encoded filter, see ReadFrom
+func (bf *BloomFilter) UnmarshalBinary(data []byte) error {
+	r := bytes.NewReader(data)
+	if _, err := bf.ReadFrom(r); err != nil {
+		return err
+	}
+	return checkTrailing(r)
+}
+
+// WriteTo writes the header and the bit array of the filter to w.
+// Keys added while the filter is written may or may not be in the written filter.
+func (bf *BloomFilter) WriteTo(w io.Writer) (int64, error) {
+	bf.lock.Lock()
+	defer bf.lock.Unlock()
+
+	h := bf.header()
+	buf := make([]byte, h.size())
+	h.marshal(buf)
+	return writeAll(w, buf, bf.mem[bf.bitOffset:bf.bitOffset+bf.bit_width])
+}
+
+// ReadFrom replaces the content of the filter with the filter read from r, and stores it in the filter file.
+// The filter must be opened with the same options as the filter read, or ErrHeaderMismatch is returned,
+// use ReadBloom to create a filter from the filter read.
+// It must not be called concurrently with the other methods of the filter.
+func (bf *BloomFilter) ReadFrom(r io.Reader) (int64, error) {
+	if bf.mem == nil {
+		return 0, fmt.Errorf("%w: the filter must be opened before reading into it", ErrInvalidOptions)
+	}
+
+	h, n, err := decodeHeader(r)
+	if err != nil {
+		return n, err
+	}
+	read, err := bf.readFilter(h, r)
+	return n + read, err
+}
+
+// readFilter replaces the content of the filter with the filter described by h, whose bit array is read from r
+func (bf *BloomFilter) readFilter(h *header, r io.Reader) (int64, error) {
+	if err := bf.checkHeader(h); err != nil {
+		return 0, err
+	}
+
+	// the bit array is read fully before the filter is modified
+	bits, n, err := readBits(r, bf.bit_width)
+	if err != nil {
+		return n, fmt.Errorf("unable to read the bit array: %w", err)
+	}
+
+	bf.lock.Lock()
+	defer bf.lock.Unlock()
+
+	copy(bf.mem[bf.bitOffset:], bits)
+	bf.version = int(h.version)
+	bf.hasher = hasherByID(h.hash)
+	atomic.StoreInt64(&bf.count, int64(h.count))
+	bf.header().marshal(bf.mem[bf.pageOffset:bf.bitOffset])
+	return n, nil
+}
+
+// ReadBloom creates a bloom filter at path from the filter read from r, see WriteTo.
+// The parameters of the filter are read from its header, so they do not need to be known.
+// An existing filter file at the path is replaced once the filter is read.
+//
+// database is the persistent store to attach to the filter. can be omitted.
+func ReadBloom(r io.Reader, path string, database ...Store) (*BloomFilter, error) {
+	h, _, err := decodeHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	if h.magic != headerMagic {
+		return nil, fmt.Errorf("%w: not a bloom filter", ErrInvalidHeader)
+	}
+	if err := checkHeaderSize(h); err != nil {
+		return nil, err
+	}
+
+	// the filter is created from the options of the header, which must describe the filter they create
+	opts := &BloomOptions{
+		Path:          path,
+		Err_rate:      h.errRate,
+		Capacity:      int(h.capacity),
+		FormatVersion: int(h.version),
+		Hasher:        hasherByID(h.hash),
+	}
+	if opts.Path == "" {
+		opts.Path = DefaultBloomOptions.Path
+	}
+	if len(database) > 0 {
+		opts.Database = database[0]
+	}
+	if err := validateOptions(opts); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidHeader, err)
+	}
+
+	err = buildFilterFile(opts.Path, func(path string) (Filter, error) {
+		o := *opts
+		o.Path = path
+		bf, err := NewBloomE(&o)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := bf.readFilter(h, r); err != nil {
+			bf.Close()
+			return nil, err
+		}
+		return bf, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return NewBloomE(opts)
+}
+
+// MergeBinary merges the encoded filter into the filter like Merge, to merge a filter of another process.
+// The encoded filter must have the options, the format version and the hasher of the filter,
+// or ErrHeaderMismatch is returned. The count of the filter is unchanged.
+func (bf *BloomFilter) MergeBinary(data []byte) error {
+	if bf.mem == nil {
+		return fmt.Errorf("%w: the filter must be opened before merging into it", ErrInvalidOptions)
+	}
+
+	r := bytes.NewReader(data)
+	h, _, err := decodeHeader(r)
+	if err != nil {
+		return err
+	}
+	if err := bf.checkMergeable(h); err != nil {
+		return err
+	}
+
+	// the bit array is read into whole words, to be merged like the words of another filter
+	bits := make([]byte, alignSize(bf.bit_width))
+	if _, err := io.ReadFull(r, bits[:bf.bit_width]); err != nil {
+		return fmt.Errorf("unable to read the bit array: %w", err)
+	}
+	if err := checkTrailing(r); err != nil {
+		return err
+	}
+
+	bf.lock.Lock()
+	defer bf.lock.Unlock()
+
+	for i := 0; i < bf.bit_width; i += 8 {
+		word, _ := bitWord(bf.mem, bf.bitOffset+i)
+		word2, _ := bitWord(bits, i)
+		orWord(word, *word2)
+	}
+	return nil
+}
+
+// MarshalBinary encodes the scalable filter, see WriteTo
+func (sbf *ScalableBloomFilter) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := sbf.WriteTo(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary replaces the content of the scalable filter with the encoded filter, see ReadFrom
+func (sbf *ScalableBloomFilter) UnmarshalBinary(data []byte) error {
+	r := bytes.NewReader(data)
+	if _, err := sbf.ReadFrom(r); err != nil {
+		return err
+	}
+	return checkTrailing(r)
+}
+
+// WriteTo writes the scalable filter header and all the filters to w.
+// Keys added while the filter is written may or may not be in the written filter.
+func (sbf *ScalableBloomFilter) WriteTo(w io.Writer) (int64, error) {
+	sbf.lock.RLock()
+	defer sbf.lock.RUnlock()
+
+	buf := make([]byte, scalableHeaderSize)
+	sbf.header().marshal(buf)
+	n, err := writeAll(w, buf)
+	if err != nil {
+		return n, err
+	}
+
+	// the bits of all the filters are in the mmaped region of the top filter
+	mem := sbf.Top().mem
+	for _, bf := range sbf.filters {
+		h := bf.header()
+		buf := make([]byte, h.size())
+		h.marshal(buf)
+		written, err := writeAll(w, buf, mem[bf.bitOffset:bf.bitOffset+bf.bit_width])
+		n += written
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// ReadFrom replaces the content of the scalable filter with the scalable filter read from r,
+// and stores it in the filter file. The number of filters may differ, but the scalable filter must be opened
+// with the same error rate, capacity and growth rate as the filter read, and its filters must have the format
+// version and hasher of the filters of the scalable filter, or ErrHeaderMismatch is returned.
+// The filter read is written to a temporary file, which replaces the filter file once the filter is read,
+// so that the scalable filter and its file are unchanged if the filter read is invalid.
+// It must not be called concurrently with the other methods of the filter.
+func (sbf *ScalableBloomFilter) ReadFrom(r io.Reader) (int64, error) {
+	buf := make([]byte, scalableHeaderSize)
+	read, err := io.ReadFull(r, buf)
+	n := int64(read)
+	if err != nil {
+		return n, fmt.Errorf("%w: %v", ErrInvalidHeader, err)
+	}
+	h, err := unmarshalScalableHeader(buf)
+	if err != nil {
+		return n, err
+	}
+	if h.errRate != sbf.err_rate || h.capacity != uint64(sbf.capacity) ||
+		h.growthRate != uint32(sbf.growth_rate) || h.ratio != sbf.ratio {
+		return n, fmt.Errorf("%w: filter has capacity %d, error rate %v and growth rate %d",
+			ErrHeaderMismatch, h.capacity, h.errRate, h.growthRate)
+	}
+
+	sbf.growLock.Lock()
+	defer sbf.growLock.Unlock()
+	sbf.lock.Lock()
+	defer sbf.lock.Unlock()
+
+	// the filters are laid out in the file like the filters of a growing scalable filter,
+	// and each one must have the parameters it would have had. The first filter of the scalable filter
+	// is the reference of the format version and hasher of the filters read, which are kept as they grow.
+	// The number of filters comes from the data read, so the filters are appended as they are decoded.
+	var filters []*BloomFilter
+	var regions [][]byte
+	opts := sbf.initialOptions()
+	opts.FormatVersion = sbf.filters[0].version
+	opts.Hasher = sbf.filters[0].hasher
+	for i := 0; i < int(h.filters); i++ {
+		if i > 0 {
+			opts = sbf.growOptions(i, filters[i-1])
+		}
+		bf := newBloomFilter(opts)
+		bf.setRegion(opts)
+
+		fh, read, err := decodeHeader(r)
+		n += read
+		if err == nil {
+			err = bf.checkHeader(fh)
+		}
+		if err != nil {
+			return n, fmt.Errorf("unable to read filter %d: %w", i, err)
+		}
+		bf.count = int64(fh.count)
+
+		region := make([]byte, bf.opts.dataSize-bf.pageOffset)
+		fh.marshal(region)
+		bits, err := io.ReadFull(r, region[bf.bitOffset-bf.pageOffset:][:bf.bit_width])
+		n += int64(bits)
+		if err != nil {
+			return n, fmt.Errorf("unable to read the bit array of filter %d: %w", i, err)
+		}
+		filters = append(filters, bf)
+		regions = append(regions, region)
+	}
+
+	if err := sbf.writeFilters(h, filters, regions); err != nil {
+		return n, err
+	}
+
+	// the old top filter holds the lock of the old file until it is replaced
+	top := sbf.Top()
+	sbf.filters = filters
+	sbf.m0 = filters[0].m
+	top.release()
+	top.mem, top.memFile, top.flock = nil, nil, nil
+	return n, nil
+}
+
+// writeFilters writes the scalable filter header and the regions of the filters to a temporary file,
+// and moves it over the filter file, whose lock is held by the scalable filter.
+// The last filter maps the file, and holds it locked while it is moved.
+func (sbf *ScalableBloomFilter) writeFilters(h *scalableHeader, filters []*BloomFilter, regions [][]byte) error {
+	tmp, err := tempFilterPath(sbf.path)
+	if err != nil {
+		return err
+	}
+	top := filters[len(filters)-1]
+	top.path = tmp
+	top.flock = fslock.New(tmp)
+	err = top.openFile()
+	top.path = sbf.path
+	if err != nil {
+		return err
+	}
+
+	header := make([]byte, scalableHeaderSize)
+	h.marshal(header)
+	_, err = top.memFile.WriteAt(header, 0)
+	for i := 0; err == nil && i < len(filters); i++ {
+		_, err = top.memFile.WriteAt(regions[i], int64(filters[i].pageOffset))
+	}
+	if err == nil {
+		err = top.memFile.Truncate(int64(top.opts.dataSize))
+	}
+	if err != nil {
+		err = fmt.Errorf("Error writing filter: %w", err)
+	} else {
+		err = top.mapRegion()
+	}
+	if err == nil {
+		if err = renameFilterFile(tmp, sbf.path); err != nil {
+			_ = top.unmap()
+		}
+	}
+	if err != nil {
+		_ = top.flock.Unlock()
+		_ = top.memFile.Close()
+		top.mem, top.memFile, top.flock = nil, nil, nil
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// MarshalBinary encodes the filter, see WriteTo
+func (bf *BloomFilter2) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Grow(headerSize(len(bf.seeds)) + bf.bit_width)
+	if _, err := bf.WriteTo(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary replaces the filter with the encoded filter, see ReadFrom
+func (bf *BloomFilter2) UnmarshalBinary(data []byte) error {
+	r := bytes.NewReader(data)
+	if _, err := bf.ReadFrom(r); err != nil {
+		return err
+	}
+	return checkTrailing(r)
+}
+
+// WriteTo writes the header and the bit array of the filter to w.
+// The header is the one of a BloomFilter of format version 1 hashed with HasherMurmur3,
+// which computes the same indices.
+func (bf *BloomFilter2) WriteTo(w io.Writer) (int64, error) {
+	h := bf.header()
+	buf := make([]byte, h.size())
+	h.marshal(buf)
+	return writeAll(w, buf, bf.bit_array)
+}
+
+// ReadFrom replaces the filter with the filter read from r. The filter does not need to be created first,
+// and keeps its persistent store. The filter read can also be a BloomFilter of format version 1
+// hashed with HasherMurmur3.
+func (bf *BloomFilter2) ReadFrom(r io.Reader) (int64, error) {
+	h, n, err := decodeHeader(r)
+	if err != nil {
+		return n, err
+	}
+	if h.magic != headerMagic || h.version != formatVersion1 || h.hash != hashMurmur3 {
+		return n, fmt.Errorf("%w: not a bloom filter of format version %d hashed with murmur3", ErrInvalidHeader, formatVersion1)
+	}
+	if err := checkHeaderSize(h); err != nil {
+		return n, err
+	}
+
+	// the size of the filter is derived from its capacity and error rate, like a new filter
+	k, m, bitWidth := bloom2Params(h.errRate, int(h.capacity))
+	if h.k != uint32(k) || h.m != uint64(m) || h.bitWidth != uint64(bitWidth) {
+		return n, fmt.Errorf("%w: invalid filter size", ErrInvalidHeader)
+	}
+	bits, read, err := readBits(r, bitWidth)
+	n += read
+	if err != nil {
+		return n, fmt.Errorf("unable to read the bit array: %w", err)
+	}
+
+	var b byte
+	*bf = BloomFilter2{
+		err_rate:  h.errRate,
+		capacity:  int(h.capacity),
+		bit_width: bitWidth,
+		bit_array: bits,
+		m:         m,
+		seeds:     h.seeds,
+		db:        bf.db,
+		byteSize:  int(unsafe.Sizeof(&b)),
+		count:     int(h.count),
+	}
+	return n, nil
+}
+
+// header returns the header describing the filter
+func (bf *BloomFilter2) header() *header {
+	return &header{
+		magic:    headerMagic,
+		version:  formatVersion1,
+		cellBits: 1,
+		hash:     hashMurmur3,
+		k:        uint32(len(bf.seeds)),
+		m:        uint64(bf.m),
+		bitWidth: uint64(bf.bit_width),
+		capacity: uint64(bf.capacity),
+		errRate:  bf.err_rate,
+		count:    uint64(bf.count),
+		seeds:    bf.seeds,
+	}
+}
+
+// writeAll writes the buffers to w, and returns the number of bytes written
+func writeAll(w io.Writer, bufs ...[]byte) (int64, error) {
+	var n int64
+	for _, buf := range bufs {
+		written, err := w.Write(buf)
+		n += int64(written)
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// checkHeaderSize checks the error rate and the capacity of a header read from untrusted data,
+// before the size of the filter is derived from them. The error rate bounds k by maxHashFns,
+// and the capacity bounds the bit width so that it fits in an int.
+func checkHeaderSize(h *header) error {
+	if !(h.errRate >= math.Pow(2, -maxHashFns) && h.errRate < 1) || h.capacity == 0 || h.capacity > math.MaxInt64/128 {
+		return fmt.Errorf("%w: invalid error rate or capacity", ErrInvalidHeader)
+	}
+	return nil
+}
+
+// readBits reads a bit array of n bytes from r. The buffer grows as the bytes are read,
+// so that the size of a corrupt header does not allocate more than r holds.
+func readBits(r io.Reader, n int) ([]byte, int64, error) {
+	var buf bytes.Buffer
+	read, err := io.CopyN(&buf, r, int64(n))
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return buf.Bytes(), read, err
+}
+
+// checkTrailing checks that the encoded filter was read entirely
+func checkTrailing(r *bytes.Reader) error {
+	if r.Len() != 0 {
+		return fmt.Errorf("%w: %d trailing bytes after the filter", ErrInvalidHeader, r.Len())
+	}
+	return nil
+}
+
+var (
+	_ encoding.BinaryMarshaler   = (*BloomFilter)(nil)
+	_ encoding.BinaryUnmarshaler = (*BloomFilter)(nil)
+	_ io.WriterTo                = (*BloomFilter)(nil)
+	_ io.ReaderFrom              = (*BloomFilter)(nil)
+
+	_ encoding.BinaryMarshaler   = (*ScalableBloomFilter)(nil)
+	_ encoding.BinaryUnmarshaler = (*ScalableBloomFilter)(nil)
+	_ io.WriterTo                = (*ScalableBloomFilter)(nil)
+	_ io.ReaderFrom              = (*ScalableBloomFilter)(nil)
+
+	_ encoding.BinaryMarshaler   = (*BloomFilter2)(nil)
+	_ encoding.BinaryUnmarshaler = (*BloomFilter2)(nil)
+	_ io.WriterTo                = (*BloomFilter2)(nil)
+	_ io.ReaderFrom              = (*BloomFilter2)(nil)
+)
@@ -0,0 +1,472 @@
+package sprout
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBloomFilter_MarshalBinary(t *testing.T) {
+	opts := &BloomOptions{
+		Err_rate: 0.01,
+		Capacity: 1000,
+		Path:     "./test.db",
+	}
+	bf := NewBloom(opts)
+	opts2 := *opts
+	opts2.Path = "./test2.db"
+	bf2 := NewBloom(&opts2)
+	defer func() {
+		bf.Close()
+		bf2.Close()
+		os.Remove(opts.Path)
+		os.Remove(opts2.Path)
+	}()
+
+	for i := 0; i < 500; i++ {
+		bf.Add([]byte(fmt.Sprintf("foo%d", i)))
+	}
+
+	data, err := bf.MarshalBinary()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(data) != headerSize(bf.k)+bf.bit_width {
+		t.Errorf("Expected %d bytes, got %d", headerSize(bf.k)+bf.bit_width, len(data))
+	}
+
+	t.Run("should restore the keys and the count", func(t *testing.T) {
+		if err := bf2.UnmarshalBinary(data); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if bf2.Count() != 500 {
+			t.Errorf("Expected count to be 500, got %d", bf2.Count())
+		}
+		for i := 0; i < 500; i++ {
+			if !bf2.Contains([]byte(fmt.Sprintf("foo%d", i))) {
+				t.Fatalf("Expected key foo%d to be found", i)
+			}
+		}
+	})
+
+	t.Run("should store the filter in the file", func(t *testing.T) {
+		bf2.Close()
+		var err error
+		bf2, err = OpenBloom(opts2.Path)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if bf2.Count() != 500 || !bf2.Contains([]byte("foo0")) {
+			t.Errorf("Expected the filter read to be stored in the file")
+		}
+	})
+
+	t.Run("WriteTo and ReadFrom should match the binary encoding", func(t *testing.T) {
+		var buf bytes.Buffer
+		n, err := bf.WriteTo(&buf)
+		if err != nil || n != int64(len(data)) || !bytes.Equal(buf.Bytes(), data) {
+			t.Fatalf("Expected WriteTo to write the %d bytes of MarshalBinary, wrote %d: %v", len(data), n, err)
+		}
+
+		// the filter is followed by other data in the stream
+		buf.WriteString("trailer")
+		n, err = bf2.ReadFrom(&buf)
+		if err != nil || n != int64(len(data)) {
+			t.Fatalf("Expected ReadFrom to read %d bytes, read %d: %v", len(data), n, err)
+		}
+		if buf.String() != "trailer" {
+			t.Errorf("Expected ReadFrom to stop after the filter, left %q", buf.String())
+		}
+	})
+
+	t.Run("should reject invalid data", func(t *testing.T) {
+		if err := bf2.UnmarshalBinary(data[:len(data)-1]); !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("Expected io.ErrUnexpectedEOF for a truncated filter, got %v", err)
+		}
+		if err := bf2.UnmarshalBinary(data[:10]); !errors.Is(err, ErrInvalidHeader) {
+			t.Errorf("Expected ErrInvalidHeader for a truncated header, got %v", err)
+		}
+		if err := bf2.UnmarshalBinary(append(data, 0)); !errors.Is(err, ErrInvalidHeader) {
+			t.Errorf("Expected ErrInvalidHeader for trailing bytes, got %v", err)
+		}
+
+		opts3 := &BloomOptions{Err_rate: 0.01, Capacity: 2000, Path: "./test3.db"}
+		bf3 := NewBloom(opts3)
+		defer func() {
+			bf3.Close()
+			os.Remove(opts3.Path)
+		}()
+		if err := bf3.UnmarshalBinary(data); !errors.Is(err, ErrHeaderMismatch) {
+			t.Errorf("Expected ErrHeaderMismatch for a filter of another capacity, got %v", err)
+		}
+		if bf3.Count() != 0 {
+			t.Errorf("Expected the filter to be left unchanged, got count %d", bf3.Count())
+		}
+
+		if err := new(BloomFilter).UnmarshalBinary(data); !errors.Is(err, ErrInvalidOptions) {
+			t.Errorf("Expected ErrInvalidOptions reading into a filter that is not open, got %v", err)
+		}
+	})
+}
+
+func TestReadBloom(t *testing.T) {
+	defer os.Remove("./test.db")
+	defer os.Remove("./test2.db")
+
+	for name, opts := range map[string]*BloomOptions{
+		"format version 1": {Err_rate: 0.01, Capacity: 1000, Path: "./test.db", FormatVersion: formatVersion1},
+		"format version 2": {Err_rate: 0.001, Capacity: 2000, Path: "./test.db", Hasher: HasherXXHash},
+		"format version 3": {Err_rate: 0.01, Capacity: 1000, Path: "./test.db", FormatVersion: formatVersion3},
+	} {
+		t.Run(name, func(t *testing.T) {
+			bf := NewBloom(opts)
+			for i := 0; i < 500; i++ {
+				bf.Add([]byte(fmt.Sprintf("foo%d", i)))
+			}
+			var buf bytes.Buffer
+			_, err := bf.WriteTo(&buf)
+			bf.Close()
+			os.Remove(opts.Path)
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+
+			// the file of a filter with other options is replaced
+			bf2, err := ReadBloom(&buf, "./test2.db")
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			defer bf2.Close()
+			if bf2.Count() != 500 || bf2.Capacity() != opts.Capacity || bf2.version != newFormatVersion(opts) {
+				t.Errorf("Expected a filter of format version %d holding 500 keys, got version %d holding %d keys",
+					newFormatVersion(opts), bf2.version, bf2.Count())
+			}
+			for i := 0; i < 500; i++ {
+				if !bf2.Contains([]byte(fmt.Sprintf("foo%d", i))) {
+					t.Fatalf("Expected key foo%d to be found", i)
+				}
+			}
+		})
+	}
+
+	t.Run("should reject invalid data and keep the existing filter", func(t *testing.T) {
+		bf := NewBloom(&BloomOptions{Err_rate: 0.01, Capacity: 1000, Path: "./test.db"})
+		bf.Add([]byte("foo"))
+		data, err := bf.MarshalBinary()
+		bf.Close()
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		huge := append([]byte(nil), data...)
+		binary.LittleEndian.PutUint64(huge[32:], 1<<62)
+		for name, data := range map[string][]byte{
+			"truncated":     data[:len(data)-1],
+			"huge capacity": huge,
+			"not a bloom":   append([]byte(cuckooHeaderMagic), data[4:]...),
+		} {
+			if _, err := ReadBloom(bytes.NewReader(data), "./test.db"); err == nil {
+				t.Errorf("%s: expected an error", name)
+			}
+		}
+
+		bf, err = OpenBloom("./test.db")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		defer bf.Close()
+		if !bf.Contains([]byte("foo")) || bf.Count() != 1 {
+			t.Errorf("Expected the existing filter to be kept")
+		}
+	})
+}
+
+func TestScalableBloomFilter_MarshalBinary(t *testing.T) {
+	opts := &BloomOptions{
+		Err_rate: 0.01,
+		Capacity: 100,
+		Path:     "./test.db",
+	}
+	sbf := NewScalableBloom(opts)
+	opts2 := *opts
+	opts2.Path = "./test2.db"
+	sbf2 := NewScalableBloom(&opts2)
+	defer func() {
+		sbf.Close()
+		sbf2.Close()
+		os.Remove(opts.Path)
+		os.Remove(opts2.Path)
+	}()
+
+	// grow the filter a few times
+	for i := 0; i < 1000; i++ {
+		sbf.Add([]byte(fmt.Sprintf("foo%d", i)))
+	}
+	numFilters := len(sbf.filters)
+	if numFilters < 3 {
+		t.Fatalf("Expected the filter to grow, got %d filters", numFilters)
+	}
+
+	data, err := sbf.MarshalBinary()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	t.Run("should restore all the filters", func(t *testing.T) {
+		if err := sbf2.UnmarshalBinary(data); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if len(sbf2.filters) != numFilters || sbf2.Count() != 1000 || sbf2.Capacity() != sbf.Capacity() {
+			t.Errorf("Expected %d filters holding 1000 keys, got %d filters holding %d keys", numFilters, len(sbf2.filters), sbf2.Count())
+		}
+		for i := 0; i < 1000; i++ {
+			if !sbf2.Contains([]byte(fmt.Sprintf("foo%d", i))) {
+				t.Fatalf("Expected key foo%d to be found", i)
+			}
+		}
+	})
+
+	t.Run("should keep growing and be stored in the file", func(t *testing.T) {
+		for i := 1000; i < 2000; i++ {
+			if err := sbf2.Add([]byte(fmt.Sprintf("foo%d", i))); err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+		}
+		sbf2.Close()
+		var err error
+		sbf2, err = OpenScalableBloom(opts2.Path)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		for i := 0; i < 2000; i++ {
+			if !sbf2.Contains([]byte(fmt.Sprintf("foo%d", i))) {
+				t.Fatalf("Expected key foo%d to be found", i)
+			}
+		}
+	})
+
+	t.Run("should shrink to the filter read", func(t *testing.T) {
+		var buf bytes.Buffer
+		if _, err := sbf.WriteTo(&buf); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if _, err := sbf2.ReadFrom(&buf); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if len(sbf2.filters) != numFilters || sbf2.Count() != 1000 {
+			t.Errorf("Expected %d filters holding 1000 keys, got %d filters holding %d keys", numFilters, len(sbf2.filters), sbf2.Count())
+		}
+		fi, err := os.Stat(opts2.Path)
+		if err != nil || fi.Size() != int64(sbf2.Top().opts.dataSize) {
+			t.Errorf("Expected the file to be truncated to %d bytes, got %v", sbf2.Top().opts.dataSize, fi.Size())
+		}
+	})
+
+	t.Run("should reject a filter with other options", func(t *testing.T) {
+		opts3 := &BloomOptions{Err_rate: 0.001, Capacity: 100, Path: "./test3.db"}
+		sbf3 := NewScalableBloom(opts3)
+		defer func() {
+			sbf3.Close()
+			os.Remove(opts3.Path)
+		}()
+		if err := sbf3.UnmarshalBinary(data); !errors.Is(err, ErrHeaderMismatch) {
+			t.Errorf("Expected ErrHeaderMismatch, got %v", err)
+		}
+		if err := sbf3.UnmarshalBinary(data[:len(data)-1]); err == nil {
+			t.Errorf("Expected an error for a truncated filter")
+		}
+	})
+
+	t.Run("should reject a filter count larger than the data", func(t *testing.T) {
+		corrupt := append([]byte(nil), data...)
+		binary.LittleEndian.PutUint32(corrupt[filtersOffset:], 0xFFFFFFF)
+		before, _ := os.ReadFile(opts2.Path)
+		if err := sbf2.UnmarshalBinary(corrupt); err == nil {
+			t.Errorf("Expected an error for a filter count larger than the data")
+		}
+		if len(sbf2.filters) != numFilters {
+			t.Errorf("Expected the filter to be unchanged, got %d filters", len(sbf2.filters))
+		}
+		if after, _ := os.ReadFile(opts2.Path); !bytes.Equal(before, after) {
+			t.Errorf("Expected the filter file to be unchanged")
+		}
+		if tmp, _ := filepath.Glob(opts2.Path + ".*.tmp"); len(tmp) != 0 {
+			t.Errorf("Expected no temporary file to be left, got %v", tmp)
+		}
+		if !sbf2.Contains([]byte("foo1")) {
+			t.Errorf("Expected key foo1 to be found")
+		}
+	})
+
+	t.Run("should reject filters of another format version", func(t *testing.T) {
+		opts3 := *opts
+		opts3.Path = "./test3.db"
+		opts3.FormatVersion = formatVersion1
+		sbf3 := NewScalableBloom(&opts3)
+		defer func() {
+			sbf3.Close()
+			os.Remove(opts3.Path)
+		}()
+		if err := sbf3.UnmarshalBinary(data); !errors.Is(err, ErrHeaderMismatch) {
+			t.Errorf("Expected ErrHeaderMismatch, got %v", err)
+		}
+	})
+}
+
+func TestBloomFilter2_MarshalBinary(t *testing.T) {
+	opts := &BloomOptions{
+		Err_rate: 0.01,
+		Capacity: 1000,
+		Path:     "./test.db",
+	}
+	bf := NewBloom2(opts)
+	for i := 0; i < 500; i++ {
+		bf.Add([]byte(fmt.Sprintf("foo%d", i)))
+	}
+
+	data, err := bf.MarshalBinary()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	t.Run("should be read into an empty filter", func(t *testing.T) {
+		var bf2 BloomFilter2
+		if err := bf2.UnmarshalBinary(data); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if bf2.Count() != 500 || bf2.Capacity() != 1000 {
+			t.Errorf("Expected 500 keys and capacity 1000, got %d and %d", bf2.Count(), bf2.Capacity())
+		}
+		for i := 0; i < 500; i++ {
+			if !bf2.Contains([]byte(fmt.Sprintf("foo%d", i))) {
+				t.Fatalf("Expected key foo%d to be found", i)
+			}
+		}
+	})
+
+	t.Run("should read a bloom filter of format version 1", func(t *testing.T) {
+		opts := *opts
+		opts.FormatVersion = formatVersion1
+		bf := NewBloom(&opts)
+		defer func() {
+			bf.Close()
+			os.Remove(opts.Path)
+		}()
+		bf.Add([]byte("foo"))
+		data, err := bf.MarshalBinary()
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		var bf2 BloomFilter2
+		if err := bf2.UnmarshalBinary(data); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if !bf2.Contains([]byte("foo")) {
+			t.Errorf("Expected key foo to be found")
+		}
+	})
+
+	t.Run("should reject other filters", func(t *testing.T) {
+		bf := NewBloom(opts)
+		defer func() {
+			bf.Close()
+			os.Remove(opts.Path)
+		}()
+		data, err := bf.MarshalBinary()
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		var bf2 BloomFilter2
+		if err := bf2.UnmarshalBinary(data); !errors.Is(err, ErrInvalidHeader) {
+			t.Errorf("Expected ErrInvalidHeader for a filter of format version 2, got %v", err)
+		}
+	})
+
+	t.Run("should reject a corrupt header", func(t *testing.T) {
+		corrupt := func(offset int, val uint64) []byte {
+			buf := append([]byte(nil), data...)
+			binary.LittleEndian.PutUint64(buf[offset:], val)
+			return buf
+		}
+		var bf2 BloomFilter2
+		if err := bf2.UnmarshalBinary(corrupt(32, 1<<63)); !errors.Is(err, ErrInvalidHeader) {
+			t.Errorf("Expected ErrInvalidHeader for a capacity of 1<<63, got %v", err)
+		}
+		if err := bf2.UnmarshalBinary(corrupt(40, math.Float64bits(math.NaN()))); !errors.Is(err, ErrInvalidHeader) {
+			t.Errorf("Expected ErrInvalidHeader for an error rate of NaN, got %v", err)
+		}
+
+		// a huge filter with a consistent header is only read as far as the data goes
+		_, m, bitWidth := bloom2Params(0.01, 1<<36)
+		buf := corrupt(32, 1<<36)
+		binary.LittleEndian.PutUint64(buf[16:], uint64(m))
+		binary.LittleEndian.PutUint64(buf[24:], uint64(bitWidth))
+		if err := bf2.UnmarshalBinary(buf); !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("Expected ErrUnexpectedEOF for a truncated bit array, got %v", err)
+		}
+	})
+}
+
+func TestBloomFilter_MergeBinary(t *testing.T) {
+	opts := &BloomOptions{
+		Err_rate: 0.01,
+		Capacity: 1000,
+		Path:     "./test.db",
+	}
+	bf := NewBloom(opts)
+	opts2 := *opts
+	opts2.Path = "./test2.db"
+	bf2 := NewBloom(&opts2)
+	defer func() {
+		bf.Close()
+		bf2.Close()
+		os.Remove(opts.Path)
+		os.Remove(opts2.Path)
+	}()
+
+	for i := 0; i < 100; i++ {
+		bf.Add([]byte(fmt.Sprintf("foo%d", i)))
+		bf2.Add([]byte(fmt.Sprintf("bar%d", i)))
+	}
+	data, err := bf2.MarshalBinary()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	t.Run("should merge the keys of the encoded filter", func(t *testing.T) {
+		if err := bf.MergeBinary(data); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		for i := 0; i < 100; i++ {
+			if !bf.Contains([]byte(fmt.Sprintf("foo%d", i))) || !bf.Contains([]byte(fmt.Sprintf("bar%d", i))) {
+				t.Fatalf("Expected keys foo%d and bar%d to be found", i, i)
+			}
+		}
+		if bf.Count() != 100 {
+			t.Errorf("Expected the count to be unchanged, got %d", bf.Count())
+		}
+	})
+
+	t.Run("should reject other filters", func(t *testing.T) {
+		opts3 := &BloomOptions{Err_rate: 0.01, Capacity: 1000, Path: "./test3.db", FormatVersion: formatVersion1}
+		bf3 := NewBloom(opts3)
+		defer func() {
+			bf3.Close()
+			os.Remove(opts3.Path)
+		}()
+		if err := bf3.MergeBinary(data); !errors.Is(err, ErrHeaderMismatch) {
+			t.Errorf("Expected ErrHeaderMismatch for a filter of another format version, got %v", err)
+		}
+		if err := bf.MergeBinary(data[:len(data)-1]); !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("Expected io.ErrUnexpectedEOF for a truncated filter, got %v", err)
+		}
+	})
+}
@@ -7,7 +7,61 @@ import "encoding/binary"
 
 // 64-bit hash for 64-bit platforms
 
+// MurmurHash64A returns the 64-bit MurmurHash2 of the key, as computed by
+// MurmurHash64A in the reference implementation and in RedisBloom.
+func MurmurHash64A(key []byte, seed uint64) uint64 {
+	const m = uint64(0xc6a4a7935bd1e995)
+	const r = 47
+
+	h := seed ^ (uint64(len(key)) * m)
+
+	nblocks := len(key) / 8
+	for i := 0; i < nblocks; i++ {
+		k := binary.LittleEndian.Uint64(key[i*8:])
+
+		k *= m
+		k ^= k >> r
+		k *= m
+
+		h ^= k
+		h *= m
+	}
+
+	tail := key[nblocks*8:]
+	switch len(tail) {
+	case 7:
+		h ^= uint64(tail[6]) << 48
+		fallthrough
+	case 6:
+		h ^= uint64(tail[5]) << 40
+		fallthrough
+	case 5:
+		h ^= uint64(tail[4]) << 32
+		fallthrough
+	case 4:
+		h ^= uint64(tail[3]) << 24
+		fallthrough
+	case 3:
+		h ^= uint64(tail[2]) << 16
+		fallthrough
+	case 2:
+		h ^= uint64(tail[1]) << 8
+		fallthrough
+	case 1:
+		h ^= uint64(tail[0])
+		h *= m
+	}
+
+	h ^= h >> r
+	h *= m
+	h ^= h >> r
+
+	return h
+}
+
 // MurmurHash64A_Bloom returns the murmur hash of the given data
+//
+// Deprecated: MurmurHash64A_Bloom does not match the reference implementation, use MurmurHash64A instead.
 func MurmurHash64A_Bloom(key []byte, length, seed uint64) uint64 {
 	m := uint64(0xc6a4a7935bd1e995)
 	r := 47
@@ -0,0 +1,30 @@
+package murmur
+
+import "testing"
+
+// the expected hashes are computed with MurmurHash64A from the SMHasher reference implementation
+func TestMurmurHash64A(t *testing.T) {
+	tests := []struct {
+		key  string
+		seed uint64
+		want uint64
+	}{
+		{"", 0x0, 0x0000000000000000},
+		{"a", 0x0, 0x071717d2d36b6b11},
+		{"abc", 0x0, 0x9cc9c33498a95efb},
+		{"hello world", 0x0, 0xd3ba2368a832afce},
+		{"The quick brown fox jumps over the lazy dog", 0x0, 0x5589ca33042a861b},
+		{"0123456789abcdef", 0x0, 0x93a92d1a91a24bc7},
+		{"", 0xc6a4a7935bd1e995, 0x1ab11ea5a7b2c56e},
+		{"a", 0xc6a4a7935bd1e995, 0x4292cee227b9150a},
+		{"abc", 0xc6a4a7935bd1e995, 0xca52f3863690cd7b},
+		{"hello world", 0xc6a4a7935bd1e995, 0xbae8fb35317acde1},
+		{"The quick brown fox jumps over the lazy dog", 0xc6a4a7935bd1e995, 0xc7a616a28f4a74d6},
+		{"0123456789abcdef", 0xc6a4a7935bd1e995, 0x73397e4fb095abef},
+	}
+	for _, tt := range tests {
+		if got := MurmurHash64A([]byte(tt.key), tt.seed); got != tt.want {
+			t.Errorf("MurmurHash64A(%q, %#x) = %#016x, want %#016x", tt.key, tt.seed, got, tt.want)
+		}
+	}
+}
@@ -1,54 +1,74 @@
 package murmur
 
 import (
+	"encoding/binary"
+	"hash"
 	"math/bits"
-	"unsafe"
 )
 
 // Source: https://github.com/aappleby/smhasher/blob/master/src/MurmurHash3.cpp
+//
+// The blocks of the key are read in little endian order, so that the hashes are
+// the same on all platforms and match the reference implementation on x86.
 
-func Murmur3_64(key []byte, seed uint64) uint64 {
-	nblocks := len(key) / 16
-	keyLen := len(key)
+const (
+	c1_128 = uint64(0x87c37b91114253d5)
+	c2_128 = uint64(0x4cf5ad432745937f)
 
-	h1 := uint64(seed)
-	h2 := uint64(seed)
+	c1_32 = uint32(0xcc9e2d51)
+	c2_32 = uint32(0x1b873593)
+)
+
+// Murmur3_64 returns the first 64 bits of the 128-bit murmur3 hash of the key
+func Murmur3_64(key []byte, seed uint64) uint64 {
+	h1, _ := Murmur3_128(key, seed)
+	return h1
+}
 
-	c1 := uint64(0x87c37b91114253d5)
-	c2 := uint64(0x4cf5ad432745937f)
+// Murmur3_128 returns both 64-bit halves of the 128-bit murmur3 hash of the key (MurmurHash3_x64_128)
+func Murmur3_128(key []byte, seed uint64) (uint64, uint64) {
+	h1, h2 := seed, seed
 
 	//----------
 	// body
 
+	nblocks := len(key) / 16
 	for i := 0; i < nblocks; i++ {
-		kk := (*[2]uint64)(unsafe.Pointer(&key[i*16]))
-		k1 := kk[0]
-		k2 := kk[1]
+		k1 := binary.LittleEndian.Uint64(key[i*16:])
+		k2 := binary.LittleEndian.Uint64(key[i*16+8:])
+		h1, h2 = block128(h1, h2, k1, k2)
+	}
 
-		k1 *= c1
-		k1 = bits.RotateLeft64(k1, 31)
-		k1 *= c2
-		h1 ^= k1
+	//----------
+	// tail
 
-		h1 = bits.RotateLeft64(h1, 27)
-		h1 += h2
-		h1 = h1*5 + 0x52dce729
+	return finalize128(h1, h2, key[nblocks*16:], uint64(len(key)))
+}
 
-		k2 *= c2
-		k2 = bits.RotateLeft64(k2, 33)
-		k2 *= c1
-		h2 ^= k2
+// block128 mixes a 16 byte block into the hash state
+func block128(h1, h2, k1, k2 uint64) (uint64, uint64) {
+	k1 *= c1_128
+	k1 = bits.RotateLeft64(k1, 31)
+	k1 *= c2_128
+	h1 ^= k1
 
-		h2 = bits.RotateLeft64(h2, 31)
-		h2 += h1
-		h2 = h2*5 + 0x38495ab5
-	}
+	h1 = bits.RotateLeft64(h1, 27)
+	h1 += h2
+	h1 = h1*5 + 0x52dce729
 
-	// //----------
-	// // tail
+	k2 *= c2_128
+	k2 = bits.RotateLeft64(k2, 33)
+	k2 *= c1_128
+	h2 ^= k2
 
-	tail := key[nblocks*16:]
+	h2 = bits.RotateLeft64(h2, 31)
+	h2 += h1
+	h2 = h2*5 + 0x38495ab5
+	return h1, h2
+}
 
+// finalize128 mixes the tail of less than 16 bytes and the length of the key into the hash state
+func finalize128(h1, h2 uint64, tail []byte, keyLen uint64) (uint64, uint64) {
 	k1 := uint64(0)
 	k2 := uint64(0)
 
@@ -73,9 +93,9 @@ func Murmur3_64(key []byte, seed uint64) uint64 {
 		fallthrough
 	case 9:
 		k2 ^= uint64(tail[8]) << 0
-		k2 *= c2
+		k2 *= c2_128
 		k2 = bits.RotateLeft64(k2, 33)
-		k2 *= c1
+		k2 *= c1_128
 		h2 ^= k2
 		fallthrough
 	case 8:
@@ -101,17 +121,17 @@ func Murmur3_64(key []byte, seed uint64) uint64 {
 		fallthrough
 	case 1:
 		k1 ^= uint64(tail[0]) << 0
-		k1 *= c1
+		k1 *= c1_128
 		k1 = bits.RotateLeft64(k1, 31)
-		k1 *= c2
+		k1 *= c2_128
 		h1 ^= k1
 	}
 
 	//----------
 	// finalization
 
-	h1 ^= uint64(keyLen)
-	h2 ^= uint64(keyLen)
+	h1 ^= keyLen
+	h2 ^= keyLen
 
 	h1 += h2
 	h2 += h1
@@ -122,10 +142,51 @@ func Murmur3_64(key []byte, seed uint64) uint64 {
 	h1 += h2
 	h2 += h1
 
-	return h1
+	return h1, h2
+}
+
+// Murmur3_32 returns the 32-bit murmur3 hash of the key (MurmurHash3_x86_32)
+func Murmur3_32(key []byte, seed uint32) uint32 {
+	h1 := seed
+
+	nblocks := len(key) / 4
+	for i := 0; i < nblocks; i++ {
+		h1 = block32(h1, binary.LittleEndian.Uint32(key[i*4:]))
+	}
+	return finalize32(h1, key[nblocks*4:], uint32(len(key)))
+}
+
+// block32 mixes a 4 byte block into the hash state
+func block32(h1, k1 uint32) uint32 {
+	k1 *= c1_32
+	k1 = bits.RotateLeft32(k1, 15)
+	k1 *= c2_32
+
+	h1 ^= k1
+	h1 = bits.RotateLeft32(h1, 13)
+	return h1*5 + 0xe6546b64
+}
+
+// finalize32 mixes the tail of less than 4 bytes and the length of the key into the hash state
+func finalize32(h1 uint32, tail []byte, keyLen uint32) uint32 {
+	k1 := uint32(0)
+	switch len(tail) & 3 {
+	case 3:
+		k1 ^= uint32(tail[2]) << 16
+		fallthrough
+	case 2:
+		k1 ^= uint32(tail[1]) << 8
+		fallthrough
+	case 1:
+		k1 ^= uint32(tail[0])
+		k1 *= c1_32
+		k1 = bits.RotateLeft32(k1, 15)
+		k1 *= c2_32
+		h1 ^= k1
+	}
 
-	// ((uint64_t*)out)[0] = h1;
-	// ((uint64_t*)out)[1] = h2;
+	h1 ^= keyLen
+	return fmix32(h1)
 }
 
 // Finalization mix - force all bits of a hash block to avalanche
@@ -137,3 +198,145 @@ func fmix64(k uint64) uint64 {
 	k ^= k >> 33
 	return k
 }
+
+func fmix32(h uint32) uint32 {
+	h ^= h >> 16
+	h *= 0x85ebca6b
+	h ^= h >> 13
+	h *= 0xc2b2ae35
+	h ^= h >> 16
+	return h
+}
+
+// Hash128 is the streaming 128-bit murmur3 hash.
+// Sum appends h1 then h2 in big endian order, and Sum64 returns h1.
+type Hash128 interface {
+	hash.Hash64
+
+	// Sum128 returns both 64-bit halves of the hash
+	Sum128() (uint64, uint64)
+}
+
+// New128 returns a streaming 128-bit murmur3 hash with the given seed.
+// Its hashes are the same as the ones of Murmur3_128.
+func New128(seed uint64) Hash128 {
+	d := &digest128{seed: seed}
+	d.Reset()
+	return d
+}
+
+type digest128 struct {
+	seed   uint64
+	h1, h2 uint64
+	buf    [16]byte
+	nbuf   int    // number of bytes in buf
+	length uint64 // number of bytes written
+}
+
+func (d *digest128) Size() int      { return 16 }
+func (d *digest128) BlockSize() int { return 16 }
+
+func (d *digest128) Reset() {
+	d.h1, d.h2 = d.seed, d.seed
+	d.nbuf = 0
+	d.length = 0
+}
+
+func (d *digest128) Write(p []byte) (int, error) {
+	n := len(p)
+	d.length += uint64(n)
+
+	// complete the buffered block first
+	if d.nbuf > 0 {
+		c := copy(d.buf[d.nbuf:], p)
+		d.nbuf += c
+		p = p[c:]
+		if d.nbuf < 16 {
+			return n, nil
+		}
+		d.h1, d.h2 = block128(d.h1, d.h2, binary.LittleEndian.Uint64(d.buf[:]), binary.LittleEndian.Uint64(d.buf[8:]))
+		d.nbuf = 0
+	}
+
+	for len(p) >= 16 {
+		d.h1, d.h2 = block128(d.h1, d.h2, binary.LittleEndian.Uint64(p), binary.LittleEndian.Uint64(p[8:]))
+		p = p[16:]
+	}
+	d.nbuf = copy(d.buf[:], p)
+	return n, nil
+}
+
+func (d *digest128) Sum128() (uint64, uint64) {
+	return finalize128(d.h1, d.h2, d.buf[:d.nbuf], d.length)
+}
+
+func (d *digest128) Sum64() uint64 {
+	h1, _ := d.Sum128()
+	return h1
+}
+
+func (d *digest128) Sum(b []byte) []byte {
+	h1, h2 := d.Sum128()
+	var sum [16]byte
+	binary.BigEndian.PutUint64(sum[:], h1)
+	binary.BigEndian.PutUint64(sum[8:], h2)
+	return append(b, sum[:]...)
+}
+
+// New32 returns a streaming 32-bit murmur3 hash with the given seed.
+// Its hashes are the same as the ones of Murmur3_32.
+func New32(seed uint32) hash.Hash32 {
+	d := &digest32{seed: seed}
+	d.Reset()
+	return d
+}
+
+type digest32 struct {
+	seed   uint32
+	h1     uint32
+	buf    [4]byte
+	nbuf   int    // number of bytes in buf
+	length uint32 // number of bytes written
+}
+
+func (d *digest32) Size() int      { return 4 }
+func (d *digest32) BlockSize() int { return 4 }
+
+func (d *digest32) Reset() {
+	d.h1 = d.seed
+	d.nbuf = 0
+	d.length = 0
+}
+
+func (d *digest32) Write(p []byte) (int, error) {
+	n := len(p)
+	d.length += uint32(n)
+
+	if d.nbuf > 0 {
+		c := copy(d.buf[d.nbuf:], p)
+		d.nbuf += c
+		p = p[c:]
+		if d.nbuf < 4 {
+			return n, nil
+		}
+		d.h1 = block32(d.h1, binary.LittleEndian.Uint32(d.buf[:]))
+		d.nbuf = 0
+	}
+
+	for len(p) >= 4 {
+		d.h1 = block32(d.h1, binary.LittleEndian.Uint32(p))
+		p = p[4:]
+	}
+	d.nbuf = copy(d.buf[:], p)
+	return n, nil
+}
+
+func (d *digest32) Sum32() uint32 {
+	return finalize32(d.h1, d.buf[:d.nbuf], d.length)
+}
+
+func (d *digest32) Sum(b []byte) []byte {
+	var sum [4]byte
+	binary.BigEndian.PutUint32(sum[:], d.Sum32())
+	return append(b, sum[:]...)
+}
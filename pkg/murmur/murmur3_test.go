@@ -0,0 +1,133 @@
+package murmur
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// the expected hashes are computed with MurmurHash3_x86_32 and MurmurHash3_x64_128
+// from the SMHasher reference implementation
+var murmur3Tests = []struct {
+	key    string
+	seed   uint32
+	want32 uint32
+	h1, h2 uint64
+}{
+	{"", 0x0, 0x00000000, 0x0000000000000000, 0x0000000000000000},
+	{"a", 0x0, 0x3c2569b2, 0x85555565f6597889, 0xe6b53a48510e895a},
+	{"abc", 0x0, 0xb3dd93fa, 0xb4963f3f3fad7867, 0x3ba2744126ca2d52},
+	{"hello world", 0x0, 0x5e928f0f, 0x533f6046eb7f610e, 0xab97467d60eb63b1},
+	{"The quick brown fox jumps over the lazy dog", 0x0, 0x2e4ff723, 0xe34bbc7bbc071b6c, 0x7a433ca9c49a9347},
+	{"0123456789abcdef", 0x0, 0x36c7e0df, 0x4be06d94cf4ad1a7, 0x87c35b5c63a708da},
+	{"0123456789abcdefg", 0x0, 0xe2ad6669, 0x8e32612daa45f9de, 0x0800f4c206c372ee},
+	{"", 0x9747b28c, 0xebb6c228, 0x392b208a1daabbb3, 0x93b0608fe302957a},
+	{"a", 0x9747b28c, 0x7fa09ea6, 0x5ce8d8512db25a1d, 0x9e6dab0f9208f004},
+	{"abc", 0x9747b28c, 0xc84a62dd, 0x3743630dbfc3cedc, 0xcde0a23420b504bf},
+	{"hello world", 0x9747b28c, 0xbf34f5e0, 0x2785cdc826220bf1, 0x1d8e62eeb9508d7f},
+	{"The quick brown fox jumps over the lazy dog", 0x9747b28c, 0x2fa826cd, 0x738a7f3bd2633121, 0xf94573727ec016e5},
+	{"0123456789abcdef", 0x9747b28c, 0x2ada2fcc, 0xd8061eb14f48c9c9, 0x521e1174e459c2cc},
+	{"0123456789abcdefg", 0x9747b28c, 0x2803b4b6, 0xc0bf962bfd77a52b, 0xbd74af14707ce362},
+}
+
+func TestMurmur3(t *testing.T) {
+	for _, tt := range murmur3Tests {
+		key := []byte(tt.key)
+		if got := Murmur3_32(key, tt.seed); got != tt.want32 {
+			t.Errorf("Murmur3_32(%q, %#x) = %#08x, want %#08x", tt.key, tt.seed, got, tt.want32)
+		}
+		if h1, h2 := Murmur3_128(key, uint64(tt.seed)); h1 != tt.h1 || h2 != tt.h2 {
+			t.Errorf("Murmur3_128(%q, %#x) = %#016x, %#016x, want %#016x, %#016x", tt.key, tt.seed, h1, h2, tt.h1, tt.h2)
+		}
+		if got := Murmur3_64(key, uint64(tt.seed)); got != tt.h1 {
+			t.Errorf("Murmur3_64(%q, %#x) = %#016x, want %#016x", tt.key, tt.seed, got, tt.h1)
+		}
+	}
+}
+
+// verificationValue is the SMHasher verification test: the keys {}, {0}, {0, 1}, ... {0, ..., 254}
+// are hashed with the seeds 256, 255, ... 1, and the concatenation of their hashes is hashed with the seed 0
+func verificationValue(size int, sum func(key []byte, seed uint32, out []byte)) uint32 {
+	key := make([]byte, 256)
+	hashes := make([]byte, 256*size)
+	for i := 0; i < 256; i++ {
+		key[i] = byte(i)
+		sum(key[:i], uint32(256-i), hashes[i*size:])
+	}
+	final := make([]byte, size)
+	sum(hashes, 0, final)
+	return binary.LittleEndian.Uint32(final)
+}
+
+func TestMurmur3_Verification(t *testing.T) {
+	got32 := verificationValue(4, func(key []byte, seed uint32, out []byte) {
+		binary.LittleEndian.PutUint32(out, Murmur3_32(key, seed))
+	})
+	if got32 != 0xB0F57EE3 {
+		t.Errorf("Murmur3_32 verification = %#08x, want 0xb0f57ee3", got32)
+	}
+
+	got128 := verificationValue(16, func(key []byte, seed uint32, out []byte) {
+		h1, h2 := Murmur3_128(key, uint64(seed))
+		binary.LittleEndian.PutUint64(out, h1)
+		binary.LittleEndian.PutUint64(out[8:], h2)
+	})
+	if got128 != 0x6384BA69 {
+		t.Errorf("Murmur3_128 verification = %#08x, want 0x6384ba69", got128)
+	}
+}
+
+func TestMurmur3_Streaming(t *testing.T) {
+	for _, tt := range murmur3Tests {
+		key := []byte(tt.key)
+
+		// write the key in chunks of every size, so that the blocks are split across writes
+		for chunk := 1; chunk <= len(key)+1; chunk++ {
+			h128 := New128(uint64(tt.seed))
+			h32 := New32(tt.seed)
+			for i := 0; i < len(key); i += chunk {
+				end := i + chunk
+				if end > len(key) {
+					end = len(key)
+				}
+				h128.Write(key[i:end])
+				h32.Write(key[i:end])
+			}
+
+			if h1, h2 := h128.Sum128(); h1 != tt.h1 || h2 != tt.h2 {
+				t.Errorf("New128(%#x).Sum128() of %q in chunks of %d = %#016x, %#016x, want %#016x, %#016x",
+					tt.seed, tt.key, chunk, h1, h2, tt.h1, tt.h2)
+			}
+			if got := h128.Sum64(); got != tt.h1 {
+				t.Errorf("New128(%#x).Sum64() of %q in chunks of %d = %#016x, want %#016x", tt.seed, tt.key, chunk, got, tt.h1)
+			}
+			if got := h32.Sum32(); got != tt.want32 {
+				t.Errorf("New32(%#x).Sum32() of %q in chunks of %d = %#08x, want %#08x", tt.seed, tt.key, chunk, got, tt.want32)
+			}
+
+			sum := h128.Sum([]byte("prefix"))
+			if string(sum[:6]) != "prefix" || binary.BigEndian.Uint64(sum[6:]) != tt.h1 || binary.BigEndian.Uint64(sum[14:]) != tt.h2 {
+				t.Errorf("New128(%#x).Sum() of %q = %x", tt.seed, tt.key, sum)
+			}
+			if sum := h32.Sum(nil); binary.BigEndian.Uint32(sum) != tt.want32 {
+				t.Errorf("New32(%#x).Sum() of %q = %x", tt.seed, tt.key, sum)
+			}
+		}
+	}
+
+	// the hash can be reused after Reset
+	h := New128(0)
+	h.Write([]byte("some other key"))
+	h.Reset()
+	h.Write([]byte(murmur3Tests[3].key))
+	if h1, h2 := h.Sum128(); h1 != murmur3Tests[3].h1 || h2 != murmur3Tests[3].h2 {
+		t.Errorf("Sum128() after Reset = %#016x, %#016x", h1, h2)
+	}
+}
+
+func BenchmarkMurmur3_128(b *testing.B) {
+	key := []byte("The quick brown fox jumps over the lazy dog")
+	b.SetBytes(int64(len(key)))
+	for i := 0; i < b.N; i++ {
+		Murmur3_128(key, uint64(i))
+	}
+}
@@ -0,0 +1,189 @@
+package sprout
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/juju/fslock"
+)
+
+// RebuildOptions is the options for rebuilding a filter from a store
+type RebuildOptions struct {
+
+	// path to the new filter. An existing filter file at the path is replaced once the new filter is built.
+	Path string
+
+	// The desired false positive rate
+	Err_rate float64
+
+	// the minimum capacity of the new filter. The capacity is otherwise sized from the number of keys in the store.
+	Capacity int
+
+	// Scalable rebuilds a ScalableBloomFilter instead of a BloomFilter
+	Scalable bool
+
+	// growth rate of the scalable bloom filter (valid values are 2 and 4)
+	GrowthRate GrowthRate
+
+	// Progress is called with the number of keys added to the filter and the number of keys in the store,
+	// every ProgressInterval keys and once the filter is rebuilt. can be nil.
+	Progress func(added, total int)
+
+	// the number of keys added between two calls to Progress, defaults to 10000
+	ProgressInterval int
+}
+
+// rebuildHeadroom leaves room in the rebuilt filter for the keys added after the rebuild
+const rebuildHeadroom = 1.25
+
+// RebuildFromStore creates a new filter holding every key of the store, and attaches the store to it.
+// It is used to recover a filter whose file was lost, or to change the parameters of a filter.
+//
+// The capacity of the filter is sized from the number of keys in the store,
+// and the filter is returned as a *BloomFilter, or a *ScalableBloomFilter if opts.Scalable is set.
+func RebuildFromStore(store IterableStore, opts *RebuildOptions) (KeyValueFilter, error) {
+	if store == nil || !storeReady(store) {
+		return nil, ErrNoStore
+	}
+	if opts == nil {
+		opts = &RebuildOptions{Err_rate: DefaultBloomOptions.Err_rate, Path: DefaultBloomOptions.Path}
+	}
+	interval := opts.ProgressInterval
+	if interval <= 0 {
+		interval = 10000
+	}
+
+	total := 0
+	err := store.ForEach(func(key, val []byte) error {
+		total++
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to count the keys in the store: %w", err)
+	}
+
+	capacity := int(float64(total) * rebuildHeadroom)
+	if capacity < opts.Capacity {
+		capacity = opts.Capacity
+	}
+
+	// a bloom filter needs a capacity greater than 10
+	if capacity <= 10 {
+		capacity = 11
+	}
+
+	bloomOpts := &BloomOptions{
+		Path:       opts.Path,
+		Err_rate:   opts.Err_rate,
+		Capacity:   capacity,
+		Database:   store,
+		GrowthRate: opts.GrowthRate,
+	}
+	if bloomOpts.Path == "" {
+		bloomOpts.Path = DefaultBloomOptions.Path
+	}
+	if err := validateOptions(bloomOpts); err != nil {
+		return nil, err
+	}
+
+	// the filter is built in a temporary file, which replaces the filter file once the filter is complete
+	err = buildFilterFile(bloomOpts.Path, func(path string) (Filter, error) {
+		o := *bloomOpts
+		o.Path = path
+		filter, err := newRebuiltFilter(&o, opts.Scalable)
+		if err != nil {
+			return nil, err
+		}
+
+		added := 0
+		err = store.ForEach(func(key, val []byte) error {
+			if err := filter.Add(key); err != nil {
+				return err
+			}
+			added++
+			if opts.Progress != nil && added%interval == 0 {
+				opts.Progress(added, total)
+			}
+			return nil
+		})
+		if err != nil {
+			filter.Close()
+			return nil, fmt.Errorf("unable to rebuild the filter: %w", err)
+		}
+		if opts.Progress != nil {
+			opts.Progress(added, total)
+		}
+		return filter, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return newRebuiltFilter(bloomOpts, opts.Scalable)
+}
+
+// newRebuiltFilter creates or opens the filter of a rebuild
+func newRebuiltFilter(opts *BloomOptions, scalable bool) (KeyValueFilter, error) {
+	if scalable {
+		return NewScalableBloomE(opts)
+	}
+	return NewBloomE(opts)
+}
+
+// buildFilterFile builds a filter in a temporary file next to path with build, and moves the file over path
+// once the filter is built and closed, so that a filter file is only replaced by a complete filter.
+// The filter file at path must not be open in another filter or process, or ErrFileLocked is returned.
+func buildFilterFile(path string, build func(path string) (Filter, error)) error {
+	tmp, err := tempFilterPath(path)
+	if err != nil {
+		return err
+	}
+
+	filter, err := build(tmp)
+	if err == nil {
+		err = filter.Close()
+	}
+	if err == nil {
+		err = replaceFilterFile(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// tempFilterPath returns the path of a new temporary file next to the filter file at path.
+// The name is reserved with CreateTemp, and the file is created again by the filter with the permissions of a filter file.
+func tempFilterPath(path string) (string, error) {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return "", fmt.Errorf("unable to create the new filter file: %w", err)
+	}
+	tmp := f.Name()
+	f.Close()
+	os.Remove(tmp)
+	return tmp, nil
+}
+
+// replaceFilterFile renames the file at tmp over the filter file at path, unless it is locked by a filter
+func replaceFilterFile(tmp, path string) error {
+	lock := fslock.New(path)
+	if err := lock.TryLock(); err != nil {
+		if err == fslock.ErrLocked {
+			return ErrFileLocked
+		}
+		return fmt.Errorf("unable to lock the old filter file: %w", err)
+	}
+	defer lock.Unlock()
+
+	return renameFilterFile(tmp, path)
+}
+
+// renameFilterFile renames the file at tmp over the filter file at path, whose lock is held by the caller
+func renameFilterFile(tmp, path string) error {
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("unable to replace the old filter file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package sprout
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRebuildFromStore(t *testing.T) {
+	setups := map[string]func(t *testing.T) (Store, func()){
+		"bolt":   DBSetupTest,
+		"badger": BadgerDBSetupTest,
+	}
+
+	for name, setup := range setups {
+		for _, scalable := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%s scalable=%v", name, scalable), func(t *testing.T) {
+				store, cleanupFunc := setup(t)
+				defer cleanupFunc()
+
+				n := 250
+				for i := 0; i < n; i++ {
+					key := []byte(fmt.Sprintf("key%d", i))
+					if err := store.Put(key, key); err != nil {
+						t.Fatalf("Expected no error, got %v", err)
+					}
+				}
+
+				var calls, lastAdded, lastTotal int
+				opts := &RebuildOptions{
+					Path:             "./test.db",
+					Err_rate:         0.01,
+					Scalable:         scalable,
+					ProgressInterval: 100,
+					Progress: func(added, total int) {
+						calls++
+						lastAdded, lastTotal = added, total
+					},
+				}
+				defer os.Remove(opts.Path)
+
+				filter, err := RebuildFromStore(store.(IterableStore), opts)
+				if err != nil {
+					t.Fatalf("Expected no error, got %v", err)
+				}
+				defer filter.Close()
+
+				if calls != 3 || lastAdded != n || lastTotal != n {
+					t.Errorf("Expected 3 progress reports ending at %d/%d, got %d ending at %d/%d", n, n, calls, lastAdded, lastTotal)
+				}
+				if filter.Count() != n {
+					t.Errorf("Expected count %d, got %d", n, filter.Count())
+				}
+				if filter.Capacity() < n {
+					t.Errorf("Expected capacity of at least %d, got %d", n, filter.Capacity())
+				}
+				for i := 0; i < n; i++ {
+					key := []byte(fmt.Sprintf("key%d", i))
+					if !filter.Contains(key) {
+						t.Fatalf("Expected key %s to be in the rebuilt filter", key)
+					}
+					if val, err := filter.GetE(key); err != nil || string(val) != string(key) {
+						t.Fatalf("Expected value %s, got %s; error: %v", key, val, err)
+					}
+				}
+
+				_, isScalable := filter.(*ScalableBloomFilter)
+				if isScalable != scalable {
+					t.Errorf("Expected a scalable filter to be %v, got %T", scalable, filter)
+				}
+			})
+		}
+	}
+
+	t.Run("it replaces an existing filter file", func(t *testing.T) {
+		store, cleanupFunc := DBSetupTest(t)
+		defer cleanupFunc()
+		store.Put([]byte("foo"), []byte("bar"))
+
+		bf := NewBloom(&BloomOptions{Err_rate: 0.1, Capacity: 1000, Path: "./test.db"})
+		bf.Add([]byte("baz"))
+		bf.Close()
+		defer os.Remove("./test.db")
+
+		filter, err := RebuildFromStore(store.(IterableStore), &RebuildOptions{Path: "./test.db", Err_rate: 0.01})
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		defer filter.Close()
+		if !filter.Contains([]byte("foo")) || filter.Count() != 1 {
+			t.Errorf("Expected the filter to only hold the keys of the store, got count %d", filter.Count())
+		}
+	})
+
+	t.Run("it keeps the existing filter file if the rebuild fails", func(t *testing.T) {
+		store, cleanupFunc := DBSetupTest(t)
+		defer cleanupFunc()
+		store.Put([]byte("foo"), []byte("bar"))
+
+		bf := NewBloom(&BloomOptions{Err_rate: 0.1, Capacity: 1000, Path: "./test.db"})
+		bf.Add([]byte("baz"))
+		defer os.Remove("./test.db")
+
+		// the filter is still open
+		_, err := RebuildFromStore(store.(IterableStore), &RebuildOptions{Path: "./test.db", Err_rate: 0.01})
+		if !errors.Is(err, ErrFileLocked) {
+			t.Errorf("Expected ErrFileLocked, got %v", err)
+		}
+		bf.Close()
+
+		if _, err := RebuildFromStore(store.(IterableStore), &RebuildOptions{Path: "./test.db", Err_rate: 2}); !errors.Is(err, ErrInvalidOptions) {
+			t.Errorf("Expected ErrInvalidOptions, got %v", err)
+		}
+
+		bf, err = OpenBloom("./test.db")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		defer bf.Close()
+		if !bf.Contains([]byte("baz")) {
+			t.Errorf("Expected the existing filter to be kept")
+		}
+		if tmp, _ := filepath.Glob("./test.db.*.tmp"); len(tmp) != 0 {
+			t.Errorf("Expected the temporary files to be removed, got %v", tmp)
+		}
+	})
+
+	t.Run("it requires a store", func(t *testing.T) {
+		if _, err := RebuildFromStore(nil, nil); !errors.Is(err, ErrNoStore) {
+			t.Errorf("Expected ErrNoStore, got %v", err)
+		}
+	})
+}
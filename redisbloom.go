@@ -0,0 +1,449 @@
+package sprout
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/bits"
+)
+
+// RedisBloom dumps a filter with BF.SCANDUMP as a header chunk, followed by the bit arrays of the filters
+// of its chain, and loads it back with BF.LOADCHUNK. Filters of format version 3 are sized and hashed like
+// RedisBloom filters, so their bit arrays are exchanged as is.
+//
+// Layout of the header chunk (packed, little endian):
+//
+//	0   size      uint64  the number of items in the chain
+//	8   nfilters  uint32
+//	12  options   uint32
+//	16  growth    uint32
+//	20  links     [nfilters]link
+//
+// Layout of a link:
+//
+//	0   bytes     uint64  the size of the bit array
+//	8   bits      uint64
+//	16  size      uint64  the number of items in the filter
+//	24  error     float64
+//	32  bpe       float64 bits per entry
+//	40  hashes    uint32
+//	44  entries   uint64  the capacity of the filter
+//	52  n2        uint8   log2 of the number of bits, if it is rounded to a power of 2
+const (
+	// redisSeed is the seed of the first hash of a key, the second hash is seeded with the first one
+	redisSeed uint64 = 0xc6a4a7935bd1e995
+
+	// redisTighteningRatio is the ratio of the error rates of two successive filters of a chain
+	redisTighteningRatio = 0.5
+
+	// redisGrowth is the default growth rate of a chain
+	redisGrowth = 2
+
+	// redisChunkSize bounds the size of the chunks of the bit arrays
+	redisChunkSize = 16 << 20
+
+	redisHeaderSize = 20
+	redisLinkSize   = 53
+
+	// options of a chain
+	redisOptNoRound   = 1
+	redisOptForce64   = 4
+	redisOptNoScaling = 8
+)
+
+// RedisChunk is a chunk of a RedisBloom filter, as returned by BF.SCANDUMP and loaded with BF.LOADCHUNK
+type RedisChunk struct {
+	// Iter is the iterator returned with the chunk by BF.SCANDUMP, and passed with it to BF.LOADCHUNK
+	Iter int64
+
+	Data []byte
+}
+
+type redisChain struct {
+	size    uint64
+	options uint32
+	growth  uint32
+	links   []redisLink
+}
+
+type redisLink struct {
+	bytes   uint64
+	bits    uint64
+	size    uint64
+	errRate float64
+	bpe     float64
+	hashes  uint32
+	entries uint64
+	n2      uint8
+}
+
+func (c *redisChain) marshal() []byte {
+	buf := make([]byte, redisHeaderSize+redisLinkSize*len(c.links))
+	binary.LittleEndian.PutUint64(buf[0:], c.size)
+	binary.LittleEndian.PutUint32(buf[8:], uint32(len(c.links)))
+	binary.LittleEndian.PutUint32(buf[12:], c.options)
+	binary.LittleEndian.PutUint32(buf[16:], c.growth)
+	for i, l := range c.links {
+		b := buf[redisHeaderSize+redisLinkSize*i:]
+		binary.LittleEndian.PutUint64(b[0:], l.bytes)
+		binary.LittleEndian.PutUint64(b[8:], l.bits)
+		binary.LittleEndian.PutUint64(b[16:], l.size)
+		binary.LittleEndian.PutUint64(b[24:], math.Float64bits(l.errRate))
+		binary.LittleEndian.PutUint64(b[32:], math.Float64bits(l.bpe))
+		binary.LittleEndian.PutUint32(b[40:], l.hashes)
+		binary.LittleEndian.PutUint64(b[44:], l.entries)
+		b[52] = l.n2
+	}
+	return buf
+}
+
+// unmarshalRedisChain decodes the header chunk of a RedisBloom filter
+func unmarshalRedisChain(buf []byte) (*redisChain, error) {
+	if len(buf) < redisHeaderSize {
+		return nil, fmt.Errorf("%w: truncated RedisBloom header", ErrInvalidHeader)
+	}
+	n := int(binary.LittleEndian.Uint32(buf[8:]))
+	if n == 0 || len(buf) != redisHeaderSize+redisLinkSize*n {
+		return nil, fmt.Errorf("%w: RedisBloom header of %d bytes for %d filters", ErrInvalidHeader, len(buf), n)
+	}
+	c := &redisChain{
+		size:    binary.LittleEndian.Uint64(buf[0:]),
+		options: binary.LittleEndian.Uint32(buf[12:]),
+		growth:  binary.LittleEndian.Uint32(buf[16:]),
+		links:   make([]redisLink, n),
+	}
+
+	// without FORCE64, RedisBloom only uses the low 32 bits of the hashes
+	if c.options&redisOptForce64 == 0 {
+		return nil, fmt.Errorf("%w: RedisBloom filters with 32-bit hashes are not supported", ErrInvalidHeader)
+	}
+
+	for i := range c.links {
+		b := buf[redisHeaderSize+redisLinkSize*i:]
+		l := redisLink{
+			bytes:   binary.LittleEndian.Uint64(b[0:]),
+			bits:    binary.LittleEndian.Uint64(b[8:]),
+			size:    binary.LittleEndian.Uint64(b[16:]),
+			errRate: math.Float64frombits(binary.LittleEndian.Uint64(b[24:])),
+			bpe:     math.Float64frombits(binary.LittleEndian.Uint64(b[32:])),
+			hashes:  binary.LittleEndian.Uint32(b[40:]),
+			entries: binary.LittleEndian.Uint64(b[44:]),
+			n2:      b[52],
+		}
+		if l.bytes == 0 || l.bits != 8*l.bytes || l.n2 >= 64 || (l.n2 > 0 && 1<<l.n2 > l.bits) {
+			return nil, fmt.Errorf("%w: invalid size of RedisBloom filter %d", ErrInvalidHeader, i)
+		}
+		if l.hashes == 0 || l.hashes > maxHashFns || l.errRate <= 0 || l.errRate >= 1 {
+			return nil, fmt.Errorf("%w: invalid parameters of RedisBloom filter %d", ErrInvalidHeader, i)
+		}
+		c.links[i] = l
+	}
+	return c, nil
+}
+
+// header returns the header of the filter of format version 3 holding the link
+func (l *redisLink) header() *header {
+	m := l.bits
+	if l.n2 > 0 {
+		m = 1 << l.n2
+	}
+	return &header{
+		magic:    headerMagic,
+		version:  formatVersion3,
+		cellBits: 1,
+		hash:     hashMurmur2,
+		k:        l.hashes,
+		m:        m,
+		bitWidth: l.bytes,
+		capacity: l.entries,
+		errRate:  l.errRate,
+		count:    l.size,
+		seeds:    redisSeeds(int(l.hashes)),
+	}
+}
+
+// redisLink returns the link describing the filter in a RedisBloom chain
+func (bf *BloomFilter) redisLink() (redisLink, error) {
+	if bf.kind != headerMagic || bf.version != formatVersion3 || bf.hasher.ID() != hashMurmur2 || uint64(bf.seeds[0]) != redisSeed {
+		return redisLink{}, fmt.Errorf("%w: only bloom filters of format version %d can be dumped for RedisBloom",
+			ErrInvalidOptions, formatVersion3)
+	}
+	l := redisLink{
+		bytes:   uint64(bf.bit_width),
+		bits:    8 * uint64(bf.bit_width),
+		size:    uint64(bf.Count()),
+		errRate: bf.err_rate,
+		bpe:     redisBitsPerEntry(bf.err_rate),
+		hashes:  uint32(bf.k),
+		entries: uint64(bf.capacity),
+	}
+	if uint64(bf.m) != l.bits {
+		l.n2 = uint8(bits.TrailingZeros64(uint64(bf.m)))
+	}
+	return l, nil
+}
+
+// redisParams returns the number of hash functions, the number of bits and the size in bytes
+// of the bit array of a RedisBloom filter holding capacity items, which is a whole number of 64 bit words.
+func redisParams(capacity int, errRate float64) (k, m, bitWidth int) {
+	bpe := redisBitsPerEntry(errRate)
+	m = int(float64(capacity) * bpe)
+	if m == 0 {
+		m = 1
+	}
+	bitWidth = (m + 63) / 64 * 8
+	return int(math.Ceil(math.Ln2 * bpe)), 8 * bitWidth, bitWidth
+}
+
+// redisBitsPerEntry returns the number of bits per item of a RedisBloom filter, with the value of ln(2)^2 it uses
+func redisBitsPerEntry(errRate float64) float64 {
+	return -math.Log(errRate) / 0.480453013918201
+}
+
+// redisSeeds returns the seeds of a filter of format version 3. Only the first one is used.
+func redisSeeds(k int) []int64 {
+	seeds := make([]int64, k)
+	for i := range seeds {
+		seeds[i] = 64 << int64(i+1)
+	}
+	seed := redisSeed
+	seeds[0] = int64(seed)
+	return seeds
+}
+
+// redisDump returns the chunks of BF.SCANDUMP for the chain and the bit arrays of its filters
+func redisDump(chain *redisChain, arrays [][]byte) []RedisChunk {
+	chunks := []RedisChunk{{Iter: 1, Data: chain.marshal()}}
+
+	// the iterator is one past the offset of the end of the chunk in the bit arrays
+	iter := int64(1)
+	for _, array := range arrays {
+		for len(array) > 0 {
+			n := len(array)
+			if n > redisChunkSize {
+				n = redisChunkSize
+			}
+			iter += int64(n)
+			chunks = append(chunks, RedisChunk{Iter: iter, Data: append([]byte(nil), array[:n]...)})
+			array = array[n:]
+		}
+	}
+	return chunks
+}
+
+// redisLoad decodes the chunks of BF.SCANDUMP, and returns the chain and the bit arrays of its filters
+func redisLoad(chunks []RedisChunk) (*redisChain, [][]byte, error) {
+	if len(chunks) == 0 || chunks[0].Iter != 1 {
+		return nil, nil, fmt.Errorf("%w: the first RedisBloom chunk must be the header", ErrInvalidHeader)
+	}
+	chain, err := unmarshalRedisChain(chunks[0].Data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// the sizes of the header are checked against the data of the chunks before the bit arrays are allocated
+	var size uint64
+	for _, chunk := range chunks[1:] {
+		size += uint64(len(chunk.Data))
+	}
+	for i, l := range chain.links {
+		if l.bytes > size {
+			return nil, nil, fmt.Errorf("%w: RedisBloom filter %d is larger than the chunks", ErrInvalidHeader, i)
+		}
+		size -= l.bytes
+	}
+	if size != 0 {
+		return nil, nil, fmt.Errorf("%w: %d bytes of RedisBloom chunks past the filters", ErrInvalidHeader, size)
+	}
+
+	arrays := make([][]byte, len(chain.links))
+	for i, l := range chain.links {
+		arrays[i] = make([]byte, l.bytes)
+	}
+	for _, chunk := range chunks[1:] {
+		// the last reply of BF.SCANDUMP
+		if chunk.Iter == 0 && len(chunk.Data) == 0 {
+			continue
+		}
+
+		// a chunk never spans two filters
+		offset := chunk.Iter - 1 - int64(len(chunk.Data))
+		i := 0
+		for ; i < len(arrays) && offset >= int64(len(arrays[i])); i++ {
+			offset -= int64(len(arrays[i]))
+		}
+		if offset < 0 || i == len(arrays) || offset+int64(len(chunk.Data)) > int64(len(arrays[i])) {
+			return nil, nil, fmt.Errorf("%w: RedisBloom chunk %d is out of bounds", ErrInvalidHeader, chunk.Iter)
+		}
+		copy(arrays[i][offset:], chunk.Data)
+	}
+	return chain, arrays, nil
+}
+
+// DumpRedis returns the filter as the chunks of RedisBloom's BF.SCANDUMP, to load it in RedisBloom with BF.LOADCHUNK.
+// The filter must be created with FormatVersion 3, and is loaded as a non scaling RedisBloom filter.
+func (bf *BloomFilter) DumpRedis() ([]RedisChunk, error) {
+	bf.lock.Lock()
+	defer bf.lock.Unlock()
+
+	link, err := bf.redisLink()
+	if err != nil {
+		return nil, err
+	}
+	chain := &redisChain{
+		size:    link.size,
+		options: redisOptNoRound | redisOptForce64 | redisOptNoScaling,
+		growth:  redisGrowth,
+		links:   []redisLink{link},
+	}
+	return redisDump(chain, [][]byte{bf.mem[bf.bitOffset : bf.bitOffset+bf.bit_width]}), nil
+}
+
+// LoadRedisBloom creates a bloom filter at opts.Path from the chunks of a RedisBloom filter returned by BF.SCANDUMP.
+// The filter has format version 3, and the capacity and error rate of the RedisBloom filter.
+// The RedisBloom filter must not have grown, use LoadRedisScalableBloom otherwise.
+//
+// An existing filter file at the path is replaced once the filter is loaded.
+func LoadRedisBloom(opts *BloomOptions, chunks []RedisChunk) (*BloomFilter, error) {
+	if opts == nil {
+		opts = &DefaultBloomOptions
+	}
+	chain, arrays, err := redisLoad(chunks)
+	if err != nil {
+		return nil, err
+	}
+	if len(chain.links) != 1 {
+		return nil, fmt.Errorf("%w: RedisBloom filter has %d filters, use LoadRedisScalableBloom", ErrInvalidHeader, len(chain.links))
+	}
+	link := chain.links[0]
+
+	o := *opts
+	o.Err_rate = link.errRate
+	o.Capacity = int(link.entries)
+	o.FormatVersion = formatVersion3
+	o.Hasher = HasherMurmur2
+	if o.Path == "" {
+		o.Path = DefaultBloomOptions.Path
+	}
+	if err := validateOptions(&o); err != nil {
+		return nil, err
+	}
+
+	// the filter is loaded in a temporary file, which replaces the filter file once the filter is complete
+	err = buildFilterFile(o.Path, func(path string) (Filter, error) {
+		o := o
+		o.Path = path
+		bf, err := NewBloomE(&o)
+		if err != nil {
+			return nil, err
+		}
+
+		var buf bytes.Buffer
+		h := link.header()
+		header := make([]byte, h.size())
+		h.marshal(header)
+		buf.Write(header)
+		buf.Write(arrays[0])
+		if _, err := bf.ReadFrom(&buf); err != nil {
+			bf.Close()
+			return nil, fmt.Errorf("unable to load the RedisBloom filter: %w", err)
+		}
+		return bf, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return NewBloomE(&o)
+}
+
+// DumpRedis returns the scalable filter as the chunks of RedisBloom's BF.SCANDUMP, to load it in RedisBloom with BF.LOADCHUNK.
+// The filter must be created with FormatVersion 3.
+func (sbf *ScalableBloomFilter) DumpRedis() ([]RedisChunk, error) {
+	sbf.lock.RLock()
+	defer sbf.lock.RUnlock()
+
+	chain := &redisChain{
+		options: redisOptNoRound | redisOptForce64,
+		growth:  uint32(sbf.growth_rate),
+	}
+
+	// the bits of all the filters are in the mmaped region of the top filter
+	mem := sbf.Top().mem
+	arrays := make([][]byte, 0, len(sbf.filters))
+	for _, bf := range sbf.filters {
+		link, err := bf.redisLink()
+		if err != nil {
+			return nil, err
+		}
+		chain.size += link.size
+		chain.links = append(chain.links, link)
+		arrays = append(arrays, mem[bf.bitOffset:bf.bitOffset+bf.bit_width])
+	}
+	return redisDump(chain, arrays), nil
+}
+
+// LoadRedisScalableBloom creates a scalable bloom filter at opts.Path from the chunks of a RedisBloom filter
+// returned by BF.SCANDUMP. The filter has format version 3, and the capacity, error rate and growth rate
+// of the RedisBloom filter, so that it grows like the RedisBloom filter would.
+//
+// An existing filter file at the path is replaced once the filter is loaded.
+func LoadRedisScalableBloom(opts *BloomOptions, chunks []RedisChunk) (*ScalableBloomFilter, error) {
+	if opts == nil {
+		opts = &DefaultBloomOptions
+	}
+	chain, arrays, err := redisLoad(chunks)
+	if err != nil {
+		return nil, err
+	}
+	first := chain.links[0]
+
+	o := *opts
+	o.Err_rate = first.errRate
+	o.Capacity = int(first.entries)
+	o.GrowthRate = GrowthRate(chain.growth)
+	o.FormatVersion = formatVersion3
+	o.Hasher = HasherMurmur2
+	if o.Path == "" {
+		o.Path = DefaultBloomOptions.Path
+	}
+
+	// the filter is loaded in a temporary file, which replaces the filter file once the filter is complete
+	err = buildFilterFile(o.Path, func(path string) (Filter, error) {
+		o := o
+		o.Path = path
+		sbf, err := NewScalableBloomE(&o)
+		if err != nil {
+			return nil, err
+		}
+
+		var buf bytes.Buffer
+		sh := &scalableHeader{
+			version:    scalableFormatVersion,
+			growthRate: uint32(sbf.growth_rate),
+			filters:    uint32(len(chain.links)),
+			errRate:    sbf.err_rate,
+			capacity:   uint64(sbf.capacity),
+			ratio:      sbf.ratio,
+		}
+		header := make([]byte, scalableHeaderSize)
+		sh.marshal(header)
+		buf.Write(header)
+		for i, link := range chain.links {
+			h := link.header()
+			header := make([]byte, h.size())
+			h.marshal(header)
+			buf.Write(header)
+			buf.Write(arrays[i])
+		}
+		if _, err := sbf.ReadFrom(&buf); err != nil {
+			sbf.Close()
+			return nil, fmt.Errorf("unable to load the RedisBloom filter: %w", err)
+		}
+		return sbf, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return NewScalableBloomE(&o)
+}
@@ -0,0 +1,264 @@
+package sprout
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+)
+
+// redisFixture is the dump of the BF.SCANDUMP example of the RedisBloom documentation:
+//
+//	BF.RESERVE bf 0.1 10
+//	BF.ADD bf item1
+//	BF.SCANDUMP bf 0
+//	BF.SCANDUMP bf 1
+//	BF.SCANDUMP bf 9
+var redisFixture = []RedisChunk{
+	{Iter: 1, Data: []byte("\x01\x00\x00\x00\x00\x00\x00\x00\x01\x00\x00\x00\x05\x00\x00\x00\x02\x00\x00\x00" +
+		"\x08\x00\x00\x00\x00\x00\x00\x00\x40\x00\x00\x00\x00\x00\x00\x00\x01\x00\x00\x00\x00\x00\x00\x00" +
+		"\x9a\x99\x99\x99\x99\x99\xa9\x3f\x4a\xf7\xd4\x9e\xde\xf0\x18\x40\x05\x00\x00\x00" +
+		"\x0a\x00\x00\x00\x00\x00\x00\x00\x00")},
+	{Iter: 9, Data: []byte("\x01\x08\x00\x80\x00\x04\x20\x00")},
+	{Iter: 0, Data: []byte{}},
+}
+
+func TestLoadRedisScalableBloom(t *testing.T) {
+	opts := &BloomOptions{Path: "./test.db"}
+	defer os.Remove(opts.Path)
+
+	t.Run("should load the RedisBloom filter", func(t *testing.T) {
+		sbf, err := LoadRedisScalableBloom(opts, redisFixture)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		defer sbf.Close()
+
+		if !sbf.Contains([]byte("item1")) {
+			t.Errorf("Expected key item1 to be found")
+		}
+		if sbf.Contains([]byte("item2")) {
+			t.Errorf("Expected key item2 not to be found")
+		}
+		if sbf.Count() != 1 || sbf.Capacity() != 10 {
+			t.Errorf("Expected 1 key and a capacity of 10, got %d and %d", sbf.Count(), sbf.Capacity())
+		}
+
+		chunks, err := sbf.DumpRedis()
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if !reflect.DeepEqual(chunks, redisFixture[:2]) {
+			t.Errorf("Expected the dump to match the RedisBloom dump, got %q", chunks)
+		}
+	})
+
+	t.Run("should dump a new filter like RedisBloom", func(t *testing.T) {
+		os.Remove(opts.Path)
+		// BF.RESERVE tightens the error rate of the first filter
+		sbf := NewScalableBloom(&BloomOptions{Err_rate: 0.1 * redisTighteningRatio, Capacity: 10, Path: opts.Path, FormatVersion: formatVersion3})
+		defer sbf.Close()
+
+		sbf.Add([]byte("item1"))
+		chunks, err := sbf.DumpRedis()
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if !reflect.DeepEqual(chunks, redisFixture[:2]) {
+			t.Errorf("Expected the dump to match the RedisBloom dump, got %q", chunks)
+		}
+	})
+
+	t.Run("should grow like RedisBloom", func(t *testing.T) {
+		sbf, err := LoadRedisScalableBloom(opts, redisFixture)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		defer sbf.Close()
+
+		for i := 0; i < 100; i++ {
+			sbf.Add([]byte(fmt.Sprintf("foo%d", i)))
+		}
+		chunks, err := sbf.DumpRedis()
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		chain, _, err := redisLoad(chunks)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if len(chain.links) < 3 || chain.size != 101 || chain.growth != 2 {
+			t.Fatalf("Expected the filter to grow to hold 101 keys, got %d filters holding %d keys", len(chain.links), chain.size)
+		}
+		for i, link := range chain.links {
+			if link.entries != 10<<i || link.errRate != 0.05/float64(int(1)<<i) {
+				t.Errorf("Expected filter %d to have capacity %d and error rate %v, got %d and %v",
+					i, 10<<i, 0.05/float64(int(1)<<i), link.entries, link.errRate)
+			}
+		}
+
+		if _, err := LoadRedisBloom(&BloomOptions{Path: "./test2.db"}, chunks); !errors.Is(err, ErrInvalidHeader) {
+			t.Errorf("Expected ErrInvalidHeader loading a scalable filter as a bloom filter, got %v", err)
+		}
+	})
+}
+
+func TestBloomFilter_DumpRedis(t *testing.T) {
+	opts := &BloomOptions{
+		Err_rate:      0.01,
+		Capacity:      10000,
+		Path:          "./test.db",
+		FormatVersion: formatVersion3,
+	}
+	bf := NewBloom(opts)
+	opts2 := *opts
+	opts2.Path = "./test2.db"
+	defer func() {
+		bf.Close()
+		os.Remove(opts.Path)
+		os.Remove(opts2.Path)
+	}()
+
+	for i := 0; i < 5000; i++ {
+		bf.Add([]byte(fmt.Sprintf("foo%d", i)))
+	}
+	chunks, err := bf.DumpRedis()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	t.Run("should dump a non scaling RedisBloom filter", func(t *testing.T) {
+		chain, arrays, err := redisLoad(chunks)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if chain.options != redisOptNoRound|redisOptForce64|redisOptNoScaling || chain.size != 5000 || len(chain.links) != 1 {
+			t.Errorf("Expected a non scaling filter holding 5000 keys, got options %d and %d keys", chain.options, chain.size)
+		}
+
+		// BF.RESERVE bf 0.01 10000 NONSCALING
+		link := chain.links[0]
+		if link.bits != 95872 || link.bytes != 11984 || link.hashes != 7 {
+			t.Errorf("Expected the size of the RedisBloom filter, got %d bits, %d bytes and %d hashes", link.bits, link.bytes, link.hashes)
+		}
+		if !bytes.Equal(arrays[0], bf.mem[bf.bitOffset:bf.bitOffset+bf.bit_width]) {
+			t.Errorf("Expected the bit array to be dumped as is")
+		}
+	})
+
+	t.Run("should load the dump in chunks of any size", func(t *testing.T) {
+		// split the bit array in small chunks, as BF.SCANDUMP may
+		small := chunks[:1]
+		array := chunks[1].Data
+		for offset := 0; offset < len(array); offset += 1000 {
+			end := offset + 1000
+			if end > len(array) {
+				end = len(array)
+			}
+			small = append(small, RedisChunk{Iter: int64(end + 1), Data: array[offset:end]})
+		}
+
+		bf2, err := LoadRedisBloom(&opts2, small)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		defer bf2.Close()
+		if bf2.Count() != 5000 || bf2.Capacity() != 10000 {
+			t.Errorf("Expected 5000 keys and a capacity of 10000, got %d and %d", bf2.Count(), bf2.Capacity())
+		}
+		for i := 0; i < 5000; i++ {
+			if !bf2.Contains([]byte(fmt.Sprintf("foo%d", i))) {
+				t.Fatalf("Expected key foo%d to be found", i)
+			}
+		}
+	})
+
+	t.Run("should be stored in the filter file", func(t *testing.T) {
+		bf2, err := OpenBloom(opts2.Path)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		defer bf2.Close()
+		if !bf2.Contains([]byte("foo0")) {
+			t.Errorf("Expected key foo0 to be found")
+		}
+	})
+}
+
+func TestRedisBloom_Errors(t *testing.T) {
+	defer os.Remove("./test.db")
+
+	t.Run("only filters of format version 3 can be dumped", func(t *testing.T) {
+		bf := NewBloom(&BloomOptions{Err_rate: 0.01, Capacity: 1000, Path: "./test.db"})
+		defer func() {
+			bf.Close()
+			os.Remove("./test.db")
+		}()
+		if _, err := bf.DumpRedis(); !errors.Is(err, ErrInvalidOptions) {
+			t.Errorf("Expected ErrInvalidOptions, got %v", err)
+		}
+	})
+
+	t.Run("format version 3 requires murmur2", func(t *testing.T) {
+		_, err := NewBloomE(&BloomOptions{Err_rate: 0.01, Capacity: 1000, Path: "./test.db", FormatVersion: formatVersion3, Hasher: HasherXXHash})
+		if !errors.Is(err, ErrInvalidOptions) {
+			t.Errorf("Expected ErrInvalidOptions, got %v", err)
+		}
+	})
+
+	t.Run("should reject invalid dumps", func(t *testing.T) {
+		opts := &BloomOptions{Path: "./test.db"}
+		header := redisFixture[0]
+		hugeHeader := append([]byte{}, header.Data...)
+		binary.LittleEndian.PutUint64(hugeHeader[20:], 1<<40)
+		binary.LittleEndian.PutUint64(hugeHeader[28:], 8<<40)
+		tests := map[string][]RedisChunk{
+			"no chunks":       nil,
+			"no header":       redisFixture[1:],
+			"short header":    {{Iter: 1, Data: header.Data[:30]}},
+			"out of bounds":   {header, {Iter: 10, Data: redisFixture[1].Data}},
+			"32-bit hashes":   {{Iter: 1, Data: append(append([]byte{}, header.Data[:12]...), append([]byte{1, 0, 0, 0}, header.Data[16:]...)...)}},
+			"too many hashes": {{Iter: 1, Data: append(append([]byte{}, header.Data[:60]...), append([]byte{0xff, 0, 0, 0}, header.Data[64:]...)...)}},
+			"missing chunks":  {header},
+			"extra chunks":    append(append([]RedisChunk{}, redisFixture...), RedisChunk{Iter: 2, Data: []byte{0}}),
+			"huge filter":     {{Iter: 1, Data: hugeHeader}},
+		}
+		for name, chunks := range tests {
+			if _, err := LoadRedisScalableBloom(opts, chunks); !errors.Is(err, ErrInvalidHeader) {
+				t.Errorf("%s: expected ErrInvalidHeader, got %v", name, err)
+			}
+		}
+
+		// the fixture is too small for a bloom filter
+		if _, err := LoadRedisBloom(opts, redisFixture); !errors.Is(err, ErrInvalidOptions) {
+			t.Errorf("Expected ErrInvalidOptions, got %v", err)
+		}
+	})
+
+	t.Run("should keep the existing filter if the dump is invalid", func(t *testing.T) {
+		opts := &BloomOptions{Err_rate: 0.01, Capacity: 1000, Path: "./test.db"}
+		sbf := NewScalableBloom(opts)
+		sbf.Add([]byte("foo"))
+		sbf.Close()
+		defer os.Remove(opts.Path)
+
+		if _, err := LoadRedisScalableBloom(opts, redisFixture[:1]); !errors.Is(err, ErrInvalidHeader) {
+			t.Errorf("Expected ErrInvalidHeader, got %v", err)
+		}
+		if _, err := LoadRedisBloom(opts, redisFixture); !errors.Is(err, ErrInvalidOptions) {
+			t.Errorf("Expected ErrInvalidOptions, got %v", err)
+		}
+
+		sbf, err := OpenScalableBloom(opts.Path)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		defer sbf.Close()
+		if !sbf.Contains([]byte("foo")) {
+			t.Errorf("Expected the existing filter to be kept")
+		}
+	})
+}
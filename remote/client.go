@@ -0,0 +1,322 @@
+// Package remote serves sprout filters over gRPC, and implements a client that can replace a local filter.
+//
+// The service is defined in sprout.proto. The server hosts the named filters of a server.Registry:
+//
+//	reg, err := server.NewRegistry("./filters", nil)
+//	s := grpc.NewServer()
+//	remote.Register(s, reg)
+//
+// and a Client is a sprout.KeyValueFilter backed by one of them:
+//
+//	var f sprout.Filter = remote.NewClient(conn, "users")
+package remote
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative sprout.proto
+
+import (
+	"context"
+	"io"
+	"log"
+
+	"github.com/dsa0x/sprout"
+	"github.com/dsa0x/sprout/server"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// maxBatchKeys is the maximum number of keys sent in a message of AddBatch
+	maxBatchKeys = 1000
+
+	// maxBatchBytes is the size of the keys after which a message of AddBatch is sent
+	maxBatchBytes = 1 << 20
+)
+
+// Client is a filter of a remote sprout server, which implements sprout.KeyValueFilter
+// so that it can replace a local filter.
+//
+// The methods of the filter API that cannot return an error log it, and return false or a zero value.
+// ContainsE and StatsE return the error instead.
+// The errors of the server match the sprout errors with errors.Is, like sprout.ErrCapacityReached.
+type Client struct {
+	client FilterServiceClient
+	name   string
+
+	// conn is closed with the client, if the client dialed it
+	conn *grpc.ClientConn
+}
+
+// NewClient returns a client of the filter with the given name of the server at the other end of conn.
+// conn is not closed with the client.
+func NewClient(conn grpc.ClientConnInterface, name string) *Client {
+	return &Client{client: NewFilterServiceClient(conn), name: name}
+}
+
+// Dial connects to the server at target, and returns a client of the filter with the given name.
+// The connection is closed with the client.
+func Dial(target, name string, opts ...grpc.DialOption) (*Client, error) {
+	conn, err := grpc.Dial(target, opts...)
+	if err != nil {
+		return nil, err
+	}
+	c := NewClient(conn, name)
+	c.conn = conn
+	return c, nil
+}
+
+// Add adds the key to the remote filter, creating the filter if it does not exist
+func (c *Client) Add(key []byte) error {
+	_, err := c.client.Add(context.Background(), &AddRequest{Filter: c.name, Key: key})
+	return clientError(err)
+}
+
+// AddBatch adds the keys to the remote filter, streaming them in messages of up to 1000 keys.
+// Each message is added entirely or not at all, but the messages sent before an error are added.
+func (c *Client) AddBatch(keys [][]byte) error {
+	// the stream is cancelled if a key cannot be sent, so that it does not leak
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	b, err := c.NewBatchAdder(ctx)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := b.Add(key); err != nil {
+			return err
+		}
+	}
+	_, err = b.Close()
+	return err
+}
+
+// Contains checks if the key may be in the remote filter
+func (c *Client) Contains(key []byte) bool {
+	found, err := c.ContainsE(key)
+	if err != nil {
+		log.Printf("Error checking key %s in remote filter %s: %v", key, c.name, err)
+	}
+	return found
+}
+
+// ContainsE checks if the key may be in the remote filter like Contains, but returns an error instead of logging it.
+func (c *Client) ContainsE(key []byte) (bool, error) {
+	resp, err := c.client.Contains(context.Background(), &ContainsRequest{Filter: c.name, Key: key})
+	if err != nil {
+		return false, clientError(err)
+	}
+	return resp.Found, nil
+}
+
+// ContainsBatch checks if each of the keys may be in the remote filter
+func (c *Client) ContainsBatch(keys [][]byte) []bool {
+	found, err := c.ContainsBatchE(keys)
+	if err != nil {
+		log.Printf("Error checking keys in remote filter %s: %v", c.name, err)
+		return make([]bool, len(keys))
+	}
+	return found
+}
+
+// ContainsBatchE is like ContainsBatch, but returns an error instead of logging it.
+func (c *Client) ContainsBatchE(keys [][]byte) ([]bool, error) {
+	resp, err := c.client.ContainsBatch(context.Background(), &ContainsBatchRequest{Filter: c.name, Keys: keys})
+	if err != nil {
+		return nil, clientError(err)
+	}
+	return resp.Found, nil
+}
+
+// Put adds the key to the remote filter and stores its value in the persistent store of the filter
+func (c *Client) Put(key, val []byte) error {
+	_, err := c.client.Put(context.Background(), &PutRequest{Filter: c.name, Key: key, Value: val})
+	return clientError(err)
+}
+
+// Get returns the value of the key from the persistent store of the remote filter, or nil if it is not found
+func (c *Client) Get(key []byte) []byte {
+	val, err := c.GetE(key)
+	if err != nil {
+		log.Printf("Error getting key %s from remote filter %s: %v", key, c.name, err)
+	}
+	return val
+}
+
+// GetE is like Get, but returns an error instead of logging it.
+func (c *Client) GetE(key []byte) ([]byte, error) {
+	resp, err := c.client.Get(context.Background(), &GetRequest{Filter: c.name, Key: key})
+	if err != nil {
+		return nil, clientError(err)
+	}
+	if !resp.Found {
+		return nil, nil
+	}
+	if resp.Value == nil {
+		return []byte{}, nil
+	}
+	return resp.Value, nil
+}
+
+// Delete removes the key from the persistent store of the remote filter
+func (c *Client) Delete(key []byte) error {
+	_, err := c.client.Delete(context.Background(), &DeleteRequest{Filter: c.name, Key: key})
+	return clientError(err)
+}
+
+// Merge merges the local bloom filter into the remote filter,
+// which must be a bloom filter created with the same options
+func (c *Client) Merge(bf *sprout.BloomFilter) error {
+	data, err := bf.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	_, err = c.client.Merge(context.Background(), &MergeRequest{Filter: c.name, Data: data})
+	return clientError(err)
+}
+
+// Count returns the number of items added to the remote filter
+func (c *Client) Count() int {
+	return c.Stats().Count
+}
+
+// Capacity returns the number of items the remote filter is intended to hold
+func (c *Client) Capacity() int {
+	return c.Stats().Capacity
+}
+
+// Stats returns the stats of the remote filter
+func (c *Client) Stats() sprout.BloomFilterStats {
+	stats, err := c.StatsE()
+	if err != nil {
+		log.Printf("Error getting the stats of remote filter %s: %v", c.name, err)
+	}
+	return stats
+}
+
+// StatsE is like Stats, but returns an error instead of logging it.
+func (c *Client) StatsE() (sprout.BloomFilterStats, error) {
+	resp, err := c.client.Stats(context.Background(), &StatsRequest{Filter: c.name})
+	if err != nil {
+		return sprout.BloomFilterStats{}, clientError(err)
+	}
+	return sprout.BloomFilterStats{
+		Capacity:          int(resp.Capacity),
+		Count:             int(resp.Count),
+		Size:              int(resp.Size),
+		M:                 int(resp.M),
+		K:                 int(resp.K),
+		Prob:              resp.Prob,
+		FalseNegativeProb: resp.FalseNegativeProb,
+	}, nil
+}
+
+// Close closes the connection of the client, if the client dialed it. The remote filter stays open.
+func (c *Client) Close() error {
+	if c.conn != nil {
+		return c.conn.Close()
+	}
+	return nil
+}
+
+// BatchAdder streams keys to a remote filter, for bulk loading.
+// The keys are sent in messages of up to 1000 keys as they are added, and Close sends the last message.
+type BatchAdder struct {
+	stream FilterService_AddBatchClient
+	name   string
+	keys   [][]byte
+	size   int
+	sent   bool
+}
+
+// NewBatchAdder opens a stream adding keys to the remote filter.
+// The stream is canceled with ctx, and must be closed with Close.
+func (c *Client) NewBatchAdder(ctx context.Context) (*BatchAdder, error) {
+	stream, err := c.client.AddBatch(ctx)
+	if err != nil {
+		return nil, clientError(err)
+	}
+	return &BatchAdder{stream: stream, name: c.name}, nil
+}
+
+// Add adds the key to the batch. The key is copied, and can be reused by the caller.
+func (b *BatchAdder) Add(key []byte) error {
+	b.keys = append(b.keys, append([]byte(nil), key...))
+	b.size += len(key)
+	if len(b.keys) < maxBatchKeys && b.size < maxBatchBytes {
+		return nil
+	}
+	return b.flush()
+}
+
+// flush sends the buffered keys
+func (b *BatchAdder) flush() error {
+	req := &AddBatchRequest{Keys: b.keys}
+	if !b.sent {
+		req.Filter = b.name
+	}
+	b.keys, b.size, b.sent = nil, 0, true
+
+	if err := b.stream.Send(req); err != nil {
+		// the error of the stream is returned by CloseAndRecv
+		if err == io.EOF {
+			_, err = b.stream.CloseAndRecv()
+		}
+		return clientError(err)
+	}
+	return nil
+}
+
+// Close sends the remaining keys and closes the stream.
+// It returns the number of keys added to the remote filter.
+func (b *BatchAdder) Close() (int, error) {
+	if len(b.keys) > 0 || !b.sent {
+		if err := b.flush(); err != nil {
+			return 0, err
+		}
+	}
+	resp, err := b.stream.CloseAndRecv()
+	if err != nil {
+		return 0, clientError(err)
+	}
+	return int(resp.Count), nil
+}
+
+// remoteError is an error returned by the server, which matches the sprout error of its code with errors.Is
+type remoteError struct {
+	err    error
+	target error
+}
+
+func (e *remoteError) Error() string { return e.err.Error() }
+
+func (e *remoteError) Unwrap() error { return e.err }
+
+func (e *remoteError) Is(target error) bool { return target == e.target }
+
+// GRPCStatus returns the status of the error, for status.FromError
+func (e *remoteError) GRPCStatus() *status.Status {
+	s, _ := status.FromError(e.err)
+	return s
+}
+
+// clientError returns the error of a call, matching the sprout error of its code
+func clientError(err error) error {
+	if err == nil {
+		return nil
+	}
+	var target error
+	switch status.Code(err) {
+	case codes.NotFound:
+		target = server.ErrNotFound
+	case codes.FailedPrecondition:
+		target = sprout.ErrNoStore
+	case codes.ResourceExhausted:
+		target = sprout.ErrCapacityReached
+	default:
+		return err
+	}
+	return &remoteError{err: err, target: target}
+}
+
+var _ sprout.KeyValueFilter = (*Client)(nil)
@@ -0,0 +1,234 @@
+package remote
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/dsa0x/sprout"
+	"github.com/dsa0x/sprout/server"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// startServer serves the filters of a registry in dir on a local listener
+func startServer(t *testing.T, dir string) (*server.Registry, string) {
+	t.Helper()
+	reg, err := server.NewRegistry(dir, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	reg.NewStore = func(path string) (sprout.Store, error) {
+		return sprout.NewBoltE(strings.TrimSuffix(path, ".db")+".bolt", 0600)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	s := grpc.NewServer()
+	Register(s, reg)
+	go s.Serve(l)
+	t.Cleanup(func() {
+		s.Stop()
+		reg.Close()
+	})
+	return reg, l.Addr().String()
+}
+
+func dial(t *testing.T, addr, name string) *Client {
+	t.Helper()
+	c, err := Dial(addr, name, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+// testFilter runs the same checks against a local or a remote filter
+func testFilter(t *testing.T, f sprout.Filter) {
+	for i := 0; i < 100; i++ {
+		if err := f.Add([]byte(fmt.Sprintf("foo%d", i))); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	}
+	for i := 0; i < 100; i++ {
+		if !f.Contains([]byte(fmt.Sprintf("foo%d", i))) {
+			t.Fatalf("Expected key foo%d to be found", i)
+		}
+	}
+	if f.Contains([]byte("bar")) {
+		t.Errorf("Expected key bar not to be found")
+	}
+	if f.Count() != 100 || f.Capacity() != 1000 {
+		t.Errorf("Expected 100 keys and a capacity of 1000, got %d and %d", f.Count(), f.Capacity())
+	}
+}
+
+func TestClient(t *testing.T) {
+	dir := t.TempDir()
+	reg, addr := startServer(t, dir)
+	opts := &sprout.BloomOptions{Err_rate: 0.01, Capacity: 1000}
+
+	t.Run("it can replace a local filter", func(t *testing.T) {
+		local := *opts
+		local.Path = "./test.db"
+		bf := sprout.NewBloom(&local)
+		defer func() {
+			bf.Close()
+			os.Remove(local.Path)
+		}()
+		testFilter(t, bf)
+
+		if _, err := reg.Reserve("bloom", opts, false); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		testFilter(t, dial(t, addr, "bloom"))
+	})
+
+	t.Run("it streams batches of keys", func(t *testing.T) {
+		c := dial(t, addr, "batch")
+		keys := make([][]byte, 2500)
+		for i := range keys {
+			keys[i] = []byte(fmt.Sprintf("foo%d", i))
+		}
+		if err := c.AddBatch(keys); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		found := c.ContainsBatch(append(keys, []byte("bar")))
+		for i := range keys {
+			if !found[i] {
+				t.Fatalf("Expected key foo%d to be found", i)
+			}
+		}
+		if found[len(keys)] {
+			t.Errorf("Expected key bar not to be found")
+		}
+
+		stats, err := c.StatsE()
+		if err != nil || stats.Count != 2500 || stats.Capacity < 2500 {
+			t.Errorf("Expected a scalable filter holding 2500 keys, got %+v: %v", stats, err)
+		}
+	})
+
+	t.Run("it counts the keys of a stream", func(t *testing.T) {
+		b, err := dial(t, addr, "stream").NewBatchAdder(context.Background())
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		key := make([]byte, 4)
+		for i := 0; i < 1500; i++ {
+			copy(key, fmt.Sprintf("%04d", i))
+			if err := b.Add(key); err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+		}
+		n, err := b.Close()
+		if err != nil || n != 1500 {
+			t.Errorf("Expected 1500 keys to be added, got %d: %v", n, err)
+		}
+	})
+
+	t.Run("it reports a full filter", func(t *testing.T) {
+		if _, err := reg.Reserve("small", &sprout.BloomOptions{Err_rate: 0.01, Capacity: 20}, false); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		keys := make([][]byte, 21)
+		for i := range keys {
+			keys[i] = []byte(fmt.Sprintf("foo%d", i))
+		}
+		err := dial(t, addr, "small").AddBatch(keys)
+		if !errors.Is(err, sprout.ErrCapacityReached) || status.Code(err) != codes.ResourceExhausted {
+			t.Errorf("Expected ErrCapacityReached, got %v", err)
+		}
+	})
+
+	t.Run("it puts, gets and deletes values", func(t *testing.T) {
+		var f sprout.KeyValueFilter = dial(t, addr, "values")
+		if err := f.Put([]byte("foo"), []byte("bar")); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if val := f.Get([]byte("foo")); string(val) != "bar" {
+			t.Errorf("Expected bar, got %q", val)
+		}
+		if err := f.Delete([]byte("foo")); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if val, err := f.GetE([]byte("foo")); err != nil || val != nil {
+			t.Errorf("Expected the key to be deleted, got %q: %v", val, err)
+		}
+	})
+
+	t.Run("it merges a local filter", func(t *testing.T) {
+		if _, err := reg.Reserve("merge", opts, false); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		local := *opts
+		local.Path = "./test.db"
+		bf := sprout.NewBloom(&local)
+		defer func() {
+			bf.Close()
+			os.Remove(local.Path)
+		}()
+		bf.Add([]byte("foo"))
+
+		c := dial(t, addr, "merge")
+		if err := c.Merge(bf); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if !c.Contains([]byte("foo")) {
+			t.Errorf("Expected key foo to be found")
+		}
+
+		if err := dial(t, addr, "batch").Merge(bf); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("Expected InvalidArgument merging into a scalable filter, got %v", err)
+		}
+		if err := dial(t, addr, "small").Merge(bf); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("Expected InvalidArgument merging a filter with other options, got %v", err)
+		}
+	})
+
+	t.Run("it reports missing filters", func(t *testing.T) {
+		c := dial(t, addr, "missing")
+		if found, err := c.ContainsE([]byte("foo")); err != nil || found {
+			t.Errorf("Expected a missing filter to contain no key, got %v: %v", found, err)
+		}
+		if _, err := c.StatsE(); !errors.Is(err, server.ErrNotFound) {
+			t.Errorf("Expected ErrNotFound, got %v", err)
+		}
+		if !reflect.DeepEqual(c.ContainsBatch([][]byte{[]byte("foo")}), []bool{false}) {
+			t.Errorf("Expected a missing filter to contain no key")
+		}
+	})
+}
+
+func TestClient_NoStore(t *testing.T) {
+	reg, err := server.NewRegistry(t.TempDir(), nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer reg.Close()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	s := grpc.NewServer()
+	Register(s, reg)
+	go s.Serve(l)
+	defer s.Stop()
+
+	c := dial(t, l.Addr().String(), "foo")
+	if err := c.Put([]byte("foo"), []byte("bar")); !errors.Is(err, sprout.ErrNoStore) {
+		t.Errorf("Expected ErrNoStore, got %v", err)
+	}
+}
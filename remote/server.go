@@ -0,0 +1,171 @@
+package remote
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"github.com/dsa0x/sprout"
+	"github.com/dsa0x/sprout/server"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// filterServer implements FilterService over the filters of a registry
+type filterServer struct {
+	UnimplementedFilterServiceServer
+	reg *server.Registry
+}
+
+// NewServer returns the FilterService of the filters of the registry
+func NewServer(reg *server.Registry) FilterServiceServer {
+	return &filterServer{reg: reg}
+}
+
+// Register registers the FilterService of the filters of the registry on the gRPC server
+func Register(s *grpc.Server, reg *server.Registry) {
+	RegisterFilterServiceServer(s, NewServer(reg))
+}
+
+func (s *filterServer) Add(ctx context.Context, req *AddRequest) (*AddResponse, error) {
+	f, err := s.reg.GetOrCreate(req.Filter)
+	if err != nil {
+		return nil, statusError(err)
+	}
+	if err := f.Add(req.Key); err != nil {
+		return nil, statusError(err)
+	}
+	return &AddResponse{}, nil
+}
+
+// AddBatch adds the keys of each message as a batch, so that a message is added entirely or not at all
+func (s *filterServer) AddBatch(stream FilterService_AddBatchServer) error {
+	var f server.Filter
+	var count int64
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return stream.SendAndClose(&AddBatchResponse{Count: count})
+		}
+		if err != nil {
+			return err
+		}
+		if f == nil {
+			if f, err = s.reg.GetOrCreate(req.Filter); err != nil {
+				return statusError(err)
+			}
+		}
+		if err := f.AddBatch(req.Keys); err != nil {
+			return statusError(err)
+		}
+		count += int64(len(req.Keys))
+	}
+}
+
+func (s *filterServer) Contains(ctx context.Context, req *ContainsRequest) (*ContainsResponse, error) {
+	f, err := s.reg.Get(req.Filter)
+	if errors.Is(err, server.ErrNotFound) {
+		return &ContainsResponse{}, nil
+	}
+	if err != nil {
+		return nil, statusError(err)
+	}
+	return &ContainsResponse{Found: f.Contains(req.Key)}, nil
+}
+
+func (s *filterServer) ContainsBatch(ctx context.Context, req *ContainsBatchRequest) (*ContainsBatchResponse, error) {
+	f, err := s.reg.Get(req.Filter)
+	if errors.Is(err, server.ErrNotFound) {
+		return &ContainsBatchResponse{Found: make([]bool, len(req.Keys))}, nil
+	}
+	if err != nil {
+		return nil, statusError(err)
+	}
+	return &ContainsBatchResponse{Found: f.ContainsBatch(req.Keys)}, nil
+}
+
+func (s *filterServer) Put(ctx context.Context, req *PutRequest) (*PutResponse, error) {
+	f, err := s.reg.GetOrCreate(req.Filter)
+	if err != nil {
+		return nil, statusError(err)
+	}
+	if err := f.Put(req.Key, req.Value); err != nil {
+		return nil, statusError(err)
+	}
+	return &PutResponse{}, nil
+}
+
+func (s *filterServer) Get(ctx context.Context, req *GetRequest) (*GetResponse, error) {
+	f, err := s.reg.Get(req.Filter)
+	if err != nil {
+		return nil, statusError(err)
+	}
+	val, err := f.GetE(req.Key)
+	if err != nil {
+		return nil, statusError(err)
+	}
+	return &GetResponse{Value: val, Found: val != nil}, nil
+}
+
+func (s *filterServer) Delete(ctx context.Context, req *DeleteRequest) (*DeleteResponse, error) {
+	f, err := s.reg.Get(req.Filter)
+	if err != nil {
+		return nil, statusError(err)
+	}
+	if err := f.Delete(req.Key); err != nil {
+		return nil, statusError(err)
+	}
+	return &DeleteResponse{}, nil
+}
+
+func (s *filterServer) Stats(ctx context.Context, req *StatsRequest) (*StatsResponse, error) {
+	f, err := s.reg.Get(req.Filter)
+	if err != nil {
+		return nil, statusError(err)
+	}
+	stats := f.Stats()
+	return &StatsResponse{
+		Capacity:          int64(stats.Capacity),
+		Count:             int64(stats.Count),
+		Size:              int64(stats.Size),
+		M:                 int64(stats.M),
+		K:                 int64(stats.K),
+		Prob:              stats.Prob,
+		FalseNegativeProb: stats.FalseNegativeProb,
+	}, nil
+}
+
+// Merge merges the encoded filter into a bloom filter, scalable filters cannot be merged
+func (s *filterServer) Merge(ctx context.Context, req *MergeRequest) (*MergeResponse, error) {
+	f, err := s.reg.Get(req.Filter)
+	if err != nil {
+		return nil, statusError(err)
+	}
+	bf, ok := f.(*sprout.BloomFilter)
+	if !ok {
+		return nil, status.Errorf(codes.InvalidArgument, "filter %s is not a bloom filter and cannot be merged", req.Filter)
+	}
+	if err := bf.MergeBinary(req.Data); err != nil {
+		return nil, statusError(err)
+	}
+	return &MergeResponse{}, nil
+}
+
+// statusError returns the gRPC status of the error, see FilterService for the codes
+func statusError(err error) error {
+	code := codes.Internal
+	switch {
+	case errors.Is(err, server.ErrNotFound):
+		code = codes.NotFound
+	case errors.Is(err, sprout.ErrInvalidOptions), errors.Is(err, sprout.ErrInvalidHeader), errors.Is(err, sprout.ErrHeaderMismatch):
+		code = codes.InvalidArgument
+	case errors.Is(err, sprout.ErrNoStore):
+		code = codes.FailedPrecondition
+	case errors.Is(err, sprout.ErrCapacityReached):
+		code = codes.ResourceExhausted
+	case errors.Is(err, server.ErrRegistryClosed):
+		code = codes.Unavailable
+	}
+	return status.Error(code, err.Error())
+}
@@ -0,0 +1,442 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+
+package remote
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// FilterServiceClient is the client API for FilterService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type FilterServiceClient interface {
+	// Add adds a key to a filter
+	Add(ctx context.Context, in *AddRequest, opts ...grpc.CallOption) (*AddResponse, error)
+	// AddBatch adds the keys streamed by the client to a filter
+	AddBatch(ctx context.Context, opts ...grpc.CallOption) (FilterService_AddBatchClient, error)
+	// Contains checks if a key may be in a filter, a filter that does not exist contains no key
+	Contains(ctx context.Context, in *ContainsRequest, opts ...grpc.CallOption) (*ContainsResponse, error)
+	// ContainsBatch checks if each of the keys may be in a filter
+	ContainsBatch(ctx context.Context, in *ContainsBatchRequest, opts ...grpc.CallOption) (*ContainsBatchResponse, error)
+	// Put adds a key to a filter and stores its value in the persistent store of the filter
+	Put(ctx context.Context, in *PutRequest, opts ...grpc.CallOption) (*PutResponse, error)
+	// Get returns the value of a key from the persistent store of a filter
+	Get(ctx context.Context, in *GetRequest, opts ...grpc.CallOption) (*GetResponse, error)
+	// Delete removes a key from the persistent store of a filter
+	Delete(ctx context.Context, in *DeleteRequest, opts ...grpc.CallOption) (*DeleteResponse, error)
+	// Stats returns the stats of a filter
+	Stats(ctx context.Context, in *StatsRequest, opts ...grpc.CallOption) (*StatsResponse, error)
+	// Merge merges an encoded bloom filter into a bloom filter with the same options
+	Merge(ctx context.Context, in *MergeRequest, opts ...grpc.CallOption) (*MergeResponse, error)
+}
+
+type filterServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewFilterServiceClient(cc grpc.ClientConnInterface) FilterServiceClient {
+	return &filterServiceClient{cc}
+}
+
+func (c *filterServiceClient) Add(ctx context.Context, in *AddRequest, opts ...grpc.CallOption) (*AddResponse, error) {
+	out := new(AddResponse)
+	err := c.cc.Invoke(ctx, "/sprout.v1.FilterService/Add", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *filterServiceClient) AddBatch(ctx context.Context, opts ...grpc.CallOption) (FilterService_AddBatchClient, error) {
+	stream, err := c.cc.NewStream(ctx, &FilterService_ServiceDesc.Streams[0], "/sprout.v1.FilterService/AddBatch", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &filterServiceAddBatchClient{stream}
+	return x, nil
+}
+
+type FilterService_AddBatchClient interface {
+	Send(*AddBatchRequest) error
+	CloseAndRecv() (*AddBatchResponse, error)
+	grpc.ClientStream
+}
+
+type filterServiceAddBatchClient struct {
+	grpc.ClientStream
+}
+
+func (x *filterServiceAddBatchClient) Send(m *AddBatchRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *filterServiceAddBatchClient) CloseAndRecv() (*AddBatchResponse, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(AddBatchResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *filterServiceClient) Contains(ctx context.Context, in *ContainsRequest, opts ...grpc.CallOption) (*ContainsResponse, error) {
+	out := new(ContainsResponse)
+	err := c.cc.Invoke(ctx, "/sprout.v1.FilterService/Contains", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *filterServiceClient) ContainsBatch(ctx context.Context, in *ContainsBatchRequest, opts ...grpc.CallOption) (*ContainsBatchResponse, error) {
+	out := new(ContainsBatchResponse)
+	err := c.cc.Invoke(ctx, "/sprout.v1.FilterService/ContainsBatch", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *filterServiceClient) Put(ctx context.Context, in *PutRequest, opts ...grpc.CallOption) (*PutResponse, error) {
+	out := new(PutResponse)
+	err := c.cc.Invoke(ctx, "/sprout.v1.FilterService/Put", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *filterServiceClient) Get(ctx context.Context, in *GetRequest, opts ...grpc.CallOption) (*GetResponse, error) {
+	out := new(GetResponse)
+	err := c.cc.Invoke(ctx, "/sprout.v1.FilterService/Get", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *filterServiceClient) Delete(ctx context.Context, in *DeleteRequest, opts ...grpc.CallOption) (*DeleteResponse, error) {
+	out := new(DeleteResponse)
+	err := c.cc.Invoke(ctx, "/sprout.v1.FilterService/Delete", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *filterServiceClient) Stats(ctx context.Context, in *StatsRequest, opts ...grpc.CallOption) (*StatsResponse, error) {
+	out := new(StatsResponse)
+	err := c.cc.Invoke(ctx, "/sprout.v1.FilterService/Stats", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *filterServiceClient) Merge(ctx context.Context, in *MergeRequest, opts ...grpc.CallOption) (*MergeResponse, error) {
+	out := new(MergeResponse)
+	err := c.cc.Invoke(ctx, "/sprout.v1.FilterService/Merge", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// FilterServiceServer is the server API for FilterService service.
+// All implementations must embed UnimplementedFilterServiceServer
+// for forward compatibility
+type FilterServiceServer interface {
+	// Add adds a key to a filter
+	Add(context.Context, *AddRequest) (*AddResponse, error)
+	// AddBatch adds the keys streamed by the client to a filter
+	AddBatch(FilterService_AddBatchServer) error
+	// Contains checks if a key may be in a filter, a filter that does not exist contains no key
+	Contains(context.Context, *ContainsRequest) (*ContainsResponse, error)
+	// ContainsBatch checks if each of the keys may be in a filter
+	ContainsBatch(context.Context, *ContainsBatchRequest) (*ContainsBatchResponse, error)
+	// Put adds a key to a filter and stores its value in the persistent store of the filter
+	Put(context.Context, *PutRequest) (*PutResponse, error)
+	// Get returns the value of a key from the persistent store of a filter
+	Get(context.Context, *GetRequest) (*GetResponse, error)
+	// Delete removes a key from the persistent store of a filter
+	Delete(context.Context, *DeleteRequest) (*DeleteResponse, error)
+	// Stats returns the stats of a filter
+	Stats(context.Context, *StatsRequest) (*StatsResponse, error)
+	// Merge merges an encoded bloom filter into a bloom filter with the same options
+	Merge(context.Context, *MergeRequest) (*MergeResponse, error)
+	mustEmbedUnimplementedFilterServiceServer()
+}
+
+// UnimplementedFilterServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedFilterServiceServer struct {
+}
+
+func (UnimplementedFilterServiceServer) Add(context.Context, *AddRequest) (*AddResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Add not implemented")
+}
+func (UnimplementedFilterServiceServer) AddBatch(FilterService_AddBatchServer) error {
+	return status.Errorf(codes.Unimplemented, "method AddBatch not implemented")
+}
+func (UnimplementedFilterServiceServer) Contains(context.Context, *ContainsRequest) (*ContainsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Contains not implemented")
+}
+func (UnimplementedFilterServiceServer) ContainsBatch(context.Context, *ContainsBatchRequest) (*ContainsBatchResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ContainsBatch not implemented")
+}
+func (UnimplementedFilterServiceServer) Put(context.Context, *PutRequest) (*PutResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Put not implemented")
+}
+func (UnimplementedFilterServiceServer) Get(context.Context, *GetRequest) (*GetResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Get not implemented")
+}
+func (UnimplementedFilterServiceServer) Delete(context.Context, *DeleteRequest) (*DeleteResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Delete not implemented")
+}
+func (UnimplementedFilterServiceServer) Stats(context.Context, *StatsRequest) (*StatsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Stats not implemented")
+}
+func (UnimplementedFilterServiceServer) Merge(context.Context, *MergeRequest) (*MergeResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Merge not implemented")
+}
+func (UnimplementedFilterServiceServer) mustEmbedUnimplementedFilterServiceServer() {}
+
+// UnsafeFilterServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to FilterServiceServer will
+// result in compilation errors.
+type UnsafeFilterServiceServer interface {
+	mustEmbedUnimplementedFilterServiceServer()
+}
+
+func RegisterFilterServiceServer(s grpc.ServiceRegistrar, srv FilterServiceServer) {
+	s.RegisterService(&FilterService_ServiceDesc, srv)
+}
+
+func _FilterService_Add_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AddRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FilterServiceServer).Add(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/sprout.v1.FilterService/Add",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FilterServiceServer).Add(ctx, req.(*AddRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FilterService_AddBatch_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(FilterServiceServer).AddBatch(&filterServiceAddBatchServer{stream})
+}
+
+type FilterService_AddBatchServer interface {
+	SendAndClose(*AddBatchResponse) error
+	Recv() (*AddBatchRequest, error)
+	grpc.ServerStream
+}
+
+type filterServiceAddBatchServer struct {
+	grpc.ServerStream
+}
+
+func (x *filterServiceAddBatchServer) SendAndClose(m *AddBatchResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *filterServiceAddBatchServer) Recv() (*AddBatchRequest, error) {
+	m := new(AddBatchRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _FilterService_Contains_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ContainsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FilterServiceServer).Contains(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/sprout.v1.FilterService/Contains",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FilterServiceServer).Contains(ctx, req.(*ContainsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FilterService_ContainsBatch_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ContainsBatchRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FilterServiceServer).ContainsBatch(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/sprout.v1.FilterService/ContainsBatch",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FilterServiceServer).ContainsBatch(ctx, req.(*ContainsBatchRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FilterService_Put_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PutRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FilterServiceServer).Put(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/sprout.v1.FilterService/Put",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FilterServiceServer).Put(ctx, req.(*PutRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FilterService_Get_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FilterServiceServer).Get(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/sprout.v1.FilterService/Get",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FilterServiceServer).Get(ctx, req.(*GetRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FilterService_Delete_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FilterServiceServer).Delete(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/sprout.v1.FilterService/Delete",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FilterServiceServer).Delete(ctx, req.(*DeleteRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FilterService_Stats_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StatsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FilterServiceServer).Stats(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/sprout.v1.FilterService/Stats",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FilterServiceServer).Stats(ctx, req.(*StatsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _FilterService_Merge_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MergeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(FilterServiceServer).Merge(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/sprout.v1.FilterService/Merge",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(FilterServiceServer).Merge(ctx, req.(*MergeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// FilterService_ServiceDesc is the grpc.ServiceDesc for FilterService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var FilterService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "sprout.v1.FilterService",
+	HandlerType: (*FilterServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Add",
+			Handler:    _FilterService_Add_Handler,
+		},
+		{
+			MethodName: "Contains",
+			Handler:    _FilterService_Contains_Handler,
+		},
+		{
+			MethodName: "ContainsBatch",
+			Handler:    _FilterService_ContainsBatch_Handler,
+		},
+		{
+			MethodName: "Put",
+			Handler:    _FilterService_Put_Handler,
+		},
+		{
+			MethodName: "Get",
+			Handler:    _FilterService_Get_Handler,
+		},
+		{
+			MethodName: "Delete",
+			Handler:    _FilterService_Delete_Handler,
+		},
+		{
+			MethodName: "Stats",
+			Handler:    _FilterService_Stats_Handler,
+		},
+		{
+			MethodName: "Merge",
+			Handler:    _FilterService_Merge_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "AddBatch",
+			Handler:       _FilterService_AddBatch_Handler,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "remote/sprout.proto",
+}
@@ -1,8 +1,11 @@
 package sprout
 
 import (
+	"errors"
+	"fmt"
 	"log"
 	"math"
+	"os"
 	"sync"
 )
 
@@ -24,7 +27,16 @@ type ScalableBloomFilter struct {
 
 	path string
 	opts *BloomOptions
+
+	// lock guards filters: readers and writers adding to the top filter share it,
+	// and it is only held exclusively to swap in a new top filter
 	lock *sync.RWMutex
+
+	// growLock serialises the growth of the filter
+	growLock *sync.Mutex
+
+	// addLock serialises AddIfAbsent
+	addLock *sync.Mutex
 }
 
 type GrowthRate uint
@@ -42,12 +54,27 @@ var (
 // of items exceed the initial capacity, a new filter is created.
 //
 // The growth rate defaults to 2.
+//
+// NewScalableBloom panics if the filter cannot be created, use NewScalableBloomE to get an error instead.
 func NewScalableBloom(opts *BloomOptions) *ScalableBloomFilter {
+	sbf, err := NewScalableBloomE(opts)
+	if err != nil {
+		log.Panicf("%v", err)
+	}
+	return sbf
+}
+
+// NewScalableBloomE creates a new scalable bloom filter like NewScalableBloom,
+// but returns an error instead of panicking.
+func NewScalableBloomE(opts *BloomOptions) (*ScalableBloomFilter, error) {
 	if opts.Err_rate <= 0 || opts.Err_rate >= 1 {
-		panic("Error rate must be between 0 and 1")
+		return nil, fmt.Errorf("%w: error rate must be between 0 and 1", ErrInvalidOptions)
 	}
 	if opts.Capacity <= 0 {
-		panic("Initial capacity must be greater than 0")
+		return nil, fmt.Errorf("%w: initial capacity must be greater than 0", ErrInvalidOptions)
+	}
+	if err := validateFormat(opts); err != nil {
+		return nil, err
 	}
 	if opts.GrowthRate == 0 {
 		opts.GrowthRate = GrowthSmall
@@ -57,57 +84,283 @@ func NewScalableBloom(opts *BloomOptions) *ScalableBloomFilter {
 		opts.Path = "/tmp/bloom.db"
 	}
 
-	initialFilter := NewBloom(opts)
-	return &ScalableBloomFilter{
+	// filters in the RedisBloom format tighten the error rate like RedisBloom
+	ratio := 0.9 // Source: [1]
+	if opts.FormatVersion == formatVersion3 {
+		ratio = redisTighteningRatio
+	}
+
+	sbf := &ScalableBloomFilter{
+		err_rate:    opts.Err_rate,
+		capacity:    opts.Capacity,
+		growth_rate: opts.GrowthRate,
+		ratio:       ratio,
+		db:          opts.Database,
+		path:        opts.Path,
+		opts:        opts,
+		lock:        &sync.RWMutex{},
+		growLock:    &sync.Mutex{},
+		addLock:     &sync.Mutex{},
+	}
+	if err := sbf.open(); err != nil {
+		return nil, err
+	}
+	return sbf, nil
+}
+
+// OpenScalableBloom restores the scalable bloom filter stored in the file at path,
+// including all the filters added as the scalable filter grew.
+//
+// database is the persistent store to attach to the filter. can be omitted.
+func OpenScalableBloom(path string, database ...Store) (*ScalableBloomFilter, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open bloom filter file: %w", err)
+	}
+	h, err := readScalableHeader(file)
+	file.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	opts := &BloomOptions{
+		Path:       path,
+		Err_rate:   h.errRate,
+		Capacity:   int(h.capacity),
+		GrowthRate: GrowthRate(h.growthRate),
+	}
+	if len(database) > 0 {
+		opts.Database = database[0]
+	}
+
+	sbf := &ScalableBloomFilter{
 		err_rate:    opts.Err_rate,
 		capacity:    opts.Capacity,
 		growth_rate: opts.GrowthRate,
-		ratio:       0.9, // Source: [1]
-		m0:          initialFilter.m,
-		filters:     []*BloomFilter{initialFilter},
+		ratio:       h.ratio,
 		db:          opts.Database,
 		path:        opts.Path,
 		opts:        opts,
 		lock:        &sync.RWMutex{},
+		growLock:    &sync.Mutex{},
+		addLock:     &sync.Mutex{},
 	}
+	if err := sbf.open(); err != nil {
+		return nil, err
+	}
+	return sbf, nil
+}
+
+// open restores the filters from the file, or creates the initial filter if the file is empty
+func (sbf *ScalableBloomFilter) open() error {
+	file, err := os.OpenFile(sbf.path, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return fmt.Errorf("unable to open bloom filter file: %w", err)
+	}
+	defer file.Close()
+
+	fi, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if fi.Size() == 0 {
+		return sbf.create()
+	}
+
+	h, err := readScalableHeader(file)
+	if err != nil {
+		return err
+	}
+	if h.errRate != sbf.err_rate || h.capacity != uint64(sbf.capacity) || h.growthRate != uint32(sbf.growth_rate) {
+		return fmt.Errorf("%w: file has capacity %d, error rate %v and growth rate %d",
+			ErrHeaderMismatch, h.capacity, h.errRate, h.growthRate)
+	}
+	sbf.ratio = h.ratio
+
+	// walk the regions of the filters, only the top filter is mapped
+	filters := make([]*BloomFilter, 0, h.filters)
+	offset := scalableHeaderSize
+	for i := 0; i < int(h.filters); i++ {
+		fh, err := readHeader(file, int64(offset))
+		if err == nil && fh.magic != headerMagic {
+			err = fmt.Errorf("%w: not a bloom filter", ErrInvalidHeader)
+		}
+		if err != nil {
+			return fmt.Errorf("unable to read filter %d: %w", i, err)
+		}
+		bf := bloomFromHeader(fh, sbf.db)
+		opts := &BloomOptions{
+			Path:          sbf.path,
+			Err_rate:      fh.errRate,
+			Capacity:      int(fh.capacity),
+			Database:      sbf.db,
+			FormatVersion: sbf.opts.FormatVersion,
+			Hasher:        sbf.opts.Hasher,
+			dataSize:      offset,
+		}
+		if i == int(h.filters)-1 {
+			if err := bf.open(opts); err != nil {
+				return err
+			}
+		} else {
+			bf.setRegion(opts)
+		}
+		offset = bf.opts.dataSize
+		filters = append(filters, bf)
+	}
+
+	sbf.filters = filters
+	sbf.m0 = filters[0].m
+	return nil
+}
+
+// create creates the initial filter, right after the scalable filter header
+func (sbf *ScalableBloomFilter) create() error {
+	opts := sbf.initialOptions()
+	filter := newBloomFilter(opts)
+	if err := filter.open(opts); err != nil {
+		return err
+	}
+	sbf.filters = []*BloomFilter{filter}
+	sbf.m0 = filter.m
+	sbf.storeHeader()
+	return nil
+}
+
+// initialOptions returns the options of the first filter
+func (sbf *ScalableBloomFilter) initialOptions() *BloomOptions {
+	opts := *sbf.opts
+	opts.dataSize = scalableHeaderSize
+	return &opts
 }
 
 // Add adds a key to the scalable bloom filter
 // Complexity: O(k)
-func (sbf *ScalableBloomFilter) Add(key []byte) {
-	sbf.add(key)
+//
+// Keys are added to the top filter without an exclusive lock, which is only taken
+// to swap in a new top filter when the filter grows.
+func (sbf *ScalableBloomFilter) Add(key []byte) error {
+	for {
+		sbf.lock.RLock()
+		top := sbf.Top()
+		err := top.Add(key)
+		sbf.lock.RUnlock()
+
+		if !errors.Is(err, ErrCapacityReached) {
+			return err
+		}
+		if err := sbf.grow(top); err != nil {
+			return err
+		}
+	}
 }
 
-func (sbf *ScalableBloomFilter) add(key []byte) {
-	if sbf.Top().count >= sbf.Top().capacity {
-		sbf.grow()
-	}
+// AddIfAbsent adds the key to the scalable bloom filter if it is not already in any of its filters.
+// The check and the insertion are done atomically, so that concurrent callers
+// adding the same key do not both see it as new.
+//
+// added is false if the key was probably already in the filter.
+func (sbf *ScalableBloomFilter) AddIfAbsent(key []byte) (added bool, err error) {
+	sbf.addLock.Lock()
+	defer sbf.addLock.Unlock()
+
+	// the key is added to the top filter with its AddIfAbsent, so that a key
+	// a concurrent Add has just added to the top filter is not reported as new
+	for {
+		sbf.lock.RLock()
+		top := sbf.Top()
+		if sbf.containsAny(key) {
+			sbf.lock.RUnlock()
+			return false, nil
+		}
+		added, err := top.AddIfAbsent(key)
+		sbf.lock.RUnlock()
 
-	// the top filter is the one holding the mmaped bytes
-	bf := sbf.Top()
+		if !errors.Is(err, ErrCapacityReached) {
+			return added, err
+		}
+		if err := sbf.grow(top); err != nil {
+			return false, err
+		}
+	}
+}
 
-	indices := bf.candidates(string(key))
+// AddBatch adds the keys to the scalable bloom filter, growing it as needed.
+// The lock is only taken once per filter the keys are added to.
+func (sbf *ScalableBloomFilter) AddBatch(keys [][]byte) error {
+	for len(keys) > 0 {
+		sbf.lock.RLock()
+		top := sbf.Top()
+		var err error
+		n := 0
+		for ; n < len(keys); n++ {
+			if err = top.Add(keys[n]); err != nil {
+				break
+			}
+		}
+		sbf.lock.RUnlock()
 
-	for i := 0; i < len(indices); i++ {
-		idx, mask := bf.getBitIndexN(indices[i])
-		if int(idx) >= bf.bit_width {
-			// this should not happen
-			panic("Error adding key: Index out of bounds")
+		keys = keys[n:]
+		if !errors.Is(err, ErrCapacityReached) {
+			return err
+		}
+		if err := sbf.grow(top); err != nil {
+			return err
 		}
-		bf.mem[bf.pageOffset+int(idx)] |= mask
 	}
-	bf.count++
+	return nil
 }
 
 // Put adds a key to the scalable bloom filter, and puts the value in the database
 func (sbf *ScalableBloomFilter) Put(key, val []byte) error {
-	sbf.add(key)
+	if !storeReady(sbf.db) {
+		return fmt.Errorf("%w, use Add() to add keys", ErrNoStore)
+	}
+	if err := sbf.Add(key); err != nil {
+		return err
+	}
 	return sbf.db.Put(key, val)
 }
 
+// PutBatch adds the keys to the scalable bloom filter, and puts their values in the database in a single transaction.
+// vals[i] is the value of keys[i].
+func (sbf *ScalableBloomFilter) PutBatch(keys, vals [][]byte) error {
+	if !storeReady(sbf.db) {
+		return fmt.Errorf("%w, use AddBatch() to add keys", ErrNoStore)
+	}
+	if len(keys) != len(vals) {
+		return fmt.Errorf("got %d keys and %d values", len(keys), len(vals))
+	}
+	if err := sbf.AddBatch(keys); err != nil {
+		return err
+	}
+	return putBatch(sbf.db, keys, vals)
+}
+
 // Contains checks if the key is in the bloom filter
 // Complexity: O(k*n)
 func (sbf *ScalableBloomFilter) Contains(key []byte) bool {
+	sbf.lock.RLock()
+	defer sbf.lock.RUnlock()
+
+	return sbf.containsAny(key)
+}
+
+// ContainsBatch checks if each of the keys is in the bloom filter.
+// The i-th result is the result of Contains(keys[i]).
+func (sbf *ScalableBloomFilter) ContainsBatch(keys [][]byte) []bool {
+	sbf.lock.RLock()
+	defer sbf.lock.RUnlock()
+
+	res := make([]bool, len(keys))
+	for i, key := range keys {
+		res[i] = sbf.containsAny(key)
+	}
+	return res
+}
+
+// containsAny checks if the key is in any of the filters, the caller must hold the lock
+func (sbf *ScalableBloomFilter) containsAny(key []byte) bool {
 	for _, filter := range sbf.filters {
 		if sbf.contains(filter, key) {
 			return true
@@ -119,7 +372,8 @@ func (sbf *ScalableBloomFilter) Contains(key []byte) bool {
 func (sbf *ScalableBloomFilter) contains(bf *BloomFilter, key []byte) bool {
 	topFilter := sbf.Top()
 
-	indices := bf.candidates(string(key))
+	var buf [maxStackIndices]uint64
+	indices := bf.appendCandidates(buf[:0], key)
 
 	for i := 0; i < len(indices); i++ {
 		idx, mask := bf.getBitIndexN(indices[i])
@@ -127,7 +381,7 @@ func (sbf *ScalableBloomFilter) contains(bf *BloomFilter, key []byte) bool {
 		if int(idx) >= bf.bit_width {
 			return false
 		}
-		if bit := topFilter.mem[bf.pageOffset+int(idx)]; bit&mask == 0 {
+		if !testBit(topFilter.mem, bf.bitOffset+int(idx), mask) {
 			return false
 		}
 	}
@@ -136,49 +390,123 @@ func (sbf *ScalableBloomFilter) contains(bf *BloomFilter, key []byte) bool {
 
 // Get returns the value associated with the key
 func (sbf *ScalableBloomFilter) Get(key []byte) []byte {
-	for _, filter := range sbf.filters {
-		if filter.Contains(key) {
-			return filter.Get(key)
-		}
+	val, err := sbf.GetE(key)
+	if errors.Is(err, ErrNoStore) {
+		log.Panicf("ScalableBloomFilter has no persistent store. Use Contains() instead")
 	}
-	return nil
+	if err != nil {
+		fmt.Printf("Error getting key %s from db: %s\n", key, err)
+		return nil
+	}
+	return val
 }
 
-// Top returns the top filter in the scalable bloom filter
+// GetE returns the value associated with the key like Get, but returns an error instead of panicking.
+// A nil value is returned if the key is not found.
+func (sbf *ScalableBloomFilter) GetE(key []byte) ([]byte, error) {
+	if !storeReady(sbf.db) {
+		return nil, fmt.Errorf("%w, use Contains() instead", ErrNoStore)
+	}
+	if !sbf.Contains(key) {
+		return nil, nil
+	}
+	return sbf.db.Get(key)
+}
+
+// Delete removes the key from the persistent store.
+// Bits cannot be unset in a bloom filter, so Contains may still report the key.
+func (sbf *ScalableBloomFilter) Delete(key []byte) error {
+	if !storeReady(sbf.db) {
+		return fmt.Errorf("%w, keys cannot be removed from a bloom filter", ErrNoStore)
+	}
+	return sbf.db.Delete(key)
+}
+
+// Top returns the top filter in the scalable bloom filter.
+// The top filter changes when the filter grows.
 func (sbf *ScalableBloomFilter) Top() *BloomFilter {
 	return sbf.filters[len(sbf.filters)-1]
 }
 
-// grow increases the capacity of the bloom filter by adding a new filter
-func (sbf *ScalableBloomFilter) grow() {
+// grow increases the capacity of the bloom filter by adding a new filter after the full top filter.
+//
+// Growth is serialised by growLock, and the region of the new filter is mapped
+// while readers keep using the old top filter. The lock is only taken to swap in the new top filter.
+func (sbf *ScalableBloomFilter) grow(full *BloomFilter) error {
+	sbf.growLock.Lock()
+	defer sbf.growLock.Unlock()
+
+	// another writer has already grown the filter
+	top := sbf.Top()
+	if top != full {
+		return nil
+	}
+
+	// the new filter starts where the top filter ends
+	opts := sbf.growOptions(len(sbf.filters), top)
 
-	// unmap the old top filter
-	err := sbf.Top().Close()
+	// the new filter maps the whole file, and takes over the file handle and lock of the top filter
+	newFilter := newBloomFilter(opts)
+	newFilter.setRegion(opts)
+	newFilter.memFile = top.memFile
+	newFilter.flock = top.flock
+	if err := newFilter.mapRegion(); err != nil {
+		return fmt.Errorf("Error growing filter: %w", err)
+	}
+
+	sbf.lock.Lock()
+	defer sbf.lock.Unlock()
+
+	sbf.filters = append(sbf.filters, newFilter)
+	sbf.storeHeader()
+
+	// no reader uses the old top filter anymore
+	err := top.unmap()
+	top.mem, top.memFile, top.flock = nil, nil, nil
 	if err != nil {
-		log.Panicf("Error unmapping top filter before grow: %v", err)
+		return fmt.Errorf("Error unmapping top filter after grow: %w", err)
 	}
+	return nil
+}
 
-	err_rate := sbf.err_rate * math.Pow(sbf.ratio, float64(len(sbf.filters)))
-	newCapacity := sbf.getNewCap()
-	opts := &BloomOptions{
-		Err_rate: err_rate,
-		Capacity: newCapacity,
-		Database: sbf.db,
-		Path:     sbf.path,
-		dataSize: sbf.Top().bit_width,
+// growOptions returns the options of the i-th filter, whose region follows the region of prev
+func (sbf *ScalableBloomFilter) growOptions(i int, prev *BloomFilter) *BloomOptions {
+	// a new filter keeps the format version and hasher of the filters before it,
+	// which may come from the file rather than the options the filter was opened with
+	return &BloomOptions{
+		Err_rate:      sbf.err_rate * math.Pow(sbf.ratio, float64(i)),
+		Capacity:      sbf.getNewCap(i),
+		Database:      sbf.db,
+		Path:          sbf.path,
+		FormatVersion: prev.version,
+		Hasher:        prev.hasher,
+		dataSize:      prev.opts.dataSize,
 	}
-	newFilter := NewBloom(opts)
-	sbf.filters = append(sbf.filters, newFilter)
 }
 
-func (sbf *ScalableBloomFilter) getNewCap() int {
-	i := float64(len(sbf.filters)) - 1.0
-	newCapacity := float64(sbf.m0) * float64(math.Pow(float64(sbf.growth_rate), i)) * math.Ln2
+// getNewCap returns the capacity of the i-th filter
+func (sbf *ScalableBloomFilter) getNewCap(i int) int {
+	if sbf.filters[0].version == formatVersion3 {
+		// RedisBloom multiplies the capacity of the previous filter by the growth rate
+		capacity := sbf.capacity
+		for ; i > 0; i-- {
+			capacity *= int(sbf.growth_rate)
+		}
+		return capacity
+	}
+	newCapacity := float64(sbf.m0) * float64(math.Pow(float64(sbf.growth_rate), float64(i-1))) * math.Ln2
 	return int(newCapacity)
 }
 
 // Size returns the total capacity of the scalable bloom filter
 func (sbf *ScalableBloomFilter) Capacity() int {
+	sbf.lock.RLock()
+	defer sbf.lock.RUnlock()
+
+	return sbf.totalCapacity()
+}
+
+func (sbf *ScalableBloomFilter) totalCapacity() int {
 	sum := 0
 	for _, filter := range sbf.filters {
 		sum += filter.capacity
@@ -191,6 +519,31 @@ func (sbf *ScalableBloomFilter) filterSize() int {
 	return sbf.Top().bit_width
 }
 
+// FilterSize returns the total size of the filters of the scalable bloom filter
+func (sbf *ScalableBloomFilter) FilterSize() int {
+	sbf.lock.RLock()
+	defer sbf.lock.RUnlock()
+
+	sum := 0
+	for _, filter := range sbf.filters {
+		sum += filter.bit_width
+	}
+	return sum
+}
+
+// NumFilters returns the number of filters the scalable bloom filter has grown to
+func (sbf *ScalableBloomFilter) NumFilters() int {
+	sbf.lock.RLock()
+	defer sbf.lock.RUnlock()
+
+	return len(sbf.filters)
+}
+
+// GrowthRate returns the rate at which the capacity of the scalable bloom filter grows
+func (sbf *ScalableBloomFilter) GrowthRate() GrowthRate {
+	return sbf.growth_rate
+}
+
 // DB returns the store used by the scalable bloom filter
 func (sbf *ScalableBloomFilter) DB() Store {
 	return sbf.db
@@ -198,15 +551,25 @@ func (sbf *ScalableBloomFilter) DB() Store {
 
 // Count returns the number of items added to the bloom filter
 func (sbf *ScalableBloomFilter) Count() int {
+	sbf.lock.RLock()
+	defer sbf.lock.RUnlock()
+
+	return sbf.count()
+}
+
+func (sbf *ScalableBloomFilter) count() int {
 	sum := 0
 	for _, filter := range sbf.filters {
-		sum += filter.count
+		sum += filter.Count()
 	}
 	return sum
 }
 
 // Close closes the scalable bloom filter
 func (sbf *ScalableBloomFilter) Close() error {
+	sbf.lock.Lock()
+	defer sbf.lock.Unlock()
+
 	return sbf.Top().Close()
 }
 
@@ -220,9 +583,12 @@ func (sbf *ScalableBloomFilter) prob() float64 {
 
 // Stats returns the stats of the bloom filter
 func (sbf *ScalableBloomFilter) Stats() BloomFilterStats {
+	sbf.lock.RLock()
+	defer sbf.lock.RUnlock()
+
 	return BloomFilterStats{
-		Capacity: sbf.Capacity(),
-		Count:    sbf.Count(),
+		Capacity: sbf.totalCapacity(),
+		Count:    sbf.count(),
 		Size:     sbf.filterSize(),
 		M:        sbf.Top().m,
 		K:        sbf.Top().k,
@@ -232,12 +598,44 @@ func (sbf *ScalableBloomFilter) Stats() BloomFilterStats {
 
 // Clear resets all bits in the bloom filter
 func (sbf *ScalableBloomFilter) Clear() {
+	if err := sbf.ClearE(); err != nil {
+		log.Panicf("%v", err)
+	}
+}
+
+// ClearE resets all bits in the bloom filter like Clear, but returns an error instead of panicking.
+//
+// The first filter is reset in place, and the regions of the other filters are dropped.
+// The file stays open and locked, so no other process can open it while it is cleared.
+func (sbf *ScalableBloomFilter) ClearE() error {
+	sbf.growLock.Lock()
+	defer sbf.growLock.Unlock()
 	sbf.lock.Lock()
 	defer sbf.lock.Unlock()
-	err := sbf.Top().Close()
-	if err != nil {
-		log.Panicf("Error closing top filter before clear: %v", err)
+
+	first, top := sbf.filters[0], sbf.Top()
+	if first != top {
+		// the first filter takes over the file handle and lock of the top filter
+		err := top.unmap()
+		if err != nil {
+			return fmt.Errorf("Error unmapping top filter before clear: %w", err)
+		}
+		first.memFile, first.flock = top.memFile, top.flock
+		top.mem, top.memFile, top.flock = nil, nil, nil
+		sbf.filters = []*BloomFilter{first}
 	}
-	sbf.filters = []*BloomFilter{NewBloom(sbf.opts)}
 
+	// drop the regions of the other filters
+	if err := first.memFile.Truncate(int64(first.opts.dataSize)); err != nil {
+		return fmt.Errorf("Error truncating filter file: %w", err)
+	}
+	if first != top {
+		if err := first.mmap(); err != nil {
+			return fmt.Errorf("Error mapping first filter before clear: %w", err)
+		}
+	}
+	sbf.storeHeader()
+	return first.ClearE()
 }
+
+var _ KeyValueFilter = (*ScalableBloomFilter)(nil)
@@ -1,8 +1,10 @@
 package sprout
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"sync"
 	"testing"
 )
 
@@ -13,7 +15,10 @@ func TestScalableBloom(t *testing.T) {
 		Path:     "./test.db",
 	}
 	sbf := NewScalableBloom(opts)
-	defer sbf.Close()
+	defer func() {
+		sbf.Close()
+		os.Remove(opts.Path)
+	}()
 
 	t.Run("success", func(t *testing.T) {
 		key := []byte("foo")
@@ -31,7 +36,10 @@ func TestScalableBloomFilter_AddToDB(t *testing.T) {
 		Path:     "./test.db",
 	}
 	sbf := NewScalableBloom(opts)
-	defer sbf.Close()
+	defer func() {
+		sbf.Close()
+		os.Remove(opts.Path)
+	}()
 
 	t.Run("success", func(t *testing.T) {
 		key, val := []byte("foo"), []byte("var")
@@ -49,6 +57,20 @@ func TestScalableBloomFilter_AddToDB(t *testing.T) {
 			t.Errorf("expected value to be nil, got %s; error: %v", val, err)
 		}
 	})
+	t.Run("should delete the key from the store", func(t *testing.T) {
+		key, val := []byte("foo"), []byte("var")
+		sbf.Put(key, val)
+
+		if err := sbf.Delete(key); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if found, err := sbf.db.Has(key); err != nil || found {
+			t.Errorf("expected key to be deleted, got %v; error: %v", found, err)
+		}
+		if val, err := sbf.GetE(key); err != nil || val != nil {
+			t.Errorf("expected value to be nil, got %s; error: %v", val, err)
+		}
+	})
 }
 func TestScalableBloomFilter_GrowFilter(t *testing.T) {
 	store, cleanupFunc := DBSetupTest(t)
@@ -61,7 +83,10 @@ func TestScalableBloomFilter_GrowFilter(t *testing.T) {
 		Path:     "./test.db",
 	}
 	sbf := NewScalableBloom(opts)
-	defer sbf.Close()
+	defer func() {
+		sbf.Close()
+		os.Remove(opts.Path)
+	}()
 
 	t.Run("should grow filter when capacity is full", func(t *testing.T) {
 		key, val := []byte("foo"), []byte("var")
@@ -111,7 +136,8 @@ func Test_CompareBFnSBF(t *testing.T) {
 		if bf.bit_width != sbf.Top().bit_width {
 			t.Errorf("expected bf and sbf to have the same bit_width; got %d and %d", bf.bit_width, sbf.Top().bit_width)
 		}
-		if len(bf.mem) != len(sbf.Top().mem) {
+		// the sbf file also holds the scalable filter header
+		if len(bf.mem) != len(sbf.Top().mem)-scalableHeaderSize {
 			t.Errorf("expected bf and sbf to have the same bit_width; got %d and %d", bf.bit_width, sbf.Top().bit_width)
 		}
 
@@ -128,3 +154,404 @@ func Test_CompareBFnSBF(t *testing.T) {
 		os.Remove("./test2.db")
 	}()
 }
+
+func TestOpenScalableBloom(t *testing.T) {
+	initialCap := 100
+	opts := &BloomOptions{
+		Err_rate: 0.01,
+		Capacity: initialCap,
+		Path:     "./test.db",
+	}
+	sbf := NewScalableBloom(opts)
+	defer os.Remove(opts.Path)
+
+	for i := 0; i < initialCap*10; i++ {
+		sbf.Add([]byte(fmt.Sprintf("foo%d", i)))
+	}
+	filters := sbf.filters
+	count := sbf.Count()
+	if len(filters) < 3 {
+		t.Fatalf("expected the filter to grow at least twice; got %d filters", len(filters))
+	}
+	if err := sbf.Close(); err != nil {
+		t.Fatalf("expected no error closing filter, got %v", err)
+	}
+
+	t.Run("should restore all the filters from the file", func(t *testing.T) {
+		sbf, err := OpenScalableBloom(opts.Path)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		defer sbf.Close()
+
+		if len(sbf.filters) != len(filters) {
+			t.Fatalf("expected %d filters, got %d", len(filters), len(sbf.filters))
+		}
+		for i, filter := range sbf.filters {
+			if filter.pageOffset != filters[i].pageOffset || filter.capacity != filters[i].capacity ||
+				filter.err_rate != filters[i].err_rate || filter.count != filters[i].count {
+				t.Errorf("expected filter %d to match the original filter", i)
+			}
+		}
+		if sbf.Count() != count {
+			t.Errorf("expected count to be %d, got %d", count, sbf.Count())
+		}
+		for i := 0; i < initialCap*10; i++ {
+			if !sbf.Contains([]byte(fmt.Sprintf("foo%d", i))) {
+				t.Errorf("expected key foo%d to be found in the restored filter", i)
+			}
+		}
+	})
+
+	t.Run("NewScalableBloom with the same options should restore the filter", func(t *testing.T) {
+		sbf := NewScalableBloom(opts)
+		defer sbf.Close()
+
+		if len(sbf.filters) != len(filters) {
+			t.Errorf("expected %d filters, got %d", len(filters), len(sbf.filters))
+		}
+	})
+
+	t.Run("restored filter should keep growing", func(t *testing.T) {
+		sbf, err := OpenScalableBloom(opts.Path)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		for i := initialCap * 10; i < initialCap*40; i++ {
+			sbf.Add([]byte(fmt.Sprintf("foo%d", i)))
+		}
+		n := len(sbf.filters)
+		sbf.Close()
+
+		sbf, err = OpenScalableBloom(opts.Path)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		defer sbf.Close()
+		if len(sbf.filters) != n {
+			t.Errorf("expected %d filters, got %d", n, len(sbf.filters))
+		}
+		for i := 0; i < initialCap*40; i++ {
+			if !sbf.Contains([]byte(fmt.Sprintf("foo%d", i))) {
+				t.Fatalf("expected key foo%d to be found in the restored filter", i)
+			}
+		}
+	})
+
+	t.Run("cleared filter should be restored with a single filter", func(t *testing.T) {
+		sbf, err := OpenScalableBloom(opts.Path)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		sbf.Clear()
+
+		// the file stays locked while it is cleared
+		if _, err := OpenScalableBloom(opts.Path); !errors.Is(err, ErrFileLocked) {
+			t.Errorf("expected ErrFileLocked, got %v", err)
+		}
+		fi, err := os.Stat(opts.Path)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if fi.Size() != int64(sbf.filters[0].opts.dataSize) {
+			t.Errorf("expected the file to be truncated to %d bytes, got %d", sbf.filters[0].opts.dataSize, fi.Size())
+		}
+		if sbf.Contains([]byte("foo0")) {
+			t.Errorf("expected key foo0 not to be found in the cleared filter")
+		}
+		for i := 0; i < initialCap*10; i++ {
+			sbf.Add([]byte(fmt.Sprintf("bar%d", i)))
+		}
+		if len(sbf.filters) < 2 || !sbf.Contains([]byte("bar0")) {
+			t.Errorf("expected the cleared filter to grow again, got %d filters", len(sbf.filters))
+		}
+		sbf.Clear()
+		sbf.Close()
+
+		sbf, err = OpenScalableBloom(opts.Path)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		defer sbf.Close()
+		if len(sbf.filters) != 1 || sbf.Count() != 0 {
+			t.Errorf("expected a single empty filter, got %d filters with count %d", len(sbf.filters), sbf.Count())
+		}
+	})
+}
+
+func TestOpenScalableBloom_GrowKeepsFormat(t *testing.T) {
+	opts := &BloomOptions{
+		Err_rate:      0.01,
+		Capacity:      100,
+		Path:          "./test.db",
+		FormatVersion: formatVersion1,
+		Hasher:        HasherMurmur2,
+	}
+	sbf := NewScalableBloom(opts)
+	defer os.Remove(opts.Path)
+	sbf.Add([]byte("foo"))
+	sbf.Close()
+
+	// the filters grown after reopening keep the format version and hasher of the file
+	sbf, err := OpenScalableBloom(opts.Path)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer sbf.Close()
+	for i := 0; i < 1000; i++ {
+		sbf.Add([]byte(fmt.Sprintf("foo%d", i)))
+	}
+	if len(sbf.filters) < 2 {
+		t.Fatalf("expected the filter to grow; got %d filters", len(sbf.filters))
+	}
+	for i, filter := range sbf.filters {
+		if filter.version != formatVersion1 || filter.hasher.ID() != hashMurmur2 {
+			t.Errorf("expected filter %d to have format version %d and hasher %d, got %d and %d",
+				i, formatVersion1, hashMurmur2, filter.version, filter.hasher.ID())
+		}
+	}
+	for i := 0; i < 1000; i++ {
+		if !sbf.Contains([]byte(fmt.Sprintf("foo%d", i))) {
+			t.Fatalf("expected key foo%d to be found", i)
+		}
+	}
+}
+
+func TestNewScalableBloomE(t *testing.T) {
+	t.Run("should return ErrInvalidOptions for invalid options", func(t *testing.T) {
+		_, err := NewScalableBloomE(&BloomOptions{Err_rate: 1, Capacity: 100, Path: "./test.db"})
+		if !errors.Is(err, ErrInvalidOptions) {
+			t.Errorf("expected ErrInvalidOptions, got %v", err)
+		}
+	})
+
+	t.Run("GetE should find keys in all the filters", func(t *testing.T) {
+		store, cleanupFunc := DBSetupTest(t)
+		defer cleanupFunc()
+		opts := &BloomOptions{
+			Err_rate: 0.01,
+			Capacity: 100,
+			Database: store,
+			Path:     "./test.db",
+		}
+		sbf, err := NewScalableBloomE(opts)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		defer func() {
+			sbf.Close()
+			os.Remove(opts.Path)
+		}()
+
+		if err := sbf.Put([]byte("foo"), []byte("bar")); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		for i := 0; i < opts.Capacity*10; i++ {
+			sbf.Add([]byte(fmt.Sprintf("foo%d", i)))
+		}
+
+		val, err := sbf.GetE([]byte("foo"))
+		if err != nil || string(val) != "bar" {
+			t.Errorf("expected value bar, got %s; error: %v", val, err)
+		}
+	})
+
+	t.Run("should return ErrNoStore without a store", func(t *testing.T) {
+		opts := &BloomOptions{
+			Err_rate: 0.01,
+			Capacity: 100,
+			Path:     "./test.db",
+		}
+		sbf, err := NewScalableBloomE(opts)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		defer func() {
+			sbf.Close()
+			os.Remove(opts.Path)
+		}()
+
+		if err := sbf.Put([]byte("foo"), []byte("bar")); !errors.Is(err, ErrNoStore) {
+			t.Errorf("expected ErrNoStore, got %v", err)
+		}
+		if _, err := sbf.GetE([]byte("foo")); !errors.Is(err, ErrNoStore) {
+			t.Errorf("expected ErrNoStore, got %v", err)
+		}
+	})
+}
+
+func TestScalableBloomFilter_Batch(t *testing.T) {
+	store, cleanupFunc := BadgerDBSetupTest(t)
+	defer cleanupFunc()
+	opts := &BloomOptions{
+		Err_rate: 0.01,
+		Capacity: 100,
+		Database: store,
+		Path:     "./test.db",
+	}
+	sbf := NewScalableBloom(opts)
+	defer func() {
+		sbf.Close()
+		os.Remove(opts.Path)
+	}()
+
+	// the batch does not fit in the first filter
+	keys, vals := make([][]byte, 250), make([][]byte, 250)
+	for i := range keys {
+		keys[i] = []byte(fmt.Sprintf("key%d", i))
+		vals[i] = []byte(fmt.Sprintf("val%d", i))
+	}
+	if err := sbf.PutBatch(keys, vals); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(sbf.filters) < 2 {
+		t.Errorf("Expected the filter to grow, got %d filters", len(sbf.filters))
+	}
+	if sbf.Count() != len(keys) {
+		t.Errorf("Expected count %d, got %d", len(keys), sbf.Count())
+	}
+	for i, found := range sbf.ContainsBatch(keys) {
+		if !found {
+			t.Errorf("Expected key %s to be found", keys[i])
+		}
+		if val := sbf.Get(keys[i]); string(val) != string(vals[i]) {
+			t.Errorf("Expected value %s, got %s", vals[i], val)
+		}
+	}
+}
+
+func TestScalableBloomFilter_AddIfAbsent(t *testing.T) {
+	opts := &BloomOptions{
+		Err_rate: 0.0001,
+		Capacity: 50,
+		Path:     "./test.db",
+	}
+	sbf := NewScalableBloom(opts)
+	defer func() {
+		sbf.Close()
+		os.Remove(opts.Path)
+	}()
+
+	// the filter grows while the keys are added
+	assertAddIfAbsent(t, sbf)
+}
+
+func TestScalableBloomFilter_ConcurrentGrow(t *testing.T) {
+	opts := &BloomOptions{
+		Err_rate: 0.01,
+		Capacity: 100,
+		Path:     "./test.db",
+	}
+	sbf := NewScalableBloom(opts)
+	defer os.Remove(opts.Path)
+
+	workers, n := 8, 400
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(2)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < n; i++ {
+				key := []byte(fmt.Sprintf("key%d-%d", w, i))
+				var err error
+				switch i % 3 {
+				case 0:
+					err = sbf.Add(key)
+				case 1:
+					_, err = sbf.AddIfAbsent(key)
+				default:
+					err = sbf.AddBatch([][]byte{key})
+				}
+				if err != nil {
+					t.Errorf("Expected no error, got %v", err)
+					return
+				}
+			}
+		}(w)
+
+		// readers run while the filter grows
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < n; i++ {
+				key := []byte(fmt.Sprintf("key%d-%d", w, i))
+				sbf.Contains(key)
+				sbf.ContainsBatch([][]byte{key})
+				sbf.Count()
+				sbf.Stats()
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	if len(sbf.filters) < 4 {
+		t.Errorf("Expected the filter to grow several times, got %d filters", len(sbf.filters))
+	}
+	assertAllKeys := func(t *testing.T, sbf *ScalableBloomFilter) {
+		for w := 0; w < workers; w++ {
+			for i := 0; i < n; i++ {
+				if key := []byte(fmt.Sprintf("key%d-%d", w, i)); !sbf.Contains(key) {
+					t.Fatalf("Expected key %s to be in the filter", key)
+				}
+			}
+		}
+	}
+	assertAllKeys(t, sbf)
+
+	// AddIfAbsent may skip a key that is a false positive
+	count := sbf.Count()
+	if count > workers*n || count < workers*n*9/10 {
+		t.Errorf("Expected count close to %d, got %d", workers*n, count)
+	}
+
+	t.Run("the grown filter can be reopened", func(t *testing.T) {
+		filters := len(sbf.filters)
+		if err := sbf.Close(); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		sbf, err := OpenScalableBloom(opts.Path)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		defer sbf.Close()
+		if len(sbf.filters) != filters || sbf.Count() != count {
+			t.Errorf("Expected %d filters and count %d, got %d and %d", filters, count, len(sbf.filters), sbf.Count())
+		}
+		assertAllKeys(t, sbf)
+	})
+}
+
+func TestScalableBloomFilter_Allocs(t *testing.T) {
+	opts := &BloomOptions{
+		Err_rate: 0.0001,
+		Capacity: 100,
+		Path:     "./test.db",
+	}
+	sbf := NewScalableBloom(opts)
+	defer func() {
+		sbf.Close()
+		os.Remove(opts.Path)
+	}()
+
+	// the lookups go through several filters
+	for i := 0; i < 500; i++ {
+		sbf.Add([]byte(fmt.Sprintf("key%d", i)))
+	}
+	if len(sbf.filters) < 3 {
+		t.Fatalf("Expected the filter to grow, got %d filters", len(sbf.filters))
+	}
+
+	key := []byte("https://example.com/some/path?query=1")
+	if allocs := testing.AllocsPerRun(100, func() { sbf.Contains(key) }); allocs != 0 {
+		t.Errorf("Expected Contains to not allocate, got %v allocations", allocs)
+	}
+
+	// Add allocates when it grows the filter, so it is measured on keys that fit in the top filter
+	top := sbf.Top()
+	sbf.Add(key)
+	if allocs := testing.AllocsPerRun(100, func() { sbf.Add(key) }); allocs != 0 {
+		t.Errorf("Expected Add to not allocate, got %v allocations", allocs)
+	}
+	if sbf.Top() != top {
+		t.Errorf("Expected the filter not to grow")
+	}
+}
@@ -0,0 +1,284 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/dsa0x/sprout"
+)
+
+const (
+	// maxJSONBody bounds the size of the JSON body of a request
+	maxJSONBody = 32 << 20
+
+	// maxValueBody bounds the size of a value put in a filter
+	maxValueBody = 32 << 20
+)
+
+// CreateRequest is the body of a request creating a filter
+type CreateRequest struct {
+	// ErrRate is the desired false positive rate of the filter
+	ErrRate float64 `json:"err_rate"`
+
+	// Capacity is the number of keys the filter is intended to hold, or the initial capacity of a scalable filter
+	Capacity int `json:"capacity"`
+
+	// Scalable creates a scalable bloom filter instead of a bloom filter
+	Scalable bool `json:"scalable"`
+
+	// GrowthRate is the growth rate of a scalable filter, defaults to 2
+	GrowthRate int `json:"growth_rate,omitempty"`
+}
+
+// KeysRequest is the body of a request adding keys to a filter or checking them
+type KeysRequest struct {
+	Keys []string `json:"keys"`
+}
+
+// AddResponse is the response to a request adding keys to a filter,
+// Added[i] is false if Keys[i] was probably already in the filter
+type AddResponse struct {
+	Added []bool `json:"added"`
+}
+
+// ContainsResponse is the response to a request checking keys,
+// Contains[i] is true if Keys[i] may be in the filter
+type ContainsResponse struct {
+	Contains []bool `json:"contains"`
+}
+
+// ErrorResponse is the body of the response to a request that failed
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+// httpHandler serves the filters of a registry over HTTP
+type httpHandler struct {
+	reg *Registry
+}
+
+// NewHandler returns an http.Handler serving the filters of the registry as JSON, under the paths:
+//
+//	PUT  /filters/{name}               create the filter described by a CreateRequest
+//	GET  /filters/{name}               the sprout.BloomFilterStats of the filter
+//	POST /filters/{name}/add           add the keys of a KeysRequest, replies an AddResponse
+//	POST /filters/{name}/contains      check the keys of a KeysRequest, replies a ContainsResponse
+//	PUT  /filters/{name}/values/{key}  add the key with the request body as its value
+//	GET  /filters/{name}/values/{key}  the value of the key
+//	POST /filters/{name}/clear         remove all the keys from the filter
+//
+// Adding keys to a filter that does not exist creates a scalable filter with the default options of the registry.
+// Names and keys are path segments, and must be escaped if they contain a slash.
+// Errors are replied as an ErrorResponse.
+//
+// The handler can be mounted under a prefix with http.StripPrefix.
+func NewHandler(reg *Registry) http.Handler {
+	return &httpHandler{reg: reg}
+}
+
+func (h *httpHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	segments, ok := pathSegments(r.URL)
+	if !ok || len(segments) < 2 || segments[0] != "filters" {
+		writeHTTPError(w, http.StatusNotFound, errors.New("not found"))
+		return
+	}
+	name, route := segments[1], segments[2:]
+
+	switch {
+	case len(route) == 0 && r.Method == http.MethodPut:
+		h.create(w, r, name)
+	case len(route) == 0 && r.Method == http.MethodGet:
+		h.stats(w, name)
+	case len(route) == 1 && route[0] == "add" && r.Method == http.MethodPost:
+		h.add(w, r, name)
+	case len(route) == 1 && route[0] == "contains" && r.Method == http.MethodPost:
+		h.contains(w, r, name)
+	case len(route) == 1 && route[0] == "clear" && r.Method == http.MethodPost:
+		h.clear(w, name)
+	case len(route) == 2 && route[0] == "values" && r.Method == http.MethodPut:
+		h.put(w, r, name, route[1])
+	case len(route) == 2 && route[0] == "values" && r.Method == http.MethodGet:
+		h.get(w, name, route[1])
+	case len(route) <= 2:
+		writeHTTPError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+	default:
+		writeHTTPError(w, http.StatusNotFound, errors.New("not found"))
+	}
+}
+
+// pathSegments splits the path of the url in unescaped segments
+func pathSegments(u *url.URL) ([]string, bool) {
+	segments := strings.Split(strings.Trim(u.EscapedPath(), "/"), "/")
+	for i, segment := range segments {
+		s, err := url.PathUnescape(segment)
+		if err != nil {
+			return nil, false
+		}
+		segments[i] = s
+	}
+	return segments, true
+}
+
+func (h *httpHandler) create(w http.ResponseWriter, r *http.Request, name string) {
+	var req CreateRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	opts := &sprout.BloomOptions{
+		Err_rate:   req.ErrRate,
+		Capacity:   req.Capacity,
+		GrowthRate: sprout.GrowthRate(req.GrowthRate),
+	}
+	f, err := h.reg.Reserve(name, opts, req.Scalable)
+	if err != nil {
+		writeHTTPError(w, 0, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, f.Stats())
+}
+
+func (h *httpHandler) stats(w http.ResponseWriter, name string) {
+	f, err := h.reg.Get(name)
+	if err != nil {
+		writeHTTPError(w, 0, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, f.Stats())
+}
+
+func (h *httpHandler) add(w http.ResponseWriter, r *http.Request, name string) {
+	var req KeysRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	f, err := h.reg.GetOrCreate(name)
+	if err != nil {
+		writeHTTPError(w, 0, err)
+		return
+	}
+
+	resp := AddResponse{Added: make([]bool, len(req.Keys))}
+	for i, key := range req.Keys {
+		if resp.Added[i], err = f.AddIfAbsent([]byte(key)); err != nil {
+			writeHTTPError(w, 0, err)
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (h *httpHandler) contains(w http.ResponseWriter, r *http.Request, name string) {
+	var req KeysRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	f, err := h.reg.Get(name)
+	if err != nil {
+		writeHTTPError(w, 0, err)
+		return
+	}
+
+	keys := make([][]byte, len(req.Keys))
+	for i, key := range req.Keys {
+		keys[i] = []byte(key)
+	}
+	writeJSON(w, http.StatusOK, ContainsResponse{Contains: f.ContainsBatch(keys)})
+}
+
+func (h *httpHandler) clear(w http.ResponseWriter, name string) {
+	f, err := h.reg.Get(name)
+	if err != nil {
+		writeHTTPError(w, 0, err)
+		return
+	}
+	if err := f.ClearE(); err != nil {
+		writeHTTPError(w, 0, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *httpHandler) put(w http.ResponseWriter, r *http.Request, name, key string) {
+	val, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxValueBody))
+	if err != nil {
+		writeHTTPError(w, http.StatusBadRequest, err)
+		return
+	}
+	f, err := h.reg.GetOrCreate(name)
+	if err != nil {
+		writeHTTPError(w, 0, err)
+		return
+	}
+	if err := f.Put([]byte(key), val); err != nil {
+		writeHTTPError(w, 0, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *httpHandler) get(w http.ResponseWriter, name, key string) {
+	f, err := h.reg.Get(name)
+	if err != nil {
+		writeHTTPError(w, 0, err)
+		return
+	}
+	val, err := f.GetE([]byte(key))
+	if err != nil {
+		writeHTTPError(w, 0, err)
+		return
+	}
+	if val == nil {
+		writeHTTPError(w, http.StatusNotFound, errors.New("key not found"))
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(val)
+}
+
+// readJSON decodes the JSON body of the request into v, and replies an error if it is not valid
+func readJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxJSONBody))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		writeHTTPError(w, http.StatusBadRequest, err)
+		return false
+	}
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeHTTPError replies the error as an ErrorResponse.
+// If status is 0, the status is derived from the error.
+func writeHTTPError(w http.ResponseWriter, status int, err error) {
+	if status == 0 {
+		status = httpStatus(err)
+	}
+	writeJSON(w, status, ErrorResponse{Error: err.Error()})
+}
+
+// httpStatus returns the status of the response to a request that failed with err
+func httpStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrExists):
+		return http.StatusConflict
+	case errors.Is(err, sprout.ErrInvalidOptions), errors.Is(err, sprout.ErrNoStore):
+		return http.StatusBadRequest
+	case errors.Is(err, sprout.ErrCapacityReached):
+		return http.StatusInsufficientStorage
+	case errors.Is(err, ErrRegistryClosed):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
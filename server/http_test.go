@@ -0,0 +1,167 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/dsa0x/sprout"
+)
+
+// doHTTP sends the request and decodes its JSON response into resp, if not nil
+func doHTTP(t *testing.T, method, url string, body interface{}, wantStatus int, resp interface{}) {
+	t.Helper()
+	var r io.Reader
+	switch body := body.(type) {
+	case nil:
+	case []byte:
+		r = bytes.NewReader(body)
+	default:
+		buf, err := json.Marshal(body)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		r = bytes.NewReader(buf)
+	}
+	req, err := http.NewRequest(method, url, r)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer res.Body.Close()
+
+	data, _ := io.ReadAll(res.Body)
+	if res.StatusCode != wantStatus {
+		t.Fatalf("%s %s: expected status %d, got %d: %s", method, url, wantStatus, res.StatusCode, data)
+	}
+	switch resp := resp.(type) {
+	case nil:
+	case *[]byte:
+		*resp = data
+	default:
+		if err := json.Unmarshal(data, resp); err != nil {
+			t.Fatalf("Expected a JSON response, got %s: %v", data, err)
+		}
+	}
+}
+
+func newTestHandler(t *testing.T, dir string) string {
+	t.Helper()
+	reg := newTestRegistry(t, dir)
+	reg.NewStore = func(path string) (sprout.Store, error) {
+		return sprout.NewBoltE(strings.TrimSuffix(path, ".db")+".bolt", 0600)
+	}
+	srv := httptest.NewServer(NewHandler(reg))
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+func TestHandler(t *testing.T) {
+	dir := t.TempDir()
+	url := newTestHandler(t, dir)
+
+	t.Run("it creates filters", func(t *testing.T) {
+		var stats sprout.BloomFilterStats
+		doHTTP(t, "PUT", url+"/filters/users", CreateRequest{ErrRate: 0.01, Capacity: 1000}, http.StatusCreated, &stats)
+		if stats.Capacity != 1000 || stats.Count != 0 || stats.Prob != 0.01 {
+			t.Errorf("Expected the stats of the new filter, got %+v", stats)
+		}
+		doHTTP(t, "PUT", url+"/filters/scalable", CreateRequest{ErrRate: 0.01, Capacity: 100, Scalable: true, GrowthRate: 4}, http.StatusCreated, nil)
+
+		var errResp ErrorResponse
+		doHTTP(t, "PUT", url+"/filters/users", CreateRequest{ErrRate: 0.01, Capacity: 1000}, http.StatusConflict, &errResp)
+		if errResp.Error != ErrExists.Error() {
+			t.Errorf("Expected %q, got %q", ErrExists, errResp.Error)
+		}
+		doHTTP(t, "PUT", url+"/filters/invalid", CreateRequest{ErrRate: 2, Capacity: 1000}, http.StatusBadRequest, nil)
+		doHTTP(t, "PUT", url+"/filters/invalid", CreateRequest{ErrRate: 0.01, Capacity: 1 << 40}, http.StatusBadRequest, nil)
+		doHTTP(t, "PUT", url+"/filters/invalid", CreateRequest{ErrRate: 0.01, Capacity: 1000, Scalable: true, GrowthRate: 1 << 20}, http.StatusBadRequest, nil)
+		doHTTP(t, "PUT", url+"/filters/invalid", []byte(`{"err_rate": 0.01, "size": 10}`), http.StatusBadRequest, nil)
+		doHTTP(t, "GET", url+"/filters/invalid", nil, http.StatusNotFound, nil)
+	})
+
+	t.Run("it adds and checks keys", func(t *testing.T) {
+		var added AddResponse
+		doHTTP(t, "POST", url+"/filters/users/add", KeysRequest{Keys: []string{"alice", "bob", "alice"}}, http.StatusOK, &added)
+		if !reflect.DeepEqual(added.Added, []bool{true, true, false}) {
+			t.Errorf("Expected [true true false], got %v", added.Added)
+		}
+
+		var contains ContainsResponse
+		doHTTP(t, "POST", url+"/filters/users/contains", KeysRequest{Keys: []string{"alice", "carol"}}, http.StatusOK, &contains)
+		if !reflect.DeepEqual(contains.Contains, []bool{true, false}) {
+			t.Errorf("Expected [true false], got %v", contains.Contains)
+		}
+		doHTTP(t, "POST", url+"/filters/missing/contains", KeysRequest{Keys: []string{"alice"}}, http.StatusNotFound, nil)
+
+		var stats sprout.BloomFilterStats
+		doHTTP(t, "GET", url+"/filters/users", nil, http.StatusOK, &stats)
+		if stats.Count != 2 {
+			t.Errorf("Expected 2 keys, got %d", stats.Count)
+		}
+	})
+
+	t.Run("it creates a scalable filter when keys are added to a missing filter", func(t *testing.T) {
+		doHTTP(t, "POST", url+"/filters/new/add", KeysRequest{Keys: []string{"alice"}}, http.StatusOK, nil)
+		var stats sprout.BloomFilterStats
+		doHTTP(t, "GET", url+"/filters/new", nil, http.StatusOK, &stats)
+		if stats.Capacity != DefaultOptions.Capacity || stats.Count != 1 {
+			t.Errorf("Expected a filter with the default options holding 1 key, got %+v", stats)
+		}
+	})
+
+	t.Run("it puts and gets values", func(t *testing.T) {
+		doHTTP(t, "PUT", url+"/filters/users/values/a%2Fb", []byte("value of a/b"), http.StatusNoContent, nil)
+		var val []byte
+		doHTTP(t, "GET", url+"/filters/users/values/a%2Fb", nil, http.StatusOK, &val)
+		if string(val) != "value of a/b" {
+			t.Errorf("Expected the value put, got %q", val)
+		}
+		doHTTP(t, "GET", url+"/filters/users/values/carol", nil, http.StatusNotFound, nil)
+
+		var contains ContainsResponse
+		doHTTP(t, "POST", url+"/filters/users/contains", KeysRequest{Keys: []string{"a/b"}}, http.StatusOK, &contains)
+		if !contains.Contains[0] {
+			t.Errorf("Expected the key put to be in the filter")
+		}
+	})
+
+	t.Run("it clears filters", func(t *testing.T) {
+		doHTTP(t, "POST", url+"/filters/users/clear", nil, http.StatusNoContent, nil)
+		var stats sprout.BloomFilterStats
+		doHTTP(t, "GET", url+"/filters/users", nil, http.StatusOK, &stats)
+		if stats.Count != 0 {
+			t.Errorf("Expected the filter to be empty, got %d keys", stats.Count)
+		}
+	})
+
+	t.Run("it reports a full filter", func(t *testing.T) {
+		doHTTP(t, "PUT", url+"/filters/small", CreateRequest{ErrRate: 0.01, Capacity: 20}, http.StatusCreated, nil)
+		keys := make([]string, 21)
+		for i := range keys {
+			keys[i] = strings.Repeat("k", i+1)
+		}
+		doHTTP(t, "POST", url+"/filters/small/add", KeysRequest{Keys: keys}, http.StatusInsufficientStorage, nil)
+	})
+
+	t.Run("it rejects unknown routes", func(t *testing.T) {
+		doHTTP(t, "GET", url+"/foo", nil, http.StatusNotFound, nil)
+		doHTTP(t, "GET", url+"/filters/users/foo/bar/baz", nil, http.StatusNotFound, nil)
+		doHTTP(t, "DELETE", url+"/filters/users", nil, http.StatusMethodNotAllowed, nil)
+	})
+}
+
+func TestHandler_NoStore(t *testing.T) {
+	srv := httptest.NewServer(NewHandler(newTestRegistry(t, t.TempDir())))
+	defer srv.Close()
+
+	doHTTP(t, "PUT", srv.URL+"/filters/users/values/alice", []byte("foo"), http.StatusBadRequest, nil)
+}
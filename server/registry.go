@@ -0,0 +1,287 @@
+// Package server hosts named sprout filters behind network protocols,
+// so that applications that cannot link sprout can share its mmap-backed filters.
+//
+// The filters are held by a Registry, which stores each filter in its own file in a directory.
+// RESPServer serves the registry to Redis clients, with the commands of RedisBloom.
+// NewHandler serves it over HTTP, as JSON.
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/dsa0x/sprout"
+)
+
+var (
+	// ErrNotFound is returned when a filter does not exist
+	ErrNotFound = errors.New("not found")
+
+	// ErrExists is returned when a filter is reserved with the name of an existing filter
+	ErrExists = errors.New("item exists")
+
+	// ErrRegistryClosed is returned when a filter is requested from a closed registry
+	ErrRegistryClosed = errors.New("registry is closed")
+)
+
+// Filter is a filter held by the registry, a *sprout.BloomFilter or a *sprout.ScalableBloomFilter
+type Filter interface {
+	sprout.Filter
+
+	// AddIfAbsent adds the key to the filter, added is false if the key was probably already in the filter
+	AddIfAbsent(key []byte) (added bool, err error)
+
+	// AddBatch adds the keys to the filter
+	AddBatch(keys [][]byte) error
+
+	// ContainsBatch checks if each of the keys may be in the filter
+	ContainsBatch(keys [][]byte) []bool
+
+	// Put adds the key to the filter and stores its value in the persistent store of the filter
+	Put(key, val []byte) error
+
+	// GetE returns the value of the key from the persistent store of the filter, or nil if it is not found
+	GetE(key []byte) ([]byte, error)
+
+	// Delete removes the key from the persistent store of the filter
+	Delete(key []byte) error
+
+	// ClearE removes all the keys from the filter
+	ClearE() error
+}
+
+// DefaultOptions is the options of the filters created when a key is added to a filter that does not exist,
+// the defaults of RedisBloom
+var DefaultOptions = sprout.BloomOptions{
+	Err_rate:   0.01,
+	Capacity:   100,
+	GrowthRate: sprout.GrowthSmall,
+}
+
+// DefaultMaxCapacity is the default maximum capacity of the filters reserved in a registry.
+// A filter of this capacity with an error rate of 0.01 takes about 120 MB.
+const DefaultMaxCapacity = 100 * 1000 * 1000
+
+// DefaultMaxGrowthRate is the default maximum growth rate of the scalable filters reserved in a registry
+var DefaultMaxGrowthRate = sprout.GrowthLarge
+
+// Registry holds named filters, each stored in its own file in a directory.
+// Filters are opened from the directory when they are first used, and stay open until the registry is closed.
+// It is safe for concurrent use.
+type Registry struct {
+	dir string
+
+	// defaults is the options of the scalable filters created by GetOrCreate
+	defaults sprout.BloomOptions
+
+	// NewStore opens the persistent store of the filter stored in the file at path,
+	// which keeps the values put in the filter. The store is closed with the registry.
+	// If nil, the filters have no store, and putting values fails with sprout.ErrNoStore.
+	// It must be set before the registry is used.
+	NewStore func(path string) (sprout.Store, error)
+
+	// MaxCapacity and MaxGrowthRate bound the capacity and the growth rate of the filters reserved with Reserve,
+	// which come from the clients of the servers. They default to DefaultMaxCapacity and DefaultMaxGrowthRate,
+	// and must be set before the registry is used.
+	MaxCapacity   int
+	MaxGrowthRate sprout.GrowthRate
+
+	lock    sync.Mutex
+	filters map[string]Filter
+	stores  []sprout.Store
+	closed  bool
+}
+
+// NewRegistry creates a registry of the filters stored in dir, creating dir if needed.
+//
+// defaults is the options of the filters created when a key is added to a filter that does not exist.
+// If nil, DefaultOptions is used.
+func NewRegistry(dir string, defaults *sprout.BloomOptions) (*Registry, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("unable to create filter directory: %w", err)
+	}
+	if defaults == nil {
+		defaults = &DefaultOptions
+	}
+	return &Registry{
+		dir:           dir,
+		defaults:      *defaults,
+		MaxCapacity:   DefaultMaxCapacity,
+		MaxGrowthRate: DefaultMaxGrowthRate,
+		filters:       make(map[string]Filter),
+	}, nil
+}
+
+// path returns the path of the file of the filter.
+// Names are escaped, so that they cannot refer to a file outside the directory.
+func (r *Registry) path(name string) string {
+	return filepath.Join(r.dir, url.PathEscape(name)+".db")
+}
+
+// Get returns the filter with the given name, or ErrNotFound if it does not exist
+func (r *Registry) Get(name string) (Filter, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return r.get(name)
+}
+
+func (r *Registry) get(name string) (Filter, error) {
+	if r.closed {
+		return nil, ErrRegistryClosed
+	}
+	if f, ok := r.filters[name]; ok {
+		return f, nil
+	}
+
+	path := r.path(name)
+	if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+
+	store, err := r.openStore(path)
+	if err != nil {
+		return nil, err
+	}
+	f, err := openFilter(path, store)
+	if err != nil {
+		r.closeStore(store)
+		return nil, err
+	}
+	r.filters[name] = f
+	return f, nil
+}
+
+// openFilter opens the bloom filter or scalable bloom filter stored in the file at path
+func openFilter(path string, store sprout.Store) (Filter, error) {
+	var stores []sprout.Store
+	if store != nil {
+		stores = append(stores, store)
+	}
+	bf, err := sprout.OpenBloom(path, stores...)
+	if err == nil {
+		return bf, nil
+	}
+	if !errors.Is(err, sprout.ErrInvalidHeader) {
+		return nil, err
+	}
+	return sprout.OpenScalableBloom(path, stores...)
+}
+
+// openStore opens the store of the filter stored at path, and records it to close it with the registry.
+// It returns a nil store if the registry has no NewStore.
+func (r *Registry) openStore(path string) (sprout.Store, error) {
+	if r.NewStore == nil {
+		return nil, nil
+	}
+	store, err := r.NewStore(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open the store of %s: %w", path, err)
+	}
+	r.stores = append(r.stores, store)
+	return store, nil
+}
+
+// closeStore closes a store of a filter that could not be opened
+func (r *Registry) closeStore(store sprout.Store) {
+	if store == nil {
+		return
+	}
+	store.Close()
+	r.stores = r.stores[:len(r.stores)-1]
+}
+
+// GetOrCreate returns the filter with the given name,
+// creating a scalable filter with the default options of the registry if it does not exist
+func (r *Registry) GetOrCreate(name string) (Filter, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	f, err := r.get(name)
+	if !errors.Is(err, ErrNotFound) {
+		return f, err
+	}
+	opts := r.defaults
+	return r.create(name, &opts, true)
+}
+
+// Reserve creates a filter with the given name and options, or returns ErrExists if it already exists.
+// The filter is a scalable bloom filter if scalable is true, and a bloom filter otherwise.
+// opts.Path is ignored, the filter is stored in the directory of the registry.
+// A capacity or a growth rate above the maximum of the registry fails with sprout.ErrInvalidOptions.
+func (r *Registry) Reserve(name string, opts *sprout.BloomOptions, scalable bool) (Filter, error) {
+	if opts.Capacity > r.MaxCapacity {
+		return nil, fmt.Errorf("%w: capacity must be at most %d", sprout.ErrInvalidOptions, r.MaxCapacity)
+	}
+	if scalable && opts.GrowthRate > r.MaxGrowthRate {
+		return nil, fmt.Errorf("%w: growth rate must be at most %d", sprout.ErrInvalidOptions, r.MaxGrowthRate)
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	_, err := r.get(name)
+	if err == nil {
+		return nil, ErrExists
+	}
+	if !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+	o := *opts
+	return r.create(name, &o, scalable)
+}
+
+func (r *Registry) create(name string, opts *sprout.BloomOptions, scalable bool) (Filter, error) {
+	opts.Path = r.path(name)
+	store, err := r.openStore(opts.Path)
+	if err != nil {
+		return nil, err
+	}
+	opts.Database = store
+
+	var f Filter
+	if scalable {
+		f, err = sprout.NewScalableBloomE(opts)
+	} else {
+		f, err = sprout.NewBloomE(opts)
+	}
+	if err != nil {
+		// do not leave an empty file behind for a filter that could not be created
+		os.Remove(opts.Path)
+		r.closeStore(store)
+		return nil, err
+	}
+	r.filters[name] = f
+	return f, nil
+}
+
+// Close closes all the open filters and their stores. The filters cannot be used after the registry is closed.
+func (r *Registry) Close() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+
+	var firstErr error
+	for name, f := range r.filters {
+		if err := f.Close(); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("unable to close filter %s: %w", name, err)
+		}
+	}
+	for _, store := range r.stores {
+		if err := store.Close(); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("unable to close store: %w", err)
+		}
+	}
+	r.filters = nil
+	r.stores = nil
+	return firstErr
+}
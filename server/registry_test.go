@@ -0,0 +1,81 @@
+package server
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dsa0x/sprout"
+)
+
+func TestRegistry(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "filters")
+	reg := newTestRegistry(t, dir)
+
+	t.Run("it returns ErrNotFound for a missing filter", func(t *testing.T) {
+		if _, err := reg.Get("foo"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Expected ErrNotFound, got %v", err)
+		}
+	})
+
+	t.Run("it keeps the filters in the directory", func(t *testing.T) {
+		f, err := reg.GetOrCreate("../foo/bar")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if _, ok := f.(*sprout.ScalableBloomFilter); !ok {
+			t.Errorf("Expected a scalable bloom filter, got %T", f)
+		}
+		files, _ := os.ReadDir(dir)
+		if len(files) != 1 || files[0].Name() != "..%2Ffoo%2Fbar.db" {
+			t.Errorf("Expected the filter to be stored in the directory, got %v", files)
+		}
+	})
+
+	t.Run("it does not create invalid filters", func(t *testing.T) {
+		_, err := reg.Reserve("small", &sprout.BloomOptions{Err_rate: 0.01, Capacity: 5}, false)
+		if !errors.Is(err, sprout.ErrInvalidOptions) {
+			t.Errorf("Expected ErrInvalidOptions, got %v", err)
+		}
+		if _, err := reg.Get("small"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Expected ErrNotFound, got %v", err)
+		}
+	})
+
+	t.Run("it bounds the capacity and growth rate of the filters", func(t *testing.T) {
+		_, err := reg.Reserve("huge", &sprout.BloomOptions{Err_rate: 0.01, Capacity: 1 << 40}, false)
+		if !errors.Is(err, sprout.ErrInvalidOptions) {
+			t.Errorf("Expected ErrInvalidOptions for a capacity of 1<<40, got %v", err)
+		}
+		_, err = reg.Reserve("fast", &sprout.BloomOptions{Err_rate: 0.01, Capacity: 100, GrowthRate: 1 << 20}, true)
+		if !errors.Is(err, sprout.ErrInvalidOptions) {
+			t.Errorf("Expected ErrInvalidOptions for a growth rate of 1<<20, got %v", err)
+		}
+		if files, _ := filepath.Glob(filepath.Join(dir, "huge*")); len(files) != 0 {
+			t.Errorf("Expected no file to be created, got %v", files)
+		}
+	})
+
+	t.Run("it reopens the filters after it is closed", func(t *testing.T) {
+		if _, err := reg.Reserve("bloom", &sprout.BloomOptions{Err_rate: 0.01, Capacity: 100}, false); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		reg.Close()
+		if _, err := reg.Get("bloom"); !errors.Is(err, ErrRegistryClosed) {
+			t.Errorf("Expected ErrRegistryClosed, got %v", err)
+		}
+
+		reg := newTestRegistry(t, dir)
+		f, err := reg.Get("bloom")
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if _, ok := f.(*sprout.BloomFilter); !ok {
+			t.Errorf("Expected a bloom filter, got %T", f)
+		}
+		if _, err := reg.Reserve("bloom", &sprout.BloomOptions{Err_rate: 0.01, Capacity: 100}, true); !errors.Is(err, ErrExists) {
+			t.Errorf("Expected ErrExists, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,673 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/dsa0x/sprout"
+)
+
+// The clients of the server are not authenticated, so the limits of a command are far below the ones of Redis.
+// The arguments are allocated as they are received, and a client has to send the data it makes the server allocate.
+const (
+	// maxArgs bounds the number of arguments of a command read from a client
+	maxArgs = 64 * 1024
+
+	// maxBulkLen bounds the size of an argument, like the proto-max-bulk-len of Redis
+	maxBulkLen = 1024 * 1024
+
+	// maxRequestLen bounds the total size of the arguments of a command
+	maxRequestLen = 16 * 1024 * 1024
+
+	// maxInlineLen bounds the size of an inline command
+	maxInlineLen = 64 * 1024
+)
+
+// ErrServerClosed is returned by Serve and ListenAndServe after the server is closed
+var ErrServerClosed = errors.New("server closed")
+
+// errProtocol is returned when a client does not speak RESP, the connection is then closed
+var errProtocol = errors.New("Protocol error")
+
+// RESPServer serves the filters of a registry to Redis clients, over RESP2 or RESP3.
+//
+// It implements the BF.ADD, BF.MADD, BF.EXISTS, BF.MEXISTS, BF.RESERVE and BF.INFO commands of RedisBloom,
+// and the PING, ECHO, HELLO, QUIT and COMMAND commands clients send on their own.
+// BF.ADD and BF.MADD create a scalable filter with the default options of the registry if the filter does not exist.
+// Clients switch to RESP3 with HELLO 3, booleans are then returned as booleans instead of integers.
+type RESPServer struct {
+	reg *Registry
+
+	lock      sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	closed    bool
+	wg        sync.WaitGroup
+
+	// nextID is the id of the next connection, reported by HELLO
+	nextID int64
+}
+
+// NewRESPServer creates a server of the filters of the registry.
+// The registry is not closed with the server.
+func NewRESPServer(reg *Registry) *RESPServer {
+	return &RESPServer{
+		reg:       reg,
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[net.Conn]struct{}),
+	}
+}
+
+// ListenAndServe listens on the TCP address addr and serves the clients that connect to it,
+// see Serve.
+func (s *RESPServer) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve accepts the connections on the listener and serves each client in its own goroutine.
+// It blocks until the listener fails or the server is closed, and always returns an error,
+// ErrServerClosed after Close.
+func (s *RESPServer) Serve(l net.Listener) error {
+	if !s.track(l, nil) {
+		l.Close()
+		return ErrServerClosed
+	}
+	defer s.untrack(l, nil)
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if s.isClosed() {
+				return ErrServerClosed
+			}
+			return err
+		}
+		if !s.track(nil, conn) {
+			conn.Close()
+			return ErrServerClosed
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer s.untrack(nil, conn)
+			s.serveConn(conn)
+		}()
+	}
+}
+
+// track records the listener or connection, so that it is closed with the server.
+// It returns false if the server is already closed.
+func (s *RESPServer) track(l net.Listener, conn net.Conn) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.closed {
+		return false
+	}
+	if l != nil {
+		s.listeners[l] = struct{}{}
+	}
+	if conn != nil {
+		s.conns[conn] = struct{}{}
+	}
+	return true
+}
+
+func (s *RESPServer) untrack(l net.Listener, conn net.Conn) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	delete(s.listeners, l)
+	delete(s.conns, conn)
+}
+
+func (s *RESPServer) isClosed() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.closed
+}
+
+// Close closes the listeners and the connections of the server,
+// and waits for the commands being run to complete.
+func (s *RESPServer) Close() error {
+	s.lock.Lock()
+	s.closed = true
+	var firstErr error
+	for l := range s.listeners {
+		if err := l.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.lock.Unlock()
+
+	s.wg.Wait()
+	return firstErr
+}
+
+// respConn is the state of a client connection
+type respConn struct {
+	r  *bufio.Reader
+	w  *respWriter
+	id int64
+}
+
+func (s *RESPServer) serveConn(conn net.Conn) {
+	defer conn.Close()
+
+	c := &respConn{
+		r:  bufio.NewReader(conn),
+		w:  &respWriter{Writer: bufio.NewWriter(conn), proto: 2},
+		id: atomic.AddInt64(&s.nextID, 1),
+	}
+	for {
+		args, err := readCommand(c.r)
+		if err != nil {
+			if errors.Is(err, errProtocol) {
+				c.w.writeError("ERR " + err.Error())
+				c.w.Flush()
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+
+		quit := s.dispatch(c, args)
+
+		// pipelined commands are replied to at once
+		if c.r.Buffered() == 0 || quit {
+			if err := c.w.Flush(); err != nil {
+				return
+			}
+		}
+		if quit {
+			return
+		}
+	}
+}
+
+// dispatch runs the command and writes its reply. It returns true if the connection must be closed.
+func (s *RESPServer) dispatch(c *respConn, args [][]byte) (quit bool) {
+	name := strings.ToLower(string(args[0]))
+	cmd, ok := respCommands[name]
+	if !ok {
+		c.w.writeError(fmt.Sprintf("ERR unknown command '%s'", args[0]))
+		return false
+	}
+	if len(args) < cmd.minArgs || (cmd.maxArgs > 0 && len(args) > cmd.maxArgs) {
+		c.w.writeError(fmt.Sprintf("ERR wrong number of arguments for '%s' command", name))
+		return false
+	}
+	if name == "quit" {
+		c.w.writeSimple("OK")
+		return true
+	}
+	cmd.fn(s, c, args[1:])
+	return false
+}
+
+// respCommand is a command of the server, with its number of arguments including the command name.
+// maxArgs is 0 for commands with any number of arguments.
+type respCommand struct {
+	minArgs int
+	maxArgs int
+	fn      func(s *RESPServer, c *respConn, args [][]byte)
+}
+
+var respCommands = map[string]respCommand{
+	"ping":       {1, 2, (*RESPServer).ping},
+	"echo":       {2, 2, (*RESPServer).echo},
+	"hello":      {1, 0, (*RESPServer).hello},
+	"quit":       {1, 1, nil},
+	"command":    {1, 0, (*RESPServer).command},
+	"bf.add":     {3, 3, (*RESPServer).bfAdd},
+	"bf.madd":    {3, 0, (*RESPServer).bfMAdd},
+	"bf.exists":  {3, 3, (*RESPServer).bfExists},
+	"bf.mexists": {3, 0, (*RESPServer).bfMExists},
+	"bf.reserve": {4, 7, (*RESPServer).bfReserve},
+	"bf.info":    {2, 3, (*RESPServer).bfInfo},
+}
+
+func (s *RESPServer) ping(c *respConn, args [][]byte) {
+	if len(args) == 0 {
+		c.w.writeSimple("PONG")
+		return
+	}
+	c.w.writeBulk(args[0])
+}
+
+func (s *RESPServer) echo(c *respConn, args [][]byte) {
+	c.w.writeBulk(args[0])
+}
+
+// hello switches the protocol of the connection: HELLO [protover [AUTH username password] [SETNAME name]].
+// The server has no authentication, AUTH and SETNAME are accepted and ignored.
+func (s *RESPServer) hello(c *respConn, args [][]byte) {
+	if len(args) > 0 {
+		proto, err := strconv.Atoi(string(args[0]))
+		if err != nil {
+			c.w.writeError("ERR Protocol version is not an integer or out of range")
+			return
+		}
+		if proto != 2 && proto != 3 {
+			c.w.writeError("NOPROTO unsupported protocol version")
+			return
+		}
+		c.w.proto = proto
+	}
+
+	c.w.writeMapLen(7)
+	c.w.writeBulkString("server")
+	c.w.writeBulkString("sprout")
+	c.w.writeBulkString("version")
+	c.w.writeBulkString("1.0.0")
+	c.w.writeBulkString("proto")
+	c.w.writeInt(int64(c.w.proto))
+	c.w.writeBulkString("id")
+	c.w.writeInt(c.id)
+	c.w.writeBulkString("mode")
+	c.w.writeBulkString("standalone")
+	c.w.writeBulkString("role")
+	c.w.writeBulkString("master")
+	c.w.writeBulkString("modules")
+	c.w.writeArrayLen(0)
+}
+
+// command replies to the COMMAND requests of clients with an empty list of commands
+func (s *RESPServer) command(c *respConn, args [][]byte) {
+	c.w.writeArrayLen(0)
+}
+
+// bfAdd adds an item to a filter: BF.ADD key item.
+// It replies true if the item was added, and false if it may already be in the filter.
+func (s *RESPServer) bfAdd(c *respConn, args [][]byte) {
+	f, err := s.reg.GetOrCreate(string(args[0]))
+	if err != nil {
+		c.w.writeErr(err)
+		return
+	}
+	added, err := f.AddIfAbsent(args[1])
+	if err != nil {
+		c.w.writeErr(err)
+		return
+	}
+	c.w.writeBool(added)
+}
+
+// bfMAdd adds items to a filter: BF.MADD key item [item ...].
+// It replies an array with the reply of BF.ADD for each item, or the error adding the item.
+func (s *RESPServer) bfMAdd(c *respConn, args [][]byte) {
+	f, err := s.reg.GetOrCreate(string(args[0]))
+	if err != nil {
+		c.w.writeErr(err)
+		return
+	}
+	items := args[1:]
+	c.w.writeArrayLen(len(items))
+	for _, item := range items {
+		added, err := f.AddIfAbsent(item)
+		if err != nil {
+			c.w.writeErr(err)
+			continue
+		}
+		c.w.writeBool(added)
+	}
+}
+
+// bfExists checks if an item may be in a filter: BF.EXISTS key item.
+// It replies false if the filter does not exist.
+func (s *RESPServer) bfExists(c *respConn, args [][]byte) {
+	f, err := s.reg.Get(string(args[0]))
+	if errors.Is(err, ErrNotFound) {
+		c.w.writeBool(false)
+		return
+	}
+	if err != nil {
+		c.w.writeErr(err)
+		return
+	}
+	c.w.writeBool(f.Contains(args[1]))
+}
+
+// bfMExists checks if items may be in a filter: BF.MEXISTS key item [item ...]
+func (s *RESPServer) bfMExists(c *respConn, args [][]byte) {
+	items := args[1:]
+	f, err := s.reg.Get(string(args[0]))
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		c.w.writeErr(err)
+		return
+	}
+
+	c.w.writeArrayLen(len(items))
+	if f == nil {
+		for range items {
+			c.w.writeBool(false)
+		}
+		return
+	}
+	for _, found := range f.ContainsBatch(items) {
+		c.w.writeBool(found)
+	}
+}
+
+// bfReserve creates a filter: BF.RESERVE key error_rate capacity [EXPANSION expansion] [NONSCALING].
+// The filter is a scalable bloom filter, or a bloom filter with NONSCALING.
+// A bloom filter reserved for 10 keys or fewer is sized, and reports its capacity, as a filter of 11 keys.
+func (s *RESPServer) bfReserve(c *respConn, args [][]byte) {
+	errRate, err := strconv.ParseFloat(string(args[1]), 64)
+	if err != nil || errRate <= 0 || errRate >= 1 {
+		c.w.writeError("ERR bad error rate")
+		return
+	}
+	capacity, err := strconv.Atoi(string(args[2]))
+	if err != nil || capacity <= 0 {
+		c.w.writeError("ERR bad capacity")
+		return
+	}
+
+	opts := &sprout.BloomOptions{
+		Err_rate:   errRate,
+		Capacity:   capacity,
+		GrowthRate: s.reg.defaults.GrowthRate,
+	}
+	scalable := true
+	expansion := false
+	for i := 3; i < len(args); i++ {
+		switch strings.ToLower(string(args[i])) {
+		case "nonscaling":
+			scalable = false
+		case "expansion":
+			i++
+			if i == len(args) {
+				c.w.writeError("ERR no expansion")
+				return
+			}
+			growth, err := strconv.Atoi(string(args[i]))
+			if err != nil || growth <= 0 {
+				c.w.writeError("ERR bad expansion")
+				return
+			}
+			opts.GrowthRate = sprout.GrowthRate(growth)
+			expansion = true
+		default:
+			c.w.writeError("ERR syntax error")
+			return
+		}
+	}
+	if expansion && !scalable {
+		c.w.writeError("ERR Non scaling filters cannot expand")
+		return
+	}
+	// RedisBloom accepts any capacity, but a bloom filter needs a capacity greater than 10
+	if !scalable && opts.Capacity <= 10 {
+		opts.Capacity = 11
+	}
+
+	if _, err := s.reg.Reserve(string(args[0]), opts, scalable); err != nil {
+		c.w.writeErr(err)
+		return
+	}
+	c.w.writeSimple("OK")
+}
+
+// infoFields is the fields of BF.INFO, with the names of the arguments selecting a single field
+var infoFields = []struct {
+	name string
+	arg  string
+}{
+	{"Capacity", "capacity"},
+	{"Size", "size"},
+	{"Number of filters", "filters"},
+	{"Number of items inserted", "items"},
+	{"Expansion rate", "expansion"},
+}
+
+// bfInfo replies the information of a filter: BF.INFO key [CAPACITY | SIZE | FILTERS | ITEMS | EXPANSION].
+// The expansion rate of a bloom filter is null.
+func (s *RESPServer) bfInfo(c *respConn, args [][]byte) {
+	f, err := s.reg.Get(string(args[0]))
+	if err != nil {
+		c.w.writeErr(err)
+		return
+	}
+
+	values := filterInfo(f)
+	if len(args) == 2 {
+		arg := strings.ToLower(string(args[1]))
+		for i, field := range infoFields {
+			if field.arg == arg {
+				c.w.writeArrayLen(1)
+				c.w.writeIntOrNull(values[i])
+				return
+			}
+		}
+		c.w.writeError("ERR invalid information value")
+		return
+	}
+
+	c.w.writeMapLen(len(infoFields))
+	for i, field := range infoFields {
+		c.w.writeSimple(field.name)
+		c.w.writeIntOrNull(values[i])
+	}
+}
+
+// filterInfo returns the values of the infoFields of the filter, -1 for a null value
+func filterInfo(f Filter) []int64 {
+	filters, expansion := int64(1), int64(-1)
+	size := 0
+	switch f := f.(type) {
+	case *sprout.ScalableBloomFilter:
+		filters = int64(f.NumFilters())
+		expansion = int64(f.GrowthRate())
+		size = f.FilterSize()
+	case *sprout.BloomFilter:
+		size = f.FilterSize()
+	}
+	return []int64{int64(f.Capacity()), int64(size), filters, int64(f.Count()), expansion}
+}
+
+// readCommand reads a command sent as an array of bulk strings, or as an inline command
+func readCommand(r *bufio.Reader) ([][]byte, error) {
+	b, err := r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	if b[0] != '*' {
+		line, err := readLine(r, maxInlineLen)
+		if err != nil {
+			return nil, err
+		}
+		return bytes.Fields(line), nil
+	}
+
+	line, err := readLine(r, maxInlineLen)
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(string(line[1:]))
+	if err != nil || n > maxArgs {
+		return nil, fmt.Errorf("%w: invalid multibulk length", errProtocol)
+	}
+	if n <= 0 {
+		return nil, nil
+	}
+
+	// the arguments are appended as they are read, rather than allocated from the length sent
+	var args [][]byte
+	total := 0
+	for i := 0; i < n; i++ {
+		line, err := readLine(r, maxInlineLen)
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 || line[0] != '$' {
+			return nil, fmt.Errorf("%w: expected '$', got '%.1s'", errProtocol, line)
+		}
+		size, err := strconv.Atoi(string(line[1:]))
+		if err != nil || size < 0 || size > maxBulkLen {
+			return nil, fmt.Errorf("%w: invalid bulk length", errProtocol)
+		}
+		if total += size; total > maxRequestLen {
+			return nil, fmt.Errorf("%w: too big request", errProtocol)
+		}
+		arg, err := readBulk(r, size)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	return args, nil
+}
+
+// readBulk reads a bulk string of size bytes followed by CRLF.
+// The buffer grows as the string is received, so that the length alone does not allocate it.
+func readBulk(r *bufio.Reader, size int) ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := io.CopyN(&buf, r, int64(size)+2); err != nil {
+		return nil, err
+	}
+	arg := buf.Bytes()
+	if arg[size] != '\r' || arg[size+1] != '\n' {
+		return nil, fmt.Errorf("%w: expected CRLF after bulk string", errProtocol)
+	}
+	return arg[:size], nil
+}
+
+// readLine reads a line terminated by CRLF or LF, without its terminator
+func readLine(r *bufio.Reader, max int) ([]byte, error) {
+	var line []byte
+	for {
+		chunk, err := r.ReadSlice('\n')
+		line = append(line, chunk...)
+		if len(line) > max {
+			return nil, fmt.Errorf("%w: too big request", errProtocol)
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		line = line[:len(line)-1]
+		if len(line) > 0 && line[len(line)-1] == '\r' {
+			line = line[:len(line)-1]
+		}
+		return line, nil
+	}
+}
+
+// respWriter writes the replies of a connection in the protocol version selected by the client
+type respWriter struct {
+	*bufio.Writer
+	proto int
+}
+
+func (w *respWriter) writeSimple(s string) {
+	w.WriteByte('+')
+	w.WriteString(s)
+	w.WriteString("\r\n")
+}
+
+func (w *respWriter) writeError(msg string) {
+	// an error is a single line
+	msg = strings.NewReplacer("\r", " ", "\n", " ").Replace(msg)
+	w.WriteByte('-')
+	w.WriteString(msg)
+	w.WriteString("\r\n")
+}
+
+// writeErr writes the error of a command, with the message of RedisBloom for a full filter
+func (w *respWriter) writeErr(err error) {
+	if errors.Is(err, sprout.ErrCapacityReached) {
+		w.writeError("ERR non scaling filter is full")
+		return
+	}
+	w.writeError("ERR " + err.Error())
+}
+
+func (w *respWriter) writeInt(n int64) {
+	w.WriteByte(':')
+	w.WriteString(strconv.FormatInt(n, 10))
+	w.WriteString("\r\n")
+}
+
+// writeIntOrNull writes n, or null if n is negative
+func (w *respWriter) writeIntOrNull(n int64) {
+	if n < 0 {
+		w.writeNull()
+		return
+	}
+	w.writeInt(n)
+}
+
+func (w *respWriter) writeBulk(b []byte) {
+	w.WriteByte('$')
+	w.WriteString(strconv.Itoa(len(b)))
+	w.WriteString("\r\n")
+	w.Write(b)
+	w.WriteString("\r\n")
+}
+
+func (w *respWriter) writeBulkString(s string) {
+	w.WriteByte('$')
+	w.WriteString(strconv.Itoa(len(s)))
+	w.WriteString("\r\n")
+	w.WriteString(s)
+	w.WriteString("\r\n")
+}
+
+func (w *respWriter) writeNull() {
+	if w.proto == 3 {
+		w.WriteString("_\r\n")
+		return
+	}
+	w.WriteString("$-1\r\n")
+}
+
+// writeBool writes a boolean, as the integer 1 or 0 in RESP2
+func (w *respWriter) writeBool(b bool) {
+	switch {
+	case w.proto == 3 && b:
+		w.WriteString("#t\r\n")
+	case w.proto == 3:
+		w.WriteString("#f\r\n")
+	case b:
+		w.WriteString(":1\r\n")
+	default:
+		w.WriteString(":0\r\n")
+	}
+}
+
+func (w *respWriter) writeArrayLen(n int) {
+	w.WriteByte('*')
+	w.WriteString(strconv.Itoa(n))
+	w.WriteString("\r\n")
+}
+
+// writeMapLen writes the header of a map of n pairs, as an array of 2n elements in RESP2
+func (w *respWriter) writeMapLen(n int) {
+	if w.proto == 3 {
+		w.WriteByte('%')
+		w.WriteString(strconv.Itoa(n))
+		w.WriteString("\r\n")
+		return
+	}
+	w.writeArrayLen(2 * n)
+}
@@ -0,0 +1,314 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// respError is an error reply
+type respError string
+
+// respClient is a minimal Redis client for the tests
+type respClient struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func dialRESP(t *testing.T, addr string) *respClient {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &respClient{conn: conn, r: bufio.NewReader(conn)}
+}
+
+// send writes the commands without reading their replies
+func (c *respClient) send(t *testing.T, cmds ...[]string) {
+	t.Helper()
+	var buf []byte
+	for _, args := range cmds {
+		buf = append(buf, fmt.Sprintf("*%d\r\n", len(args))...)
+		for _, arg := range args {
+			buf = append(buf, fmt.Sprintf("$%d\r\n%s\r\n", len(arg), arg)...)
+		}
+	}
+	if _, err := c.conn.Write(buf); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+}
+
+func (c *respClient) do(t *testing.T, args ...string) interface{} {
+	t.Helper()
+	c.send(t, args)
+	return c.read(t)
+}
+
+func (c *respClient) read(t *testing.T) interface{} {
+	t.Helper()
+	reply, err := readReply(c.r)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	return reply
+}
+
+// readReply reads a reply: simple strings and bulk strings are returned as strings,
+// arrays and maps as []interface{}, and null as nil
+func readReply(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 {
+		return nil, fmt.Errorf("invalid reply %q", line)
+	}
+	kind, line := line[0], line[1:len(line)-2]
+	switch kind {
+	case '+':
+		return line, nil
+	case '-':
+		return respError(line), nil
+	case ':':
+		return strconv.ParseInt(line, 10, 64)
+	case '#':
+		return line == "t", nil
+	case '_':
+		return nil, nil
+	case '$':
+		n, _ := strconv.Atoi(line)
+		if n < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*', '%':
+		n, _ := strconv.Atoi(line)
+		if n < 0 {
+			return nil, nil
+		}
+		if kind == '%' {
+			n *= 2
+		}
+		elems := make([]interface{}, n)
+		for i := range elems {
+			if elems[i], err = readReply(r); err != nil {
+				return nil, err
+			}
+		}
+		return elems, nil
+	}
+	return nil, fmt.Errorf("invalid reply %q", line)
+}
+
+// startRESP serves the registry on a local listener
+func startRESP(t *testing.T, reg *Registry) (*RESPServer, string) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	s := NewRESPServer(reg)
+	done := make(chan error, 1)
+	go func() { done <- s.Serve(l) }()
+	t.Cleanup(func() {
+		s.Close()
+		if err := <-done; err != ErrServerClosed {
+			t.Errorf("Expected ErrServerClosed, got %v", err)
+		}
+	})
+	return s, l.Addr().String()
+}
+
+func newTestRegistry(t *testing.T, dir string) *Registry {
+	t.Helper()
+	reg, err := NewRegistry(dir, nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	t.Cleanup(func() { reg.Close() })
+	return reg
+}
+
+func expectReply(t *testing.T, c *respClient, want interface{}, args ...string) {
+	t.Helper()
+	if got := c.do(t, args...); !reflect.DeepEqual(got, want) {
+		t.Errorf("%v: expected %#v, got %#v", args, want, got)
+	}
+}
+
+func TestRESPServer(t *testing.T) {
+	dir := t.TempDir()
+	_, addr := startRESP(t, newTestRegistry(t, dir))
+	c := dialRESP(t, addr)
+
+	t.Run("it answers the commands of clients", func(t *testing.T) {
+		expectReply(t, c, "PONG", "PING")
+		expectReply(t, c, "foo", "PING", "foo")
+		expectReply(t, c, "foo", "ECHO", "foo")
+		expectReply(t, c, []interface{}{}, "COMMAND", "DOCS")
+		expectReply(t, c, respError("ERR unknown command 'SET'"), "SET", "foo", "bar")
+		expectReply(t, c, respError("ERR wrong number of arguments for 'bf.add' command"), "BF.ADD", "f")
+	})
+
+	t.Run("it adds items to a new scalable filter", func(t *testing.T) {
+		expectReply(t, c, int64(1), "BF.ADD", "f", "foo")
+		expectReply(t, c, int64(0), "bf.add", "f", "foo")
+		expectReply(t, c, int64(1), "BF.EXISTS", "f", "foo")
+		expectReply(t, c, int64(0), "BF.EXISTS", "f", "bar")
+		expectReply(t, c, int64(0), "BF.EXISTS", "missing", "foo")
+		expectReply(t, c, []interface{}{int64(1), int64(1), int64(0)}, "BF.MADD", "f", "a", "b", "foo")
+		expectReply(t, c, []interface{}{int64(1), int64(0)}, "BF.MEXISTS", "f", "a", "c")
+		expectReply(t, c, []interface{}{int64(0), int64(0)}, "BF.MEXISTS", "missing", "a", "c")
+
+		expectReply(t, c, []interface{}{
+			"Capacity", int64(DefaultOptions.Capacity),
+			"Size", c.do(t, "BF.INFO", "f", "SIZE").([]interface{})[0],
+			"Number of filters", int64(1),
+			"Number of items inserted", int64(3),
+			"Expansion rate", int64(2),
+		}, "BF.INFO", "f")
+		expectReply(t, c, []interface{}{int64(3)}, "BF.INFO", "f", "ITEMS")
+		expectReply(t, c, respError("ERR not found"), "BF.INFO", "missing")
+	})
+
+	t.Run("it grows a scalable filter", func(t *testing.T) {
+		for i := 0; i < 2*DefaultOptions.Capacity; i++ {
+			c.send(t, []string{"BF.ADD", "grow", strconv.Itoa(i)})
+		}
+		for i := 0; i < 2*DefaultOptions.Capacity; i++ {
+			if reply := c.read(t); reply != int64(1) && reply != int64(0) {
+				t.Fatalf("Expected BF.ADD to reply 0 or 1, got %#v", reply)
+			}
+		}
+		info := c.do(t, "BF.INFO", "grow", "FILTERS").([]interface{})
+		if info[0].(int64) < 2 {
+			t.Errorf("Expected the filter to grow, got %d filters", info[0])
+		}
+	})
+
+	t.Run("it reserves filters", func(t *testing.T) {
+		expectReply(t, c, "OK", "BF.RESERVE", "r", "0.01", "20", "NONSCALING")
+		expectReply(t, c, "OK", "BF.RESERVE", "s", "0.001", "1000", "EXPANSION", "4")
+		expectReply(t, c, "OK", "BF.RESERVE", "t", "0.01", "1", "NONSCALING")
+		expectReply(t, c, respError("ERR item exists"), "BF.RESERVE", "r", "0.01", "20")
+		expectReply(t, c, respError("ERR item exists"), "BF.RESERVE", "f", "0.01", "20")
+		expectReply(t, c, respError("ERR bad error rate"), "BF.RESERVE", "e", "2", "100")
+		expectReply(t, c, respError("ERR bad capacity"), "BF.RESERVE", "e", "0.01", "-1")
+		expectReply(t, c, respError("ERR Non scaling filters cannot expand"), "BF.RESERVE", "e", "0.01", "100", "EXPANSION", "2", "NONSCALING")
+		expectReply(t, c, respError("ERR syntax error"), "BF.RESERVE", "e", "0.01", "100", "FOO")
+		expectReply(t, c, respError(fmt.Sprintf("ERR invalid filter options: capacity must be at most %d", DefaultMaxCapacity)),
+			"BF.RESERVE", "e", "0.01", "1099511627776")
+
+		expectReply(t, c, []interface{}{nil}, "BF.INFO", "r", "EXPANSION")
+		expectReply(t, c, []interface{}{int64(4)}, "BF.INFO", "s", "EXPANSION")
+		expectReply(t, c, []interface{}{int64(1000)}, "BF.INFO", "s", "CAPACITY")
+		expectReply(t, c, []interface{}{int64(11)}, "BF.INFO", "t", "CAPACITY")
+		expectReply(t, c, respError("ERR invalid information value"), "BF.INFO", "s", "FOO")
+	})
+
+	t.Run("it reports a full non scaling filter", func(t *testing.T) {
+		for i := 0; i < 100; i++ {
+			switch reply := c.do(t, "BF.ADD", "r", fmt.Sprintf("foo%d", i)); reply {
+			case int64(0), int64(1):
+			case respError("ERR non scaling filter is full"):
+				return
+			default:
+				t.Fatalf("Expected BF.ADD to reply 0, 1 or a full filter error, got %#v", reply)
+			}
+		}
+		t.Errorf("Expected the filter of capacity 20 to be full")
+	})
+
+	t.Run("it speaks RESP3 after HELLO 3", func(t *testing.T) {
+		c := dialRESP(t, addr)
+		hello := c.do(t, "HELLO", "3").([]interface{})
+		if len(hello) != 14 || hello[4] != "proto" || hello[5] != int64(3) {
+			t.Fatalf("Expected the HELLO map with proto 3, got %#v", hello)
+		}
+		expectReply(t, c, true, "BF.ADD", "f3", "foo")
+		expectReply(t, c, false, "BF.ADD", "f3", "foo")
+		expectReply(t, c, []interface{}{true, false}, "BF.MEXISTS", "f3", "foo", "bar")
+		expectReply(t, c, []interface{}{nil}, "BF.INFO", "r", "EXPANSION")
+		expectReply(t, c, respError("NOPROTO unsupported protocol version"), "HELLO", "4")
+	})
+
+	t.Run("it replies to pipelined and inline commands", func(t *testing.T) {
+		c := dialRESP(t, addr)
+		if _, err := c.conn.Write([]byte("PING\r\nBF.EXISTS f foo\r\n")); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if reply := c.read(t); reply != "PONG" {
+			t.Errorf("Expected PONG, got %#v", reply)
+		}
+		if reply := c.read(t); reply != int64(1) {
+			t.Errorf("Expected 1, got %#v", reply)
+		}
+		expectReply(t, c, "OK", "QUIT")
+		if _, err := c.r.ReadByte(); err != io.EOF {
+			t.Errorf("Expected the connection to be closed, got %v", err)
+		}
+	})
+
+	t.Run("it closes the connection on a protocol error", func(t *testing.T) {
+		c := dialRESP(t, addr)
+		if _, err := c.conn.Write([]byte("*1\r\n+foo\r\n")); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if reply, ok := c.read(t).(respError); !ok {
+			t.Errorf("Expected a protocol error, got %#v", reply)
+		}
+		if _, err := c.r.ReadByte(); err != io.EOF {
+			t.Errorf("Expected the connection to be closed, got %v", err)
+		}
+	})
+
+	t.Run("it rejects too big commands", func(t *testing.T) {
+		// the arguments of the request are within maxBulkLen, but not their total
+		n := maxRequestLen/maxBulkLen + 1
+		arg := fmt.Sprintf("$%d\r\n%s\r\n", maxBulkLen, strings.Repeat("a", maxBulkLen))
+		requests := map[string]string{
+			"too many arguments": fmt.Sprintf("*%d\r\n", maxArgs+1),
+			"too big argument":   fmt.Sprintf("*1\r\n$%d\r\n", maxBulkLen+1),
+			"too big request":    fmt.Sprintf("*%d\r\n", n) + strings.Repeat(arg, n),
+		}
+		for name, req := range requests {
+			c := dialRESP(t, addr)
+
+			// the server replies before the whole request is sent
+			go c.conn.Write([]byte(req))
+			if reply, ok := c.read(t).(respError); !ok {
+				t.Errorf("%s: expected a protocol error, got %#v", name, reply)
+			}
+		}
+	})
+}
+
+func TestRESPServer_Restart(t *testing.T) {
+	dir := t.TempDir()
+	reg := newTestRegistry(t, dir)
+	s, addr := startRESP(t, reg)
+	c := dialRESP(t, addr)
+	expectReply(t, c, "OK", "BF.RESERVE", "r", "0.01", "1000", "NONSCALING")
+	expectReply(t, c, int64(1), "BF.ADD", "r", "foo")
+	expectReply(t, c, int64(1), "BF.ADD", "f", "foo")
+	s.Close()
+	reg.Close()
+
+	_, addr = startRESP(t, newTestRegistry(t, dir))
+	c = dialRESP(t, addr)
+	expectReply(t, c, int64(1), "BF.EXISTS", "r", "foo")
+	expectReply(t, c, int64(1), "BF.EXISTS", "f", "foo")
+	expectReply(t, c, []interface{}{nil}, "BF.INFO", "r", "EXPANSION")
+	expectReply(t, c, []interface{}{int64(2)}, "BF.INFO", "f", "EXPANSION")
+}
@@ -0,0 +1,464 @@
+package sprout
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// SlidingBloomFilter is a bloom filter over a sliding window of keys, made of a ring of
+// generations of the same size. Keys are added to the newest generation, and looked up in all of them.
+//
+// A new generation is started when the newest one is RotateEvery old or full, and it replaces
+// the oldest generation, whose keys expire. With RotateEvery set to an hour and 24 generations,
+// the filter holds the keys added in the last 23 to 24 hours.
+//
+// All the generations are stored in one mmaped file, after the sliding filter header.
+type SlidingBloomFilter struct {
+	// The desired false positive rate of the whole filter
+	err_rate float64
+
+	// the number of items each generation holds
+	capacity int
+	db       Store
+
+	// the ring of generations, current is the index of the newest one
+	generations []*BloomFilter
+	current     int
+
+	rotateEvery time.Duration
+
+	// rotatedAt is the time the newest generation was started by the time schedule
+	rotatedAt time.Time
+
+	// now returns the current time, it is replaced in tests
+	now func() time.Time
+
+	path string
+	opts *BloomOptions
+
+	// lock guards the ring: readers and writers adding to the newest generation share it,
+	// and it is only held exclusively to start a new generation
+	lock *sync.RWMutex
+
+	// addLock serialises AddIfAbsent
+	addLock *sync.Mutex
+}
+
+// NewSlidingBloom creates a new sliding bloom filter.
+// err_rate is the desired false error rate of the whole filter. e.g. 0.001 implies 1 false positive in 1000 lookups
+//
+// capacity is the number of entries each generation holds
+//
+// Generations is the number of generations in the window and defaults to 4,
+// and RotateEvery the age at which a new generation is started.
+//
+// NewSlidingBloom panics if the filter cannot be created, use NewSlidingBloomE to get an error instead.
+func NewSlidingBloom(opts *BloomOptions) *SlidingBloomFilter {
+	swf, err := NewSlidingBloomE(opts)
+	if err != nil {
+		log.Panicf("%v", err)
+	}
+	return swf
+}
+
+// NewSlidingBloomE creates a new sliding bloom filter like NewSlidingBloom,
+// but returns an error instead of panicking.
+//
+// If the file at opts.Path already holds a sliding filter created with the same options, it is restored,
+// and the generations that expired while the filter was closed are dropped.
+func NewSlidingBloomE(opts *BloomOptions) (*SlidingBloomFilter, error) {
+	if opts == nil {
+		opts = &DefaultBloomOptions
+	}
+	if err := validateOptions(opts); err != nil {
+		return nil, err
+	}
+	// the RedisBloom layout only describes plain bloom filters
+	if opts.FormatVersion == formatVersion3 {
+		return nil, fmt.Errorf("%w: sliding bloom filters do not support format version %d", ErrInvalidOptions, formatVersion3)
+	}
+	if opts.Generations == 0 {
+		opts.Generations = 4
+	}
+	if opts.Generations < 2 {
+		return nil, fmt.Errorf("%w: a sliding filter needs at least 2 generations", ErrInvalidOptions)
+	}
+	if opts.RotateEvery < 0 {
+		return nil, fmt.Errorf("%w: rotation interval must not be negative", ErrInvalidOptions)
+	}
+	if opts.Path == "" {
+		opts.Path = "/tmp/bloom.db"
+	}
+
+	swf := newSlidingBloomFilter(opts)
+	if err := swf.open(); err != nil {
+		return nil, err
+	}
+	return swf, nil
+}
+
+// OpenSlidingBloom restores the sliding bloom filter stored in the file at path.
+//
+// database is the persistent store to attach to the filter. can be omitted.
+func OpenSlidingBloom(path string, database ...Store) (*SlidingBloomFilter, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open bloom filter file: %w", err)
+	}
+	h, err := readSlidingHeader(file)
+	file.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	opts := &BloomOptions{
+		Path:        path,
+		Err_rate:    h.errRate,
+		Capacity:    int(h.capacity),
+		Generations: int(h.generations),
+		RotateEvery: time.Duration(h.rotateEvery),
+	}
+	if len(database) > 0 {
+		opts.Database = database[0]
+	}
+
+	swf := newSlidingBloomFilter(opts)
+	if err := swf.open(); err != nil {
+		return nil, err
+	}
+	return swf, nil
+}
+
+func newSlidingBloomFilter(opts *BloomOptions) *SlidingBloomFilter {
+	return &SlidingBloomFilter{
+		err_rate:    opts.Err_rate,
+		capacity:    opts.Capacity,
+		db:          opts.Database,
+		rotateEvery: opts.RotateEvery,
+		now:         time.Now,
+		path:        opts.Path,
+		opts:        opts,
+		lock:        &sync.RWMutex{},
+		addLock:     &sync.Mutex{},
+	}
+}
+
+// open maps the generations of the file, creating them if the file is empty
+func (swf *SlidingBloomFilter) open() error {
+	file, err := os.OpenFile(swf.path, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return fmt.Errorf("unable to open bloom filter file: %w", err)
+	}
+	fi, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	swf.rotatedAt = swf.now()
+	if fi.Size() > 0 {
+		h, err := readSlidingHeader(file)
+		if err != nil {
+			file.Close()
+			return err
+		}
+		if h.errRate != swf.err_rate || h.capacity != uint64(swf.capacity) ||
+			h.generations != uint32(swf.opts.Generations) || h.rotateEvery != int64(swf.rotateEvery) {
+			file.Close()
+			return fmt.Errorf("%w: file has capacity %d, error rate %v, %d generations and rotation interval %v",
+				ErrHeaderMismatch, h.capacity, h.errRate, h.generations, time.Duration(h.rotateEvery))
+		}
+		swf.current = int(h.current)
+		swf.rotatedAt = time.Unix(0, h.rotatedAt)
+	}
+	file.Close()
+
+	// the generations all have the same size, one after the other
+	generations := make([]*BloomFilter, swf.opts.Generations)
+	regions := make([]*BloomOptions, len(generations))
+	offset := slidingHeaderSize
+	for i := range generations {
+		regions[i] = swf.generationOptions(offset)
+		generations[i] = newBloomFilter(regions[i])
+		generations[i].setRegion(regions[i])
+		offset = generations[i].opts.dataSize
+	}
+
+	// the last generation maps the whole file, and holds the file handle and lock
+	last := generations[len(generations)-1]
+	if err := last.open(regions[len(regions)-1]); err != nil {
+		return err
+	}
+	for _, bf := range generations[:len(generations)-1] {
+		bf.mem = last.mem
+		if err := bf.loadHeader(true); err != nil {
+			last.release()
+			return err
+		}
+	}
+
+	swf.generations = generations
+	swf.storeHeader()
+	return nil
+}
+
+// generationOptions returns the options of the generation whose region starts at offset.
+// A key is looked up in every generation, so each one gets a share of the error rate.
+func (swf *SlidingBloomFilter) generationOptions(offset int) *BloomOptions {
+	return &BloomOptions{
+		Path:          swf.path,
+		Err_rate:      swf.err_rate / float64(swf.opts.Generations),
+		Capacity:      swf.capacity,
+		Database:      swf.db,
+		FormatVersion: swf.opts.FormatVersion,
+		Hasher:        swf.opts.Hasher,
+		dataSize:      offset,
+	}
+}
+
+// Newest returns the generation keys are added to.
+// The newest generation changes when a new generation is started.
+func (swf *SlidingBloomFilter) Newest() *BloomFilter {
+	swf.lock.RLock()
+	defer swf.lock.RUnlock()
+	return swf.newest()
+}
+
+func (swf *SlidingBloomFilter) newest() *BloomFilter {
+	return swf.generations[swf.current]
+}
+
+// Add adds the key to the newest generation of the filter, starting a new generation if it is due or full.
+// Complexity: O(k)
+func (swf *SlidingBloomFilter) Add(key []byte) error {
+	swf.expire()
+	for {
+		swf.lock.RLock()
+		newest := swf.newest()
+		err := newest.Add(key)
+		swf.lock.RUnlock()
+
+		if !errors.Is(err, ErrCapacityReached) {
+			return err
+		}
+		swf.rotateFull(newest)
+	}
+}
+
+// AddIfAbsent adds the key to the filter if it is not already in any of its generations.
+// The check and the insertion are done atomically, so that concurrent callers
+// adding the same key do not both see it as new.
+//
+// added is false if the key was probably already in the filter.
+func (swf *SlidingBloomFilter) AddIfAbsent(key []byte) (added bool, err error) {
+	swf.addLock.Lock()
+	defer swf.addLock.Unlock()
+
+	if swf.Contains(key) {
+		return false, nil
+	}
+	if err := swf.Add(key); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Contains checks if the key is in any of the live generations of the filter
+func (swf *SlidingBloomFilter) Contains(key []byte) bool {
+	swf.expire()
+
+	swf.lock.RLock()
+	defer swf.lock.RUnlock()
+
+	// the newest generations are the most likely to hold the key
+	for i := 0; i < len(swf.generations); i++ {
+		if swf.generation(i).Contains(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// generation returns the i-th newest generation, the caller must hold the lock
+func (swf *SlidingBloomFilter) generation(i int) *BloomFilter {
+	n := len(swf.generations)
+	return swf.generations[(swf.current-i+n)%n]
+}
+
+// Rotate starts a new generation, expiring the keys of the oldest one.
+// It can be used to rotate the filter on a schedule of its own.
+func (swf *SlidingBloomFilter) Rotate() {
+	swf.lock.Lock()
+	defer swf.lock.Unlock()
+
+	swf.advance()
+	swf.storeHeader()
+}
+
+// expire starts the new generations that are due according to the time schedule
+func (swf *SlidingBloomFilter) expire() {
+	if swf.rotateEvery <= 0 {
+		return
+	}
+	now := swf.now()
+
+	swf.lock.RLock()
+	due := now.Sub(swf.rotatedAt) >= swf.rotateEvery
+	swf.lock.RUnlock()
+	if !due {
+		return
+	}
+
+	swf.lock.Lock()
+	defer swf.lock.Unlock()
+
+	// the generations are started at multiples of rotateEvery, and all of them
+	// have expired if the filter was not used for the whole window
+	periods := int64(now.Sub(swf.rotatedAt) / swf.rotateEvery)
+	for i := int64(0); i < periods && i < int64(len(swf.generations)); i++ {
+		swf.advance()
+	}
+	swf.rotatedAt = swf.rotatedAt.Add(time.Duration(periods) * swf.rotateEvery)
+	swf.storeHeader()
+}
+
+// rotateFull starts a new generation after the full newest generation
+func (swf *SlidingBloomFilter) rotateFull(full *BloomFilter) {
+	swf.lock.Lock()
+	defer swf.lock.Unlock()
+
+	// another writer has already started a new generation
+	if swf.newest() != full {
+		return
+	}
+	swf.advance()
+	swf.storeHeader()
+}
+
+// advance replaces the oldest generation with an empty one, the caller must hold the lock
+func (swf *SlidingBloomFilter) advance() {
+	swf.current = (swf.current + 1) % len(swf.generations)
+	swf.newest().reset()
+}
+
+// Put adds the key to the filter, and also stores it in the persistent store
+func (swf *SlidingBloomFilter) Put(key, val []byte) error {
+	if !storeReady(swf.db) {
+		return fmt.Errorf("%w, use Add() to add keys", ErrNoStore)
+	}
+	if err := swf.Add(key); err != nil {
+		return err
+	}
+	return swf.db.Put(key, val)
+}
+
+// Get gets the key from the underlying persistent store
+func (swf *SlidingBloomFilter) Get(key []byte) []byte {
+	val, err := swf.GetE(key)
+	if errors.Is(err, ErrNoStore) {
+		log.Panicf("SlidingBloomFilter has no persistent store. Use Contains() instead")
+	}
+	if err != nil {
+		fmt.Printf("Error getting key %s from db: %s\n", key, err)
+		return nil
+	}
+	return val
+}
+
+// GetE gets the key from the underlying persistent store like Get, but returns an error instead of panicking.
+// A nil value is returned if the key is not found, or if it has expired from the filter.
+func (swf *SlidingBloomFilter) GetE(key []byte) ([]byte, error) {
+	if !storeReady(swf.db) {
+		return nil, fmt.Errorf("%w, use Contains() instead", ErrNoStore)
+	}
+	if !swf.Contains(key) {
+		return nil, nil
+	}
+	return swf.db.Get(key)
+}
+
+// Delete removes the key from the persistent store.
+// Bits cannot be unset in a bloom filter, so Contains reports the key until its generation expires.
+func (swf *SlidingBloomFilter) Delete(key []byte) error {
+	if !storeReady(swf.db) {
+		return fmt.Errorf("%w, keys cannot be removed from a bloom filter", ErrNoStore)
+	}
+	return swf.db.Delete(key)
+}
+
+// Capacity returns the number of items the live generations hold together
+func (swf *SlidingBloomFilter) Capacity() int {
+	return swf.capacity * len(swf.generations)
+}
+
+// Count returns the number of items in the live generations
+func (swf *SlidingBloomFilter) Count() int {
+	swf.expire()
+
+	swf.lock.RLock()
+	defer swf.lock.RUnlock()
+
+	sum := 0
+	for _, bf := range swf.generations {
+		sum += bf.Count()
+	}
+	return sum
+}
+
+// DB returns the underlying persistent store
+func (swf *SlidingBloomFilter) DB() interface{} {
+	return storeDB(swf.db)
+}
+
+// Stats returns the stats of the filter.
+// M and K are the parameters of each generation.
+func (swf *SlidingBloomFilter) Stats() BloomFilterStats {
+	size := 0
+	for _, bf := range swf.generations {
+		size += bf.bit_width
+	}
+	newest := swf.Newest()
+	return BloomFilterStats{
+		Capacity: swf.Capacity(),
+		Count:    swf.Count(),
+		Size:     size,
+		M:        newest.m,
+		K:        newest.k,
+		Prob:     swf.err_rate,
+	}
+}
+
+// Clear resets all the generations of the filter
+func (swf *SlidingBloomFilter) Clear() {
+	if err := swf.ClearE(); err != nil {
+		log.Panicf("%v", err)
+	}
+}
+
+// ClearE resets all the generations of the filter like Clear, but returns an error instead of panicking.
+func (swf *SlidingBloomFilter) ClearE() error {
+	swf.lock.Lock()
+	defer swf.lock.Unlock()
+
+	for _, bf := range swf.generations {
+		bf.reset()
+	}
+	swf.current = 0
+	swf.rotatedAt = swf.now()
+	swf.storeHeader()
+	return swf.generations[len(swf.generations)-1].mem.Flush()
+}
+
+// Close flushes the filter to disk and closes the file handle to the filter
+func (swf *SlidingBloomFilter) Close() error {
+	swf.lock.Lock()
+	defer swf.lock.Unlock()
+
+	return swf.generations[len(swf.generations)-1].Close()
+}
+
+var _ KeyValueFilter = (*SlidingBloomFilter)(nil)
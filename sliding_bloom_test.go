@@ -0,0 +1,242 @@
+package sprout
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// assertKeys checks that the keys with the given prefix are all in the filter, or mostly not
+func assertKeys(t *testing.T, swf *SlidingBloomFilter, prefix string, n int, want bool) {
+	t.Helper()
+	found := 0
+	for i := 0; i < n; i++ {
+		if swf.Contains([]byte(fmt.Sprintf("%s%d", prefix, i))) {
+			found++
+		}
+	}
+	if want && found != n {
+		t.Errorf("Expected all %d %s keys to be found, got %d", n, prefix, found)
+	}
+	if !want && found > n/10 {
+		t.Errorf("Expected the %s keys to have expired, got %d of %d", prefix, found, n)
+	}
+}
+
+func TestSlidingBloomFilter_RotateWhenFull(t *testing.T) {
+	opts := &BloomOptions{
+		Err_rate:    0.01,
+		Capacity:    100,
+		Path:        "./test.db",
+		Generations: 3,
+	}
+	swf := NewSlidingBloom(opts)
+	defer func() {
+		swf.Close()
+		os.Remove(opts.Path)
+	}()
+
+	for _, prefix := range []string{"a", "b", "c"} {
+		for i := 0; i < opts.Capacity; i++ {
+			if err := swf.Add([]byte(fmt.Sprintf("%s%d", prefix, i))); err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+		}
+	}
+	assertKeys(t, swf, "a", opts.Capacity, true)
+	if swf.Count() != 3*opts.Capacity || swf.Capacity() != 3*opts.Capacity {
+		t.Errorf("Expected count and capacity %d, got %d and %d", 3*opts.Capacity, swf.Count(), swf.Capacity())
+	}
+
+	// the newest generation is full, the next key starts a new generation in place of the oldest
+	if err := swf.Add([]byte("d0")); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	assertKeys(t, swf, "a", opts.Capacity, false)
+	assertKeys(t, swf, "b", opts.Capacity, true)
+	assertKeys(t, swf, "c", opts.Capacity, true)
+	assertKeys(t, swf, "d", 1, true)
+	if swf.Count() != 2*opts.Capacity+1 {
+		t.Errorf("Expected count %d, got %d", 2*opts.Capacity+1, swf.Count())
+	}
+
+	swf.Rotate()
+	assertKeys(t, swf, "b", opts.Capacity, false)
+	assertKeys(t, swf, "c", opts.Capacity, true)
+}
+
+func TestSlidingBloomFilter_RotateEvery(t *testing.T) {
+	opts := &BloomOptions{
+		Err_rate:    0.01,
+		Capacity:    1000,
+		Path:        "./test.db",
+		Generations: 3,
+		RotateEvery: time.Hour,
+	}
+	swf := NewSlidingBloom(opts)
+	defer func() {
+		swf.Close()
+		os.Remove(opts.Path)
+	}()
+
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := start
+	swf.now = func() time.Time { return now }
+	swf.rotatedAt = start
+
+	for hour, prefix := range []string{"a", "b", "c"} {
+		now = start.Add(time.Duration(hour)*time.Hour + 30*time.Minute)
+		for i := 0; i < 100; i++ {
+			swf.Add([]byte(fmt.Sprintf("%s%d", prefix, i)))
+		}
+	}
+	assertKeys(t, swf, "a", 100, true)
+
+	// the generation of the a keys was started 3 hours ago
+	now = start.Add(3 * time.Hour)
+	assertKeys(t, swf, "a", 100, false)
+	assertKeys(t, swf, "b", 100, true)
+	assertKeys(t, swf, "c", 100, true)
+
+	// generations are started on the hour, however late the filter is used
+	now = now.Add(90 * time.Minute)
+	assertKeys(t, swf, "b", 100, false)
+	assertKeys(t, swf, "c", 100, true)
+	if want := time.Date(2021, 1, 1, 4, 0, 0, 0, time.UTC); !swf.rotatedAt.Equal(want) {
+		t.Errorf("Expected the newest generation to be started at %v, got %v", want, swf.rotatedAt)
+	}
+
+	// every generation expires after a whole window
+	now = now.Add(10 * time.Hour)
+	assertKeys(t, swf, "c", 100, false)
+	if swf.Count() != 0 {
+		t.Errorf("Expected count 0, got %d", swf.Count())
+	}
+}
+
+func TestOpenSlidingBloom(t *testing.T) {
+	opts := &BloomOptions{
+		Err_rate:    0.01,
+		Capacity:    100,
+		Path:        "./test.db",
+		Generations: 3,
+		RotateEvery: time.Hour,
+	}
+	swf := NewSlidingBloom(opts)
+	defer os.Remove(opts.Path)
+
+	for _, prefix := range []string{"a", "b"} {
+		for i := 0; i < opts.Capacity; i++ {
+			swf.Add([]byte(fmt.Sprintf("%s%d", prefix, i)))
+		}
+	}
+	current, rotatedAt := swf.current, swf.rotatedAt
+	swf.Close()
+
+	swf, err := OpenSlidingBloom(opts.Path)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if swf.current != current || !swf.rotatedAt.Equal(rotatedAt) {
+		t.Errorf("Expected generation %d started at %v, got %d started at %v", current, rotatedAt, swf.current, swf.rotatedAt)
+	}
+	assertKeys(t, swf, "a", opts.Capacity, true)
+	assertKeys(t, swf, "b", opts.Capacity, true)
+	if swf.Count() != 2*opts.Capacity {
+		t.Errorf("Expected count %d, got %d", 2*opts.Capacity, swf.Count())
+	}
+	swf.Close()
+
+	// the generations that expired while the filter was closed are dropped
+	swf = NewSlidingBloom(opts)
+	swf.now = func() time.Time { return rotatedAt.Add(2 * time.Hour) }
+	assertKeys(t, swf, "a", opts.Capacity, false)
+	swf.Close()
+
+	_, err = NewSlidingBloomE(&BloomOptions{Err_rate: 0.01, Capacity: 100, Path: opts.Path, Generations: 4, RotateEvery: time.Hour})
+	if !errors.Is(err, ErrHeaderMismatch) {
+		t.Errorf("Expected ErrHeaderMismatch, got %v", err)
+	}
+	if _, err := OpenScalableBloom(opts.Path); !errors.Is(err, ErrInvalidHeader) {
+		t.Errorf("Expected ErrInvalidHeader opening a sliding filter as a scalable filter, got %v", err)
+	}
+}
+
+func TestNewSlidingBloomE(t *testing.T) {
+	defer os.Remove("./test.db")
+
+	invalid := []*BloomOptions{
+		{Err_rate: 0.01, Capacity: 100, Path: "./test.db", Generations: 1},
+		{Err_rate: 0.01, Capacity: 100, Path: "./test.db", RotateEvery: -time.Second},
+		{Err_rate: 0.01, Capacity: 10, Path: "./test.db"},
+		{Err_rate: 0.01, Capacity: 100, Path: "./test.db", FormatVersion: formatVersion3},
+	}
+	for _, opts := range invalid {
+		if _, err := NewSlidingBloomE(opts); !errors.Is(err, ErrInvalidOptions) {
+			t.Errorf("Expected ErrInvalidOptions for %+v, got %v", opts, err)
+		}
+	}
+
+	swf, err := NewSlidingBloomE(&BloomOptions{Err_rate: 0.01, Capacity: 100, Path: "./test.db"})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer swf.Close()
+	if len(swf.generations) != 4 {
+		t.Errorf("Expected 4 generations by default, got %d", len(swf.generations))
+	}
+}
+
+func TestSlidingBloomFilter_Concurrent(t *testing.T) {
+	opts := &BloomOptions{
+		Err_rate:    0.01,
+		Capacity:    200,
+		Path:        "./test.db",
+		Generations: 3,
+	}
+	swf := NewSlidingBloom(opts)
+	defer func() {
+		swf.Close()
+		os.Remove(opts.Path)
+	}()
+
+	workers, n := 8, 300
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(2)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < n; i++ {
+				key := []byte(fmt.Sprintf("key%d-%d", w, i))
+				var err error
+				if i%2 == 0 {
+					err = swf.Add(key)
+				} else {
+					_, err = swf.AddIfAbsent(key)
+				}
+				if err != nil {
+					t.Errorf("Expected no error, got %v", err)
+					return
+				}
+			}
+		}(w)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < n; i++ {
+				swf.Contains([]byte(fmt.Sprintf("key%d-%d", w, i)))
+				swf.Count()
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	if err := swf.Add([]byte("last")); err != nil || !swf.Contains([]byte("last")) {
+		t.Errorf("Expected the last key to be found, got error %v", err)
+	}
+	if count := swf.Count(); count > swf.Capacity() {
+		t.Errorf("Expected count to be at most %d, got %d", swf.Capacity(), count)
+	}
+}
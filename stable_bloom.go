@@ -0,0 +1,393 @@
+package sprout
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// StableBloomFilter is a bloom filter for unbounded streams, as described by F. Deng and D. Rafiei [6].
+//
+// Each position of the filter is a small cell instead of a bit. Adding a key decrements
+// a few random cells before setting the cells of the key to their maximum value, so that
+// old keys are forgotten and the fraction of set cells stays stable however many keys are added.
+// The filter never fills up, but a key added long ago may no longer be found.
+type StableBloomFilter struct {
+	// the underlying filter holds the cells in its mmaped region
+	bf *BloomFilter
+
+	// the number of bits per cell
+	cellBits int
+
+	// the value of the cells of an added key
+	max uint64
+
+	// the number of cells decremented per insertion (P)
+	decrements int
+
+	// picks the decremented cells, guarded by bf.lock
+	rand *rand.Rand
+
+	// the number of non zero cells in each slice of the filter, kept by setCell
+	set []int64
+}
+
+// NewStableBloom creates a new stable bloom filter.
+// err_rate is the false positive rate the filter converges to. e.g. 0.001 implies 1 false positive in 1000 lookups
+//
+// capacity sizes the filter like a bloom filter of the same capacity, and is the age, in keys added,
+// for which Stats reports the false negative rate. A filter sized for capacity keys forgets most of them
+// before capacity more keys are added, a larger capacity or more bits per cell keep the keys longer.
+//
+// CounterBits is the number of bits per cell, and defaults to 2.
+//
+// NewStableBloom panics if the filter cannot be created, use NewStableBloomE to get an error instead.
+func NewStableBloom(opts *BloomOptions) *StableBloomFilter {
+	stf, err := NewStableBloomE(opts)
+	if err != nil {
+		log.Panicf("%v", err)
+	}
+	return stf
+}
+
+// NewStableBloomE creates a new stable bloom filter like NewStableBloom, but returns an error instead of panicking.
+func NewStableBloomE(opts *BloomOptions) (*StableBloomFilter, error) {
+	if opts == nil {
+		opts = &DefaultBloomOptions
+	}
+	if err := validateOptions(opts); err != nil {
+		return nil, err
+	}
+	// the RedisBloom layout only describes plain bloom filters
+	if opts.FormatVersion == formatVersion3 {
+		return nil, fmt.Errorf("%w: stable bloom filters do not support format version %d", ErrInvalidOptions, formatVersion3)
+	}
+
+	cellBits := opts.CounterBits
+	if cellBits == 0 {
+		cellBits = 2
+	}
+	if cellBits != 1 && cellBits != 2 && cellBits != 4 {
+		return nil, fmt.Errorf("%w: cell bits must be 1, 2 or 4", ErrInvalidOptions)
+	}
+
+	bf := newBloomFilter(opts)
+	bf.kind = stableHeaderMagic
+	bf.cellBits = cellBits
+	bf.bit_width = (bf.k*bf.m*cellBits + 7) / 8
+
+	if err := bf.open(opts); err != nil {
+		return nil, err
+	}
+	return newStableBloomFilter(bf), nil
+}
+
+// OpenStableBloom restores the stable bloom filter stored in the file at path.
+//
+// database is the persistent store to attach to the filter. can be omitted.
+func OpenStableBloom(path string, database ...Store) (*StableBloomFilter, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open bloom filter file: %w", err)
+	}
+	h, err := readHeader(file, 0)
+	file.Close()
+	if err != nil {
+		return nil, err
+	}
+	if h.magic != stableHeaderMagic {
+		return nil, fmt.Errorf("%w: not a stable bloom filter", ErrInvalidHeader)
+	}
+
+	opts := &BloomOptions{
+		Path:        path,
+		Err_rate:    h.errRate,
+		Capacity:    int(h.capacity),
+		CounterBits: int(h.cellBits),
+	}
+	if len(database) > 0 {
+		opts.Database = database[0]
+	}
+
+	bf := bloomFromHeader(h, opts.Database)
+	if err := bf.open(opts); err != nil {
+		return nil, err
+	}
+	return newStableBloomFilter(bf), nil
+}
+
+func newStableBloomFilter(bf *BloomFilter) *StableBloomFilter {
+	max := uint64(1)<<bf.cellBits - 1
+	stf := &StableBloomFilter{
+		bf:         bf,
+		cellBits:   bf.cellBits,
+		max:        max,
+		decrements: stableDecrements(bf.err_rate, bf.k, bf.k*bf.m, max),
+		rand:       rand.New(rand.NewSource(time.Now().UnixNano())),
+		set:        make([]int64, bf.k),
+	}
+	for idx := uint64(0); idx < uint64(bf.k*bf.m); idx++ {
+		if stf.cell(idx) > 0 {
+			stf.set[idx/uint64(bf.m)]++
+		}
+	}
+	return stf
+}
+
+// stableDecrements returns the number of cells to decrement per insertion, so that
+// the false positive rate of a filter of numCells cells converges to errRate.
+//
+// At the stable point, the fraction of zero cells is p0 = (1 / (1 + 1/(P(1/k - 1/m))))^max [6],
+// and the false positive rate is (1 - p0)^k.
+func stableDecrements(errRate float64, k, numCells int, max uint64) int {
+	p0 := 1 - math.Pow(errRate, 1/float64(k))
+	p := 1 / ((math.Pow(p0, -1/float64(max)) - 1) * (1/float64(k) - 1/float64(numCells)))
+	if p < 1 {
+		return 1
+	}
+	return int(math.Round(p))
+}
+
+// Add adds the key to the filter, after decrementing random cells to make room for it.
+// Adding a key never fails because the filter is full.
+func (stf *StableBloomFilter) Add(key []byte) error {
+	bf := stf.bf
+	var buf [maxStackIndices]uint64
+	indices := bf.appendCandidates(buf[:0], key)
+
+	bf.lock.Lock()
+	defer bf.lock.Unlock()
+
+	numCells := int64(bf.k * bf.m)
+	for i := 0; i < stf.decrements; i++ {
+		idx := uint64(stf.rand.Int63n(numCells))
+		if c := stf.cell(idx); c > 0 {
+			stf.setCell(idx, c-1)
+		}
+	}
+	for _, idx := range indices {
+		stf.setCell(idx, stf.max)
+	}
+	atomic.AddInt64(&bf.count, 1)
+	bf.storeCount()
+	return nil
+}
+
+// Contains checks if the key exists in the filter
+func (stf *StableBloomFilter) Contains(key []byte) bool {
+	var buf [maxStackIndices]uint64
+	indices := stf.bf.appendCandidates(buf[:0], key)
+
+	// the cells are decremented and set a byte at a time by Add
+	stf.bf.lock.RLock()
+	defer stf.bf.lock.RUnlock()
+
+	for _, idx := range indices {
+		if stf.cell(idx) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// cell returns the value of the cell at idx. the first cell of a byte is in its high bits.
+func (stf *StableBloomFilter) cell(idx uint64) uint64 {
+	pos, shift := stf.cellPosition(idx)
+	return uint64(stf.bf.mem[pos]>>shift) & stf.max
+}
+
+// setCell sets the value of the cell at idx, and counts the cells it sets or clears
+func (stf *StableBloomFilter) setCell(idx, val uint64) {
+	pos, shift := stf.cellPosition(idx)
+	mem := stf.bf.mem
+	old := uint64(mem[pos]>>shift) & stf.max
+	mem[pos] = mem[pos]&^(byte(stf.max)<<shift) | byte(val)<<shift
+
+	switch slice := idx / uint64(stf.bf.m); {
+	case old == 0 && val > 0:
+		atomic.AddInt64(&stf.set[slice], 1)
+	case old > 0 && val == 0:
+		atomic.AddInt64(&stf.set[slice], -1)
+	}
+}
+
+// cellPosition returns the position in mem of the byte holding the cell at idx, and the shift of the cell in the byte
+func (stf *StableBloomFilter) cellPosition(idx uint64) (int, uint) {
+	bit := idx * uint64(stf.cellBits)
+	return stf.bf.bitOffset + int(bit/8), uint(8 - uint64(stf.cellBits) - bit%8)
+}
+
+// Put adds the key to the filter, and also stores it in the persistent store
+func (stf *StableBloomFilter) Put(key, val []byte) error {
+	if !stf.bf.hasStore() {
+		return fmt.Errorf("%w, use Add() to add keys", ErrNoStore)
+	}
+
+	if err := stf.Add(key); err != nil {
+		return err
+	}
+	return stf.bf.db.Put(key, val)
+}
+
+// Get gets the key from the underlying persistent store
+func (stf *StableBloomFilter) Get(key []byte) []byte {
+	val, err := stf.GetE(key)
+	if errors.Is(err, ErrNoStore) {
+		log.Panicf("StableBloomFilter has no persistent store. Use Contains() instead")
+	}
+	if err != nil {
+		fmt.Printf("Error getting key %s from db: %s\n", key, err)
+		return nil
+	}
+	return val
+}
+
+// GetE gets the key from the underlying persistent store like Get, but returns an error instead of panicking.
+// A nil value is returned if the key is not found, or if the filter has forgotten it.
+func (stf *StableBloomFilter) GetE(key []byte) ([]byte, error) {
+	if !stf.bf.hasStore() {
+		return nil, fmt.Errorf("%w, use Contains() instead", ErrNoStore)
+	}
+
+	if !stf.Contains(key) {
+		return nil, nil
+	}
+
+	return stf.bf.db.Get(key)
+}
+
+// Delete removes the key from the persistent store.
+// The cells of the key are left to decay, so Contains may still report the key.
+func (stf *StableBloomFilter) Delete(key []byte) error {
+	return stf.bf.Delete(key)
+}
+
+// Capacity returns the capacity the filter is sized for
+func (stf *StableBloomFilter) Capacity() int {
+	return stf.bf.capacity
+}
+
+// Count returns the number of items added to the filter, including the ones it has forgotten
+func (stf *StableBloomFilter) Count() int {
+	return stf.bf.Count()
+}
+
+// FilterSize returns the size of the cell array in bytes
+func (stf *StableBloomFilter) FilterSize() int {
+	return stf.bf.bit_width
+}
+
+// DB returns the underlying persistent store
+func (stf *StableBloomFilter) DB() interface{} {
+	return stf.bf.DB()
+}
+
+// Clear resets all cells in the filter
+func (stf *StableBloomFilter) Clear() {
+	if err := stf.ClearE(); err != nil {
+		fmt.Printf("Error flushing filter to disk: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+// ClearE resets all cells in the filter like Clear, but returns an error instead of exiting.
+func (stf *StableBloomFilter) ClearE() error {
+	bf := stf.bf
+	bf.lock.Lock()
+	defer bf.lock.Unlock()
+
+	bf.reset()
+	for i := range stf.set {
+		atomic.StoreInt64(&stf.set[i], 0)
+	}
+	return bf.mem.Flush()
+}
+
+// Stats returns the stats of the filter.
+//
+// Prob is the current false positive rate, computed from the fraction of set cells,
+// which converges to the error rate of the filter as keys are added.
+// FalseNegativeProb is the probability that a key added Capacity keys ago has been forgotten.
+func (stf *StableBloomFilter) Stats() BloomFilterStats {
+	bf := stf.bf
+	return BloomFilterStats{
+		Capacity:          bf.capacity,
+		Count:             bf.Count(),
+		Size:              bf.bit_width,
+		M:                 bf.m,
+		K:                 bf.k,
+		Prob:              stf.falsePositiveRate(),
+		FalseNegativeProb: stf.falseNegativeRate(bf.capacity),
+	}
+}
+
+// falsePositiveRate returns the probability that the cell of a key is set in every slice of the filter.
+// It reads the running counts of set cells, so it neither scans the filter nor blocks Add.
+func (stf *StableBloomFilter) falsePositiveRate() float64 {
+	rate := 1.0
+	for i := range stf.set {
+		rate *= float64(atomic.LoadInt64(&stf.set[i])) / float64(stf.bf.m)
+	}
+	return rate
+}
+
+// falseNegativeRate returns the probability that a key added age keys ago is not found.
+//
+// After a key is added, each insertion decrements one of its cells with probability P/(k*m),
+// and sets it back to max if the cell is one of the cells of the new key, with probability 1/m.
+// The distribution of the value of a cell after age insertions is computed from the powers of that
+// transition matrix, and the key is not found if any of its k cells is zero.
+func (stf *StableBloomFilter) falseNegativeRate(age int) float64 {
+	bf := stf.bf
+	dec := float64(stf.decrements) / float64(bf.k*bf.m)
+	set := 1 / float64(bf.m)
+
+	// trans[from*n+to] is the probability that a cell goes from value from to value to in one insertion
+	n := int(stf.max) + 1
+	trans := make([]float64, n*n)
+	for v := 0; v < n; v++ {
+		if v > 0 {
+			trans[v*n+v-1] += dec * (1 - set)
+			trans[v*n+v] += (1 - dec) * (1 - set)
+		} else {
+			trans[v*n+v] += 1 - set
+		}
+		trans[v*n+n-1] += set
+	}
+
+	// dist[v] is the probability that the cell has value v, starting from max
+	dist := make([]float64, n)
+	dist[n-1] = 1
+	for ; age > 0; age >>= 1 {
+		if age&1 == 1 {
+			dist = mulMatrix(dist, trans, 1, n)
+		}
+		trans = mulMatrix(trans, trans, n, n)
+	}
+	return 1 - math.Pow(1-dist[0], float64(bf.k))
+}
+
+// mulMatrix returns the product of the rows x n matrix a and the n x n matrix b
+func mulMatrix(a, b []float64, rows, n int) []float64 {
+	res := make([]float64, rows*n)
+	for i := 0; i < rows; i++ {
+		for j := 0; j < n; j++ {
+			for l := 0; l < n; l++ {
+				res[i*n+j] += a[i*n+l] * b[l*n+j]
+			}
+		}
+	}
+	return res
+}
+
+// Close flushes the filter to disk and closes the file handle to the filter
+func (stf *StableBloomFilter) Close() error {
+	return stf.bf.Close()
+}
+
+var _ KeyValueFilter = (*StableBloomFilter)(nil)
@@ -0,0 +1,214 @@
+package sprout
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sync"
+	"testing"
+)
+
+func TestStableBloomFilter(t *testing.T) {
+	opts := &BloomOptions{
+		Err_rate: 0.01,
+		Capacity: 10000,
+		Path:     "./test.db",
+	}
+	stf := NewStableBloom(opts)
+	defer func() {
+		stf.Close()
+		os.Remove(opts.Path)
+	}()
+
+	// far more keys than the capacity of a bloom filter of the same size
+	total := 20 * opts.Capacity
+	for i := 0; i < total; i++ {
+		if err := stf.Add([]byte(fmt.Sprintf("foo%d", i))); err != nil {
+			t.Fatalf("Expected no error adding key foo%d, got %v", i, err)
+		}
+	}
+
+	t.Run("should find the most recent keys", func(t *testing.T) {
+		for i := total - 100; i < total; i++ {
+			if !stf.Contains([]byte(fmt.Sprintf("foo%d", i))) {
+				t.Fatalf("Expected key foo%d to be found", i)
+			}
+		}
+	})
+
+	t.Run("false positive rate should converge to the error rate", func(t *testing.T) {
+		found := 0
+		for i := 0; i < 100000; i++ {
+			if stf.Contains([]byte(fmt.Sprintf("bar%d", i))) {
+				found++
+			}
+		}
+		rate := float64(found) / 100000
+		if rate > opts.Err_rate*1.5 || rate < opts.Err_rate/2 {
+			t.Errorf("Expected false positive rate to be close to %v, got %v", opts.Err_rate, rate)
+		}
+
+		stats := stf.Stats()
+		if stats.Prob > opts.Err_rate*1.5 || stats.Prob < opts.Err_rate/2 {
+			t.Errorf("Expected estimated false positive rate to be close to %v, got %v", opts.Err_rate, stats.Prob)
+		}
+		if stats.Count != total {
+			t.Errorf("Expected count to be %d, got %d", total, stats.Count)
+		}
+	})
+
+	t.Run("false negative rate should match the forgotten keys", func(t *testing.T) {
+		age := opts.Capacity
+		missing := 0
+		for i := total - age - 1000; i < total-age; i++ {
+			if !stf.Contains([]byte(fmt.Sprintf("foo%d", i))) {
+				missing++
+			}
+		}
+		rate := float64(missing) / 1000
+		if fnr := stf.Stats().FalseNegativeProb; fnr < rate-0.05 || fnr > rate+0.05 {
+			t.Errorf("Expected estimated false negative rate to be close to %v, got %v", rate, fnr)
+		}
+
+		prev := 0.0
+		for _, age := range []int{0, 10, 100, 1000, 10000, 100000} {
+			fnr := stf.falseNegativeRate(age)
+			if fnr < prev || fnr > 1 {
+				t.Errorf("Expected false negative rate to grow with age, got %v after %v at age %d", fnr, prev, age)
+			}
+			prev = fnr
+		}
+		if fnr := stf.falseNegativeRate(0); fnr != 0 {
+			t.Errorf("Expected no false negatives for the last key, got %v", fnr)
+		}
+	})
+
+	t.Run("should be restored from the file", func(t *testing.T) {
+		prob := stf.Stats().Prob
+		stf.Close()
+		var err error
+		stf, err = OpenStableBloom(opts.Path)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if stf.Count() != total {
+			t.Errorf("Expected count to be %d, got %d", total, stf.Count())
+		}
+		if p := stf.Stats().Prob; p != prob {
+			t.Errorf("Expected estimated false positive rate to be %v, got %v", prob, p)
+		}
+		if !stf.Contains([]byte(fmt.Sprintf("foo%d", total-1))) {
+			t.Errorf("Expected key foo%d to be found", total-1)
+		}
+
+		if _, err := OpenBloom(opts.Path); !errors.Is(err, ErrInvalidHeader) {
+			t.Errorf("Expected ErrInvalidHeader opening a stable filter as a bloom filter, got %v", err)
+		}
+		if _, err := OpenCountingBloom(opts.Path); !errors.Is(err, ErrInvalidHeader) {
+			t.Errorf("Expected ErrInvalidHeader opening a stable filter as a counting filter, got %v", err)
+		}
+	})
+
+	t.Run("should reset the estimated false positive rate when cleared", func(t *testing.T) {
+		if err := stf.ClearE(); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if p := stf.Stats().Prob; p != 0 {
+			t.Errorf("Expected estimated false positive rate to be 0, got %v", p)
+		}
+	})
+}
+
+func TestNewStableBloomE(t *testing.T) {
+	defer os.Remove("./test.db")
+
+	for _, bits := range []int{3, 8} {
+		_, err := NewStableBloomE(&BloomOptions{Err_rate: 0.01, Capacity: 1000, Path: "./test.db", CounterBits: bits})
+		if !errors.Is(err, ErrInvalidOptions) {
+			t.Errorf("Expected ErrInvalidOptions with %d bits per cell, got %v", bits, err)
+		}
+	}
+
+	_, err := NewStableBloomE(&BloomOptions{Err_rate: 0.01, Capacity: 1000, Path: "./test.db", FormatVersion: formatVersion3, Hasher: HasherMurmur2})
+	if !errors.Is(err, ErrInvalidOptions) {
+		t.Errorf("Expected ErrInvalidOptions with format version 3, got %v", err)
+	}
+
+	stf, err := NewStableBloomE(&BloomOptions{Err_rate: 0.01, Capacity: 1000, Path: "./test.db", CounterBits: 4})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	stf.Close()
+
+	_, err = NewStableBloomE(&BloomOptions{Err_rate: 0.01, Capacity: 1000, Path: "./test.db", CounterBits: 1})
+	if !errors.Is(err, ErrHeaderMismatch) {
+		t.Errorf("Expected ErrHeaderMismatch, got %v", err)
+	}
+}
+
+func TestStableDecrements(t *testing.T) {
+	for _, bits := range []int{1, 2, 4} {
+		for _, errRate := range []float64{0.1, 0.01, 0.001} {
+			bf := newBloomFilter(&BloomOptions{Err_rate: errRate, Capacity: 10000})
+			max := uint64(1)<<bits - 1
+			p := stableDecrements(errRate, bf.k, bf.k*bf.m, max)
+
+			// the more cells are decremented, the fewer are set at the stable point
+			lower := stableFalsePositiveRate(p+1, bf.k, bf.k*bf.m, max)
+			upper := stableFalsePositiveRate(p-1, bf.k, bf.k*bf.m, max)
+			if p < 1 || lower > errRate || (p > 1 && upper < errRate) {
+				t.Errorf("Expected %d decrements to bracket error rate %v with %d bits, got [%v, %v]", p, errRate, bits, lower, upper)
+			}
+		}
+	}
+}
+
+// stableFalsePositiveRate returns the false positive rate at the stable point of a filter decrementing p cells per insertion
+func stableFalsePositiveRate(p, k, numCells int, max uint64) float64 {
+	zero := math.Pow(1/(1+1/(float64(p)*(1/float64(k)-1/float64(numCells)))), float64(max))
+	return math.Pow(1-zero, float64(k))
+}
+
+func TestStableBloomFilter_Concurrent(t *testing.T) {
+	opts := &BloomOptions{
+		Err_rate: 0.01,
+		Capacity: 1000,
+		Path:     "./test.db",
+	}
+	stf := NewStableBloom(opts)
+	defer func() {
+		stf.Close()
+		os.Remove(opts.Path)
+	}()
+
+	workers, n := 8, 300
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(2)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < n; i++ {
+				if err := stf.Add([]byte(fmt.Sprintf("key%d-%d", w, i))); err != nil {
+					t.Errorf("Expected no error, got %v", err)
+					return
+				}
+			}
+		}(w)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < n; i++ {
+				stf.Contains([]byte(fmt.Sprintf("key%d-%d", w, i)))
+				stf.Stats()
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	if err := stf.Add([]byte("last")); err != nil || !stf.Contains([]byte("last")) {
+		t.Errorf("Expected the last key to be found, got error %v", err)
+	}
+	if stf.Count() != workers*n+1 {
+		t.Errorf("Expected count to be %d, got %d", workers*n+1, stf.Count())
+	}
+}
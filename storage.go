@@ -1,10 +1,88 @@
 package sprout
 
+// Store is the persistent store attached to a filter, to keep the values of the keys added to the filter.
+// It can be implemented outside of sprout, and checked with the conformance tests in the storetest package.
 type Store interface {
-	open() error
-	Close() error
+	// Get returns the value of the key, or nil if the key is not in the store
 	Get(key []byte) ([]byte, error)
+
+	// Put sets the value of the key
 	Put(key, value []byte) error
-	isReady() bool
+
+	// Delete removes the key from the store. Deleting a missing key is not an error.
+	Delete(key []byte) error
+
+	// Has returns true if the key is in the store
+	Has(key []byte) (bool, error)
+
+	// Close closes the store
+	Close() error
+}
+
+// ReadyStore is implemented by stores that can report whether they are ready to use.
+// A filter does not use a store that is not ready.
+type ReadyStore interface {
+	Store
+	IsReady() bool
+}
+
+// IterableStore is implemented by stores that can iterate over their keys,
+// so that a filter can be rebuilt from the store with RebuildFromStore.
+//
+// The key and value passed to fn are only valid until fn returns, and must be copied to be retained.
+// Iteration stops at the first error returned by fn, which is returned to the caller.
+type IterableStore interface {
+	Store
+
+	// ForEach calls fn for every key in the store, in key order
+	ForEach(fn func(key, val []byte) error) error
+
+	// Scan calls fn for every key in the store starting with prefix, in key order
+	Scan(prefix []byte, fn func(key, val []byte) error) error
+}
+
+// BatchStore is implemented by stores that can put many keys in a single transaction
+type BatchStore interface {
+	Store
+
+	// PutBatch sets the value of each key, vals[i] being the value of keys[i]
+	PutBatch(keys, vals [][]byte) error
+}
+
+// DBStore is implemented by stores that expose their underlying database
+type DBStore interface {
+	Store
 	DB() interface{}
 }
+
+// storeReady returns true if the store can be used
+func storeReady(store Store) bool {
+	if store == nil {
+		return false
+	}
+	if s, ok := store.(ReadyStore); ok {
+		return s.IsReady()
+	}
+	return true
+}
+
+// putBatch puts the keys in the store, in a single transaction if the store supports it
+func putBatch(store Store, keys, vals [][]byte) error {
+	if s, ok := store.(BatchStore); ok {
+		return s.PutBatch(keys, vals)
+	}
+	for i := range keys {
+		if err := store.Put(keys[i], vals[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// storeDB returns the underlying database of the store, or the store itself if it does not expose one
+func storeDB(store Store) interface{} {
+	if s, ok := store.(DBStore); ok {
+		return s.DB()
+	}
+	return store
+}
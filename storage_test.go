@@ -0,0 +1,71 @@
+package sprout_test
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/dsa0x/sprout"
+	"github.com/dsa0x/sprout/storetest"
+)
+
+func TestBoltStore_Conformance(t *testing.T) {
+	storetest.TestStore(t, func(t *testing.T) sprout.Store {
+		return sprout.NewBolt(filepath.Join(t.TempDir(), "bolt.db"), 0600)
+	})
+}
+
+func TestBadgerStore_Conformance(t *testing.T) {
+	storetest.TestStore(t, func(t *testing.T) sprout.Store {
+		opts := badger.DefaultOptions(filepath.Join(t.TempDir(), "badger.db")).WithLogger(nil)
+		return sprout.NewBadger(opts)
+	})
+}
+
+// mapStore is a store implemented outside of the sprout package
+type mapStore struct {
+	mu   sync.RWMutex
+	data map[string][]byte
+}
+
+func (s *mapStore) Get(key []byte) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	val, ok := s.data[string(key)]
+	if !ok {
+		return nil, nil
+	}
+	return append([]byte{}, val...), nil
+}
+
+func (s *mapStore) Put(key, value []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data[string(key)] = append([]byte{}, value...)
+	return nil
+}
+
+func (s *mapStore) Delete(key []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.data, string(key))
+	return nil
+}
+
+func (s *mapStore) Has(key []byte) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.data[string(key)]
+	return ok, nil
+}
+
+func (s *mapStore) Close() error {
+	return nil
+}
+
+func TestExternalStore_Conformance(t *testing.T) {
+	storetest.TestStore(t, func(t *testing.T) sprout.Store {
+		return &mapStore{data: map[string][]byte{}}
+	})
+}
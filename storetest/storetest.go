@@ -0,0 +1,312 @@
+// Package storetest implements conformance tests for sprout stores.
+//
+// A store implemented outside of sprout can be checked by calling TestStore from a test:
+//
+//	func TestMyStore(t *testing.T) {
+//		storetest.TestStore(t, func(t *testing.T) sprout.Store {
+//			return NewMyStore(t.TempDir())
+//		})
+//	}
+package storetest
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/dsa0x/sprout"
+)
+
+// TestStore runs the conformance tests against the stores created by newStore.
+// newStore is called once per test, and must return a new empty store. The store is closed by the tests.
+func TestStore(t *testing.T, newStore func(t *testing.T) sprout.Store) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, store sprout.Store)
+	}{
+		{"it gets the previously inserted value", testPutGet},
+		{"it returns nil for a missing key", testGetMissing},
+		{"it overwrites an existing value", testOverwrite},
+		{"it does not alias the values", testNoAliasing},
+		{"it deletes a key", testDelete},
+		{"it ignores deleting a missing key", testDeleteMissing},
+		{"it reports whether it has a key", testHas},
+		{"it stores binary keys and values", testBinary},
+		{"it stores many keys", testManyKeys},
+		{"it is safe for concurrent use", testConcurrent},
+		{"it is ready after it is created", testReady},
+		{"it iterates over its keys", testForEach},
+		{"it scans the keys with a prefix", testScan},
+		{"it puts a batch of keys", testPutBatch},
+		{"it can be attached to a filter", testFilter},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newStore(t)
+			defer func() {
+				if err := store.Close(); err != nil {
+					t.Errorf("Expected no error when the store is closed, got %v", err)
+				}
+			}()
+			tt.fn(t, store)
+		})
+	}
+}
+
+func mustPut(t *testing.T, store sprout.Store, key, val []byte) {
+	t.Helper()
+	if err := store.Put(key, val); err != nil {
+		t.Fatalf("Expected no error when a value is put in the store, got %v", err)
+	}
+}
+
+func assertGet(t *testing.T, store sprout.Store, key, expected []byte) {
+	t.Helper()
+	val, err := store.Get(key)
+	if err != nil {
+		t.Fatalf("Expected no error when a value is retrieved from the store, got %v", err)
+	}
+	if expected == nil && val != nil {
+		t.Errorf("Expected nil value for key %q, got %q", key, val)
+	}
+	if !bytes.Equal(val, expected) {
+		t.Errorf("Expected value %q for key %q, got %q", expected, key, val)
+	}
+}
+
+func testPutGet(t *testing.T, store sprout.Store) {
+	mustPut(t, store, []byte("foo"), []byte("bar"))
+	assertGet(t, store, []byte("foo"), []byte("bar"))
+}
+
+func testGetMissing(t *testing.T, store sprout.Store) {
+	mustPut(t, store, []byte("foo"), []byte("bar"))
+	assertGet(t, store, []byte("baz"), nil)
+}
+
+func testOverwrite(t *testing.T, store sprout.Store) {
+	mustPut(t, store, []byte("foo"), []byte("bar"))
+	mustPut(t, store, []byte("foo"), []byte("baz"))
+	assertGet(t, store, []byte("foo"), []byte("baz"))
+}
+
+func testNoAliasing(t *testing.T, store sprout.Store) {
+	key, val := []byte("foo"), []byte("bar")
+	mustPut(t, store, key, val)
+
+	// the store must not keep references to the caller's slices
+	copy(key, "xxx")
+	copy(val, "xxx")
+	assertGet(t, store, []byte("foo"), []byte("bar"))
+
+	// and the caller must be able to modify the returned value
+	got, err := store.Get([]byte("foo"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	copy(got, "yyy")
+	for i := 0; i < 100; i++ {
+		mustPut(t, store, []byte(fmt.Sprintf("key%d", i)), []byte(fmt.Sprintf("val%d", i)))
+	}
+	assertGet(t, store, []byte("foo"), []byte("bar"))
+}
+
+func testDelete(t *testing.T, store sprout.Store) {
+	mustPut(t, store, []byte("foo"), []byte("bar"))
+	mustPut(t, store, []byte("baz"), []byte("qux"))
+	if err := store.Delete([]byte("foo")); err != nil {
+		t.Fatalf("Expected no error when a key is deleted, got %v", err)
+	}
+	assertGet(t, store, []byte("foo"), nil)
+	assertGet(t, store, []byte("baz"), []byte("qux"))
+
+	// a deleted key can be put again
+	mustPut(t, store, []byte("foo"), []byte("bar2"))
+	assertGet(t, store, []byte("foo"), []byte("bar2"))
+}
+
+func testDeleteMissing(t *testing.T, store sprout.Store) {
+	if err := store.Delete([]byte("foo")); err != nil {
+		t.Errorf("Expected no error when a missing key is deleted, got %v", err)
+	}
+}
+
+func testHas(t *testing.T, store sprout.Store) {
+	mustPut(t, store, []byte("foo"), []byte("bar"))
+	mustPut(t, store, []byte("empty"), []byte{})
+
+	for key, expected := range map[string]bool{"foo": true, "empty": true, "baz": false} {
+		found, err := store.Has([]byte(key))
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if found != expected {
+			t.Errorf("Expected Has(%q) to be %v, got %v", key, expected, found)
+		}
+	}
+
+	if err := store.Delete([]byte("foo")); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if found, err := store.Has([]byte("foo")); err != nil || found {
+		t.Errorf("Expected deleted key to not be found, got %v; error: %v", found, err)
+	}
+}
+
+func testBinary(t *testing.T, store sprout.Store) {
+	key := []byte{0, 1, 2, 0xff, 0}
+	val := []byte{0xff, 0, 0, 1}
+	mustPut(t, store, key, val)
+	assertGet(t, store, key, val)
+	assertGet(t, store, key[:4], nil)
+}
+
+func testManyKeys(t *testing.T, store sprout.Store) {
+	n := 1000
+	for i := 0; i < n; i++ {
+		mustPut(t, store, []byte(fmt.Sprintf("key%d", i)), []byte(fmt.Sprintf("val%d", i)))
+	}
+	for i := 0; i < n; i++ {
+		assertGet(t, store, []byte(fmt.Sprintf("key%d", i)), []byte(fmt.Sprintf("val%d", i)))
+	}
+}
+
+func testConcurrent(t *testing.T, store sprout.Store) {
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				key := []byte(fmt.Sprintf("key%d-%d", g, i))
+				if err := store.Put(key, key); err != nil {
+					errs <- err
+					return
+				}
+				if val, err := store.Get(key); err != nil || !bytes.Equal(val, key) {
+					errs <- fmt.Errorf("expected value %q, got %q; error: %v", key, val, err)
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func testReady(t *testing.T, store sprout.Store) {
+	if s, ok := store.(sprout.ReadyStore); ok && !s.IsReady() {
+		t.Errorf("Expected store to be ready")
+	}
+}
+
+func testForEach(t *testing.T, store sprout.Store) {
+	s, ok := store.(sprout.IterableStore)
+	if !ok {
+		t.Skip("store does not implement sprout.IterableStore")
+	}
+	for _, key := range []string{"b", "a", "c"} {
+		mustPut(t, store, []byte(key), []byte("val"+key))
+	}
+
+	var keys []string
+	err := s.ForEach(func(key, val []byte) error {
+		if string(val) != "val"+string(key) {
+			t.Errorf("Expected value %q for key %q, got %q", "val"+string(key), key, val)
+		}
+		keys = append(keys, string(key))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if fmt.Sprint(keys) != "[a b c]" {
+		t.Errorf("Expected keys [a b c], got %v", keys)
+	}
+
+	// an error stops the iteration
+	errStop := fmt.Errorf("stop")
+	n := 0
+	err = s.ForEach(func(key, val []byte) error {
+		n++
+		return errStop
+	})
+	if err != errStop || n != 1 {
+		t.Errorf("Expected iteration to stop with the returned error, got %v after %d keys", err, n)
+	}
+}
+
+func testScan(t *testing.T, store sprout.Store) {
+	s, ok := store.(sprout.IterableStore)
+	if !ok {
+		t.Skip("store does not implement sprout.IterableStore")
+	}
+	for _, key := range []string{"user:2", "item:1", "user:1", "users", "a"} {
+		mustPut(t, store, []byte(key), []byte(key))
+	}
+
+	var keys []string
+	err := s.Scan([]byte("user:"), func(key, val []byte) error {
+		keys = append(keys, string(key))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if fmt.Sprint(keys) != "[user:1 user:2]" {
+		t.Errorf("Expected keys [user:1 user:2], got %v", keys)
+	}
+}
+
+func testPutBatch(t *testing.T, store sprout.Store) {
+	s, ok := store.(sprout.BatchStore)
+	if !ok {
+		t.Skip("store does not implement sprout.BatchStore")
+	}
+	keys, vals := make([][]byte, 100), make([][]byte, 100)
+	for i := range keys {
+		keys[i] = []byte(fmt.Sprintf("key%d", i))
+		vals[i] = []byte(fmt.Sprintf("val%d", i))
+	}
+	if err := s.PutBatch(keys, vals); err != nil {
+		t.Fatalf("Expected no error when a batch is put in the store, got %v", err)
+	}
+	for i := range keys {
+		assertGet(t, store, keys[i], vals[i])
+	}
+}
+
+func testFilter(t *testing.T, store sprout.Store) {
+	bf, err := sprout.NewBloomE(&sprout.BloomOptions{
+		Err_rate: 0.01,
+		Capacity: 100,
+		Database: store,
+		Path:     filepath.Join(t.TempDir(), "bloom.db"),
+	})
+	if err != nil {
+		t.Fatalf("Expected no error creating the filter, got %v", err)
+	}
+	defer bf.Close()
+
+	if err := bf.Put([]byte("foo"), []byte("bar")); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	val, err := bf.GetE([]byte("foo"))
+	if err != nil || string(val) != "bar" {
+		t.Errorf("Expected value bar, got %s; error: %v", val, err)
+	}
+
+	if err := bf.Delete([]byte("foo")); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if val, err := bf.GetE([]byte("foo")); err != nil || val != nil {
+		t.Errorf("Expected deleted key to have no value, got %s; error: %v", val, err)
+	}
+}